@@ -20,12 +20,14 @@ var (
 	numRooms   = flag.Int("rooms", 10, "number of rooms to simulate")
 	duration   = flag.Duration("duration", 30*time.Second, "test duration")
 	verbose    = flag.Bool("v", false, "verbose logging per bot")
+	quickMatch = flag.Bool("quick", false, "use find_match quick-match instead of create/join")
 )
 
 // --- Message types (mirrors server) ---
 type Message struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
+	Seq     uint64      `json:"seq,omitempty"`
 }
 
 type RoomInfo struct {
@@ -33,6 +35,7 @@ type RoomInfo struct {
 	Name        string `json:"name"`
 	PlayerCount int    `json:"playerCount"`
 	MaxPlayers  int    `json:"maxPlayers"`
+	Reserved    int    `json:"reserved"`
 }
 
 // --- Metrics ---
@@ -77,6 +80,9 @@ type Bot struct {
 
 	lastShotTime time.Time
 	lastPingSent time.Time
+
+	// Arena size from the last game state; the server's defaults until then
+	width, height float64
 }
 
 func newBot(id int, role string) *Bot {
@@ -84,6 +90,8 @@ func newBot(id int, role string) *Bot {
 		id:     id,
 		role:   role,
 		state:  StateConnecting,
+		width:  1300,
+		height: 650,
 		sendCh: make(chan Message, 128),
 		recvCh: make(chan Message, 256),
 		doneCh: make(chan struct{}),
@@ -228,6 +236,19 @@ func (b *Bot) run(wg *sync.WaitGroup, stopCh <-chan struct{}) {
 	}
 }
 
+// considerRooms makes a joiner join the first room with exactly 1 player.
+func (b *Bot) considerRooms(rooms []RoomInfo) bool {
+	for _, r := range rooms {
+		if r.PlayerCount == 1 && r.Reserved == 0 {
+			b.logf("Joining room with 1 player: %s", r.ID[:8])
+			b.send("join_room", map[string]interface{}{"roomId": r.ID})
+			b.state = StateInRoom
+			return true
+		}
+	}
+	return false
+}
+
 func (b *Bot) handleMessage(msg Message) error {
 	switch msg.Type {
 	case "welcome":
@@ -249,31 +270,39 @@ func (b *Bot) handleMessage(msg Message) error {
 		json.Unmarshal(data, &rooms)
 		b.logf("Room list: %d rooms", len(rooms))
 
-		if b.role == "creator" {
+		if *quickMatch {
+			b.logf("Queueing for quick match")
+			b.send("find_match", map[string]interface{}{})
+			b.state = StateInRoom
+		} else if b.role == "creator" {
 			b.actOnLobby()
-		} else {
-			// Joiner: find a room with exactly 1 player
-			joined := false
-			for _, r := range rooms {
-				if r.PlayerCount == 1 {
-					b.logf("Joining room with 1 player: %s", r.ID[:8])
-					b.send("join_room", map[string]interface{}{"roomId": r.ID})
-					b.state = StateInRoom
-					joined = true
-					break
-				}
-			}
-			if !joined {
-				b.logf("No suitable room yet, waiting for next room_list...")
-			}
+		} else if !b.considerRooms(rooms) {
+			b.logf("No suitable room yet, waiting for room updates...")
 		}
 
+	case "room_added", "room_updated":
+		// Incremental updates; joiners watch for a room with a free seat
+		if b.state != StateLobby || b.role != "joiner" || *quickMatch {
+			return nil
+		}
+		data, _ := json.Marshal(msg.Payload)
+		var room RoomInfo
+		json.Unmarshal(data, &room)
+		b.considerRooms([]RoomInfo{room})
+
 	case "gameState":
 		payload, ok := msg.Payload.(map[string]interface{})
 		if !ok {
 			return nil
 		}
 
+		if w, ok := payload["canvasWidth"].(float64); ok && w > 0 {
+			b.width = w
+		}
+		if h, ok := payload["canvasHeight"].(float64); ok && h > 0 {
+			b.height = h
+		}
+
 		state, _ := payload["state"].(string)
 		switch state {
 		case "waiting":
@@ -283,7 +312,7 @@ func (b *Bot) handleMessage(msg Message) error {
 				b.logf("Sent ready")
 			}
 
-		case "in_progress":
+		case "in_progress", "overtime":
 			if b.state != StatePlaying {
 				b.state = StatePlaying
 				b.readySent = false
@@ -318,6 +347,11 @@ func (b *Bot) handleMessage(msg Message) error {
 			}
 		}
 
+	case "match_found":
+		payload, _ := msg.Payload.(map[string]interface{})
+		code, _ := payload["roomCode"].(string)
+		b.logf("Match found: room %s", code)
+
 	case "error":
 		payload, _ := msg.Payload.(string)
 		b.logf("Server error: %s", payload)
@@ -352,8 +386,8 @@ func (b *Bot) maybeSendShoot() {
 	}
 	// Shoot at random position
 	b.send("shoot", map[string]interface{}{
-		"x": rand.Float64() * 1300,
-		"y": rand.Float64() * 650,
+		"x": rand.Float64() * b.width,
+		"y": rand.Float64() * b.height,
 	})
 	b.lastShotTime = time.Now()
 }
@@ -367,7 +401,8 @@ func main() {
 	fmt.Printf("╚════════════════════════════════════════╝\n")
 	fmt.Printf("Server  : %s\n", *serverAddr)
 	fmt.Printf("Rooms   : %d (= %d players)\n", *numRooms, *numRooms*2)
-	fmt.Printf("Duration: %s\n\n", *duration)
+	fmt.Printf("Duration: %s\n", *duration)
+	fmt.Printf("Mode    : %s\n\n", map[bool]string{false: "create/join", true: "quick match"}[*quickMatch])
 
 	stopCh := make(chan struct{})
 	var wg sync.WaitGroup
@@ -383,7 +418,7 @@ func main() {
 	}
 
 	// Wait a short time so first creators have rooms ready before joiners flood in.
-	// Joiners will keep retrying on each room_list update anyway.
+	// Joiners will keep retrying on each room list update anyway.
 	time.Sleep(300 * time.Millisecond)
 
 	for i := 0; i < *numRooms; i++ {
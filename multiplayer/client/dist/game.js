@@ -2,6 +2,10 @@ import { Canvas } from "./canvas.js";
 import { Player } from "./player.js";
 import { Bullet } from "./bullet.js";
 import { Vector2D } from "./vector2d.js";
+// Rooms per list_rooms page; as many rows as fit in the lobby panel
+const ROOM_PAGE_SIZE = 6;
+// Wheel travel that turns the page
+const ROOM_PAGE_WHEEL = 120;
 export class Game {
     constructor() {
         this.ws = null;
@@ -13,10 +17,19 @@ export class Game {
         this.amIReady = false;
         this.timeRemaining = 0;
         this.shootCooldownMax = 2;
+        this.countdown = 0;
+        this.arena = null;
+        this.autoStartIn = 0;
         // --- DATA ---
         this.players = new Map();
         this.bullets = new Map();
+        // The page of rooms the server sends for our list_rooms query
         this.rooms = [];
+        this.roomTotal = 0;
+        this.roomCursor = "";
+        this.nextRoomCursor = "";
+        // Cursors of the pages before this one, for paging back
+        this.prevRoomCursors = [];
         // --- INPUT & UI ---
         this.keysPressed = {};
         this.mousePosition = null;
@@ -25,15 +38,26 @@ export class Game {
         this.createRoomButtonArea = null;
         this.leaveRoomButtonArea = null;
         this._waitingCopyArea = null;
-        // --- LOBBY SCROLL & SEARCH ---
-        this.lobbyScrollOffset = 0;
+        // --- LOBBY PAGING & SEARCH ---
+        this.wheelTravel = 0;
         this.searchQuery = "";
         this.searchInput = null;
         this.copyToast = null;
         this.currentRoomId = null;
+        this.currentRoomCode = null;
+        // Room code from an invite link (/r/{code} redirects to /?room={code})
+        this.pendingJoinCode = null;
+        // Match to watch from a replay link (/?replay={matchId})
+        this.pendingReplayId = null;
+        // Set while watching a replay instead of playing
+        this.replay = null;
+        // When the last replay_status arrived, in performance.now() time
+        this.replayAt = 0;
         // --- ANIMATION ---
         this.animTime = 0;
         this.canvas = new Canvas();
+        this.pendingJoinCode = new URLSearchParams(location.search).get("room");
+        this.pendingReplayId = new URLSearchParams(location.search).get("replay");
         this.connectWebSocket();
         this.setupInputHandlers();
         this.setupSearchInput();
@@ -58,8 +82,16 @@ export class Game {
       letter-spacing: 1px;
     `;
         const syncQuery = () => {
-            this.searchQuery = input.value.toLowerCase();
-            this.lobbyScrollOffset = 0;
+            const query = input.value.trim().toLowerCase();
+            if (query === this.searchQuery)
+                return;
+            this.searchQuery = query;
+            // The server does the searching; wait for a pause in typing
+            clearTimeout(this.searchTimer);
+            this.searchTimer = window.setTimeout(() => {
+                this.prevRoomCursors = [];
+                this.requestRooms("");
+            }, 200);
         };
         input.addEventListener("input", syncQuery);
         // keyup catches Backspace/Delete on some browsers where `input` event misfires
@@ -89,7 +121,11 @@ export class Game {
     // --- NETWORK ---
     connectWebSocket() {
         const protocol = location.protocol === "https:" ? "wss:" : "ws:";
-        this.ws = new WebSocket(`${protocol}//${location.host}/ws`);
+        // Guests keep the session the server hands out, so chat mutes and
+        // kick bans follow them across reconnects
+        const guest = localStorage.getItem("guestToken");
+        const query = guest ? `?guest=${encodeURIComponent(guest)}` : "";
+        this.ws = new WebSocket(`${protocol}//${location.host}/ws${query}`);
         this.ws.onopen = () => {
             console.log("Connected to game server.");
             this.clientState = "lobby";
@@ -114,14 +150,27 @@ export class Game {
         switch (msg.type) {
             case "welcome":
                 this.myPlayerId = msg.payload.playerId;
+                if (msg.payload.guestToken) {
+                    localStorage.setItem("guestToken", msg.payload.guestToken);
+                }
+                // From now on the server sends our page instead of the whole list
+                this.requestRooms("");
+                break;
+            // Room lists only go to clients in the lobby, which includes anyone
+            // watching a replay; those keep watching until they stop
+            case "room_page":
+                this.showRoomPage(msg.payload);
+                if (!this.replay)
+                    this.enterLobby();
                 break;
             case "room_list":
-                this.clientState = "lobby";
-                this.currentRoomId = null;
-                this.rooms = msg.payload || [];
-                this.players.clear();
-                this.bullets.clear();
-                this.keysPressed = {};
+                // Sent on connect, before our list_rooms query takes over
+                if (!this.replay)
+                    this.enterLobby();
+                break;
+            case "replay_status":
+                this.replay = msg.payload;
+                this.replayAt = performance.now();
                 break;
             case "error":
                 console.error("Server error:", (_a = msg.payload) === null || _a === void 0 ? void 0 : _a.message);
@@ -130,10 +179,17 @@ export class Game {
                 this.clientState = "in_game";
                 const sp = msg.payload;
                 this.currentRoomId = sp.roomId || null;
+                this.currentRoomCode = sp.roomCode || null;
                 this.roomState = sp.state;
                 this.winnerId = sp.winnerId || null;
                 this.timeRemaining = (_b = sp.timeRemaining) !== null && _b !== void 0 ? _b : 0;
                 this.shootCooldownMax = (_c = sp.shootCooldownMax) !== null && _c !== void 0 ? _c : 2;
+                this.countdown = sp.countdown || 0;
+                this.arena = sp.arena || null;
+                if (sp.canvasWidth && sp.canvasHeight) {
+                    this.canvas.resize(sp.canvasWidth, sp.canvasHeight);
+                }
+                this.autoStartIn = sp.autoStartIn || 0;
                 this.amIReady = this.myPlayerId
                     ? sp.readyPlayers[this.myPlayerId] || false
                     : false;
@@ -160,6 +216,54 @@ export class Game {
                 break;
         }
     }
+    // The server sends a room list whenever we arrive in the lobby
+    enterLobby() {
+        this.clientState = "lobby";
+        this.currentRoomId = null;
+        this.currentRoomCode = null;
+        this.players.clear();
+        this.bullets.clear();
+        this.keysPressed = {};
+        // Follow an invite link once we're in the lobby
+        if (this.pendingJoinCode) {
+            this.sendWsMessage("join_room", { roomId: this.pendingJoinCode });
+            this.pendingJoinCode = null;
+            history.replaceState(null, "", "/");
+        }
+        else if (this.pendingReplayId) {
+            this.sendWsMessage("watch_replay", { matchId: this.pendingReplayId });
+            this.pendingReplayId = null;
+            history.replaceState(null, "", "/");
+        }
+    }
+    // Ask for a page of rooms matching the search. The server keeps sending
+    // that page as rooms change, until we ask for another.
+    requestRooms(cursor) {
+        this.sendWsMessage("list_rooms", { query: this.searchQuery, cursor, limit: ROOM_PAGE_SIZE });
+    }
+    showRoomPage(page) {
+        var _a, _b;
+        this.rooms = page.rooms || [];
+        this.roomTotal = page.total;
+        this.roomCursor = (_a = page.cursor) !== null && _a !== void 0 ? _a : "";
+        this.nextRoomCursor = (_b = page.nextCursor) !== null && _b !== void 0 ? _b : "";
+        // Rooms closed under us and left this page empty; go back one
+        if (this.rooms.length === 0 && this.prevRoomCursors.length > 0) {
+            this.requestRooms(this.prevRoomCursors.pop());
+        }
+    }
+    nextRoomPage() {
+        if (!this.nextRoomCursor)
+            return;
+        this.prevRoomCursors.push(this.roomCursor);
+        this.requestRooms(this.nextRoomCursor);
+        this.nextRoomCursor = ""; // until the page arrives
+    }
+    prevRoomPage() {
+        if (this.prevRoomCursors.length === 0)
+            return;
+        this.requestRooms(this.prevRoomCursors.pop());
+    }
     sendWsMessage(type, payload) {
         if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(JSON.stringify({ type, payload }));
@@ -169,13 +273,16 @@ export class Game {
     setupInputHandlers() {
         var _a, _b, _c;
         window.addEventListener("keydown", (e) => {
-            if (this.clientState === "in_game") {
+            if (this.replay) {
+                this.handleReplayKey(e.key);
+            }
+            else if (this.clientState === "in_game") {
                 this.keysPressed[e.key.toLowerCase()] = true;
                 this.updatePlayerInput();
             }
         });
         window.addEventListener("keyup", (e) => {
-            if (this.clientState === "in_game") {
+            if (this.clientState === "in_game" && !this.replay) {
                 this.keysPressed[e.key.toLowerCase()] = false;
                 this.updatePlayerInput();
             }
@@ -189,7 +296,15 @@ export class Game {
         });
         (_b = this.canvas.getCanvas()) === null || _b === void 0 ? void 0 : _b.addEventListener("wheel", (e) => {
             if (this.clientState === "lobby") {
-                this.lobbyScrollOffset = Math.max(0, this.lobbyScrollOffset + e.deltaY * 0.5);
+                this.wheelTravel += e.deltaY;
+                if (this.wheelTravel >= ROOM_PAGE_WHEEL) {
+                    this.wheelTravel = 0;
+                    this.nextRoomPage();
+                }
+                else if (this.wheelTravel <= -ROOM_PAGE_WHEEL) {
+                    this.wheelTravel = 0;
+                    this.prevRoomPage();
+                }
                 e.preventDefault();
             }
         }, { passive: false });
@@ -209,11 +324,13 @@ export class Game {
             this.sendWsMessage("create_room", {});
             return;
         }
-        // Copy room ID chip
+        // Copy room code chip — copies the invite link
         for (const id in this.copyIdAreas) {
             if (this.inArea(pos, this.copyIdAreas[id])) {
-                navigator.clipboard.writeText(id).then(() => {
-                    this.copyToast = { text: "Room ID copied!", expiry: Date.now() + 2000 };
+                const room = this.rooms.find((r) => r.id === id);
+                const link = room ? `${location.origin}/r/${room.code}` : id;
+                navigator.clipboard.writeText(link).then(() => {
+                    this.copyToast = { text: "Invite link copied!", expiry: Date.now() + 2000 };
                 });
                 return;
             }
@@ -228,22 +345,33 @@ export class Game {
     }
     handleInGameClick(pos) {
         if (this.leaveRoomButtonArea && this.inArea(pos, this.leaveRoomButtonArea)) {
-            this.sendWsMessage("leave_room", {});
+            this.sendWsMessage(this.replay ? "stop_replay" : "leave_room", {});
+            // The room list the server answers with takes us back to the lobby
+            this.replay = null;
+            return;
+        }
+        if (this.replay) {
+            this.sendWsMessage("replay_control", { action: this.replay.paused ? "play" : "pause" });
             return;
         }
         switch (this.roomState) {
             case "waiting":
-                // Copy room ID chip
-                if (this._waitingCopyArea && this.inArea(pos, this._waitingCopyArea) && this.currentRoomId) {
-                    navigator.clipboard.writeText(this.currentRoomId).then(() => {
-                        this.copyToast = { text: "Room ID copied!", expiry: Date.now() + 2000 };
+                // Copy room code chip — copies the invite link
+                if (this._waitingCopyArea && this.inArea(pos, this._waitingCopyArea) && this.currentRoomCode) {
+                    navigator.clipboard.writeText(`${location.origin}/r/${this.currentRoomCode}`).then(() => {
+                        this.copyToast = { text: "Invite link copied!", expiry: Date.now() + 2000 };
                     });
                     return;
                 }
-                if (!this.amIReady)
-                    this.sendWsMessage("ready", {});
+                this.sendWsMessage(this.amIReady ? "unready" : "ready", {});
+                break;
+            case "countdown":
+                // Clicking during the countdown backs out of it
+                if (this.amIReady)
+                    this.sendWsMessage("unready", {});
                 break;
             case "in_progress":
+            case "overtime":
                 if (!this.myPlayerId || !this.mousePosition)
                     return;
                 const me = this.players.get(this.myPlayerId);
@@ -260,8 +388,33 @@ export class Game {
         return pos.x >= area.x && pos.x <= area.x + area.width
             && pos.y >= area.y && pos.y <= area.y + area.height;
     }
+    // Space pauses, 1-4 pick the speed, arrows skip 5s back or forward
+    handleReplayKey(key) {
+        const r = this.replay;
+        const speeds = { "1": 0.5, "2": 1, "3": 2, "4": 4 };
+        if (key === " ") {
+            this.sendWsMessage("replay_control", { action: r.paused ? "play" : "pause" });
+        }
+        else if (key in speeds) {
+            this.sendWsMessage("replay_control", { action: "speed", speed: speeds[key] });
+        }
+        else if (key === "ArrowLeft" || key === "ArrowRight") {
+            const step = (key === "ArrowLeft" ? -5 : 5) * r.tickRate;
+            const tick = Math.min(Math.max(this.replayTick() + step, 0), r.lastTick);
+            this.sendWsMessage("replay_control", { action: "seek", tick: Math.round(tick) });
+        }
+    }
+    // Where the replay is now: the last reported tick, moved on by the time
+    // since while it plays
+    replayTick() {
+        const r = this.replay;
+        if (r.paused)
+            return r.tick;
+        const elapsed = (performance.now() - this.replayAt) / 1000;
+        return Math.min(r.tick + elapsed * r.tickRate * r.speed, r.lastTick);
+    }
     updatePlayerInput() {
-        if (!this.myPlayerId || this.clientState !== "in_game")
+        if (!this.myPlayerId || this.clientState !== "in_game" || this.replay)
             return;
         let x = 0, y = 0;
         if (this.keysPressed["w"])
@@ -374,14 +527,11 @@ export class Game {
             this.positionSearchInput(SEARCH_X + ICON_W, SEARCH_Y, SEARCH_W - ICON_W, SEARCH_H);
         }
         // Section label
-        const filteredRooms = this.rooms.filter(r => !this.searchQuery ||
-            r.id.toLowerCase().includes(this.searchQuery) ||
-            r.name.toLowerCase().includes(this.searchQuery));
         const labelStr = this.searchQuery
-            ? `─── ${filteredRooms.length} RESULT${filteredRooms.length !== 1 ? "S" : ""} ───`
-            : `─── ACTIVE ROOMS (${this.rooms.length}) ───`;
+            ? `─── ${this.roomTotal} RESULT${this.roomTotal !== 1 ? "S" : ""} ───`
+            : `─── ACTIVE ROOMS (${this.roomTotal}) ───`;
         this.canvas.drawText(labelStr, new Vector2D(cx, 178), "#6a8a50", "13px monospace", "center");
-        // ── Scrollable room list ─────────────────────────────────
+        // ── Room list, one server page at a time ─────────────────
         const roomW = 680, roomH = 58;
         const roomX = cx - roomW / 2;
         const listTop = 186;
@@ -392,14 +542,14 @@ export class Game {
         ctx.beginPath();
         ctx.rect(roomX - 5, listTop, roomW + 10, listHeight);
         ctx.clip();
-        let yOff = listTop - this.lobbyScrollOffset;
-        if (filteredRooms.length === 0) {
+        let yOff = listTop;
+        if (this.rooms.length === 0) {
             const msg = this.searchQuery ? "No rooms match your search." : "No rooms. Be the first to create one!";
             ctx.fillStyle = "rgba(255,255,255,0.03)";
             ctx.fillRect(roomX, yOff, roomW, 70);
             this.canvas.drawText(msg, new Vector2D(cx, yOff + 44), "#445534", "15px monospace", "center");
         }
-        filteredRooms.forEach((room) => {
+        this.rooms.forEach((room) => {
             const isFull = room.playerCount >= room.maxPlayers;
             const rowBottom = yOff + roomH;
             // Only register click areas for visible rows
@@ -417,8 +567,8 @@ export class Game {
             ctx.fill();
             // Room name
             this.canvas.drawText(room.name, new Vector2D(roomX + 38, yOff + 22), isFull ? "#886666" : "#b8d090", "bold 15px monospace");
-            // Short room ID chip — click to copy
-            const shortId = room.id.substring(0, 8) + "…";
+            // Room code chip — click to copy invite link
+            const shortId = room.code;
             const chipX = roomX + 38, chipY = yOff + 30, chipW = 110, chipH = 18;
             ctx.fillStyle = "rgba(40,70,30,0.7)";
             ctx.fillRect(chipX, chipY, chipW, chipH);
@@ -443,22 +593,15 @@ export class Game {
             }
             yOff += roomH + 6;
         });
-        // ── Scroll indicator ─────────────────────────────────────
-        const totalContentH = filteredRooms.length * (roomH + 6);
-        if (totalContentH > listHeight) {
-            const trackH = listHeight;
-            const thumbH = Math.max(30, (listHeight / totalContentH) * trackH);
-            const thumbY = listTop + (this.lobbyScrollOffset / (totalContentH - listHeight)) * (trackH - thumbH);
-            const trackX = roomX + roomW + 6;
-            ctx.fillStyle = "rgba(255,255,255,0.06)";
-            ctx.fillRect(trackX, listTop, 6, trackH);
-            ctx.fillStyle = "#3a6a28";
-            ctx.fillRect(trackX, thumbY, 6, thumbH);
-            // Clamp scroll
-            const maxScroll = Math.max(0, totalContentH - listHeight);
-            this.lobbyScrollOffset = Math.min(this.lobbyScrollOffset, maxScroll);
-        }
         ctx.restore(); // end clip
+        // ── Page indicator ───────────────────────────────────────
+        const pages = Math.ceil(this.roomTotal / ROOM_PAGE_SIZE);
+        if (pages > 1) {
+            const page = this.prevRoomCursors.length + 1;
+            const up = this.prevRoomCursors.length > 0 ? "▲" : " ";
+            const down = this.nextRoomCursor ? "▼" : " ";
+            this.canvas.drawText(`${up}  PAGE ${page} / ${pages}  ${down}`, new Vector2D(cx, listBottom - 4), "#5a7a48", "12px monospace", "center");
+        }
         // ── Copy toast ───────────────────────────────────────────
         if (this.copyToast && Date.now() < this.copyToast.expiry) {
             const fade = (this.copyToast.expiry - Date.now()) / 2000;
@@ -499,7 +642,7 @@ export class Game {
             const labelColor = isMe ? "#c8d860" : "#e06060";
             this.canvas.drawTextShadow(label, new Vector2D(cx, by - 4), labelColor, isMe ? "#406020" : "#601010", "bold 12px monospace", "center");
             // Aiming laser — only for me
-            if (isMe && this.mousePosition && this.roomState === "in_progress") {
+            if (isMe && this.mousePosition && (this.roomState === "in_progress" || this.roomState === "overtime")) {
                 const dir = new Vector2D(this.mousePosition.x - cx, this.mousePosition.y - cy).normalize();
                 const start = new Vector2D(cx + dir.x * 30, cy + dir.y * 30);
                 const end = new Vector2D(cx + dir.x * 80, cy + dir.y * 80);
@@ -597,6 +740,42 @@ export class Game {
         const color = urgent ? `rgba(255,${Math.floor(80 * pulse)},${Math.floor(80 * pulse)},1)` : "#c8d860";
         this.canvas.drawText(`${String(Math.floor(secs / 60)).padStart(2, "0")}:${String(secs % 60).padStart(2, "0")}`, new Vector2D(cx, 33), color, `bold 20px monospace`, "center");
     }
+    drawReplayBar() {
+        const r = this.replay;
+        const tick = this.replayTick();
+        const w = this.canvas.getWidth();
+        const h = this.canvas.getHeight();
+        const ctx = this.canvas.getCtx();
+        ctx.fillStyle = "rgba(10,20,8,0.75)";
+        ctx.fillRect(0, h - 30, w, 30);
+        // Progress through the match
+        ctx.fillStyle = "#2a5a20";
+        ctx.fillRect(0, h - 30, r.lastTick > 0 ? (w * tick) / r.lastTick : 0, 3);
+        const secs = (t) => {
+            const s = Math.floor(t / r.tickRate);
+            return `${Math.floor(s / 60)}:${String(s % 60).padStart(2, "0")}`;
+        };
+        this.canvas.drawText(`REPLAY ${r.paused ? "PAUSED" : "\u25B6"} ${r.speed}x  ${secs(tick)} / ${secs(r.lastTick)}   [SPACE] pause  [1-4] speed  [\u2190/\u2192] seek`, new Vector2D(w / 2, h - 10), "#c8d860", "bold 13px monospace", "center");
+    }
+    drawOvertime() {
+        const ctx = this.canvas.getCtx();
+        const w = this.canvas.getWidth();
+        const h = this.canvas.getHeight();
+        const a = this.arena;
+        // Shade everything outside a shrinking arena
+        if (a && (a.w < w || a.h < h)) {
+            ctx.fillStyle = "rgba(60,0,0,0.55)";
+            ctx.fillRect(0, 0, w, a.y);
+            ctx.fillRect(0, a.y + a.h, w, h - a.y - a.h);
+            ctx.fillRect(0, a.y, a.x, a.h);
+            ctx.fillRect(a.x + a.w, a.y, w - a.x - a.w, a.h);
+            ctx.strokeStyle = "#ee4444";
+            ctx.lineWidth = 2;
+            ctx.strokeRect(a.x, a.y, a.w, a.h);
+        }
+        const alpha = 0.7 + 0.3 * Math.sin(this.animTime * 6);
+        this.canvas.drawText("OVERTIME", new Vector2D(w / 2, 62), `rgba(255,120,80,${alpha})`, "bold 16px monospace", "center");
+    }
     drawWaitingScreen() {
         const ctx = this.canvas.getCtx();
         const cx = this.canvas.getWidth() / 2;
@@ -613,10 +792,9 @@ export class Game {
             // Spinning dots animation
             const dots = Math.floor(this.animTime * 2) % 4;
             this.canvas.drawTextShadow("WAITING FOR OPPONENT" + ".".repeat(dots), new Vector2D(cx, cy - 10), "#c8d860", "#406020", "bold 22px monospace", "center");
-            // Show room ID chip — click to copy and send to friend
-            if (this.currentRoomId) {
+            // Show room code chip — click to copy invite link and send to friend
+            if (this.currentRoomCode) {
                 const ctx = this.canvas.getCtx();
-                const shortId = this.currentRoomId.substring(0, 8) + "…";
                 const chipW = 270, chipH = 24;
                 const chipX = cx - chipW / 2, chipY = cy + 18;
                 ctx.fillStyle = "rgba(30,60,20,0.85)";
@@ -624,19 +802,31 @@ export class Game {
                 ctx.strokeStyle = "#3a7a28";
                 ctx.lineWidth = 1;
                 ctx.strokeRect(chipX, chipY, chipW, chipH);
-                this.canvas.drawText(`📋 Room ID: ${shortId}  (click to copy)`, new Vector2D(cx, chipY + 17), "#7aaa50", "12px monospace", "center");
+                this.canvas.drawText(`📋 Room code: ${this.currentRoomCode}  (click to copy link)`, new Vector2D(cx, chipY + 17), "#7aaa50", "12px monospace", "center");
                 // Register click area
                 this._waitingCopyArea = { x: chipX, y: chipY, width: chipW, height: chipH };
             }
         }
         else if (this.amIReady) {
             this.canvas.drawTextShadow("READY — WAITING FOR ENEMY", new Vector2D(cx, cy - 10), "#c8d860", "#406020", "bold 22px monospace", "center");
-            this.canvas.drawText("Enemy is preparing...", new Vector2D(cx, cy + 30), "#557744", "15px monospace", "center");
+            this.canvas.drawText("Enemy is preparing... (click to cancel)", new Vector2D(cx, cy + 30), "#557744", "15px monospace", "center");
         }
         else {
             this.canvas.drawTextShadow("CLICK TO READY UP", new Vector2D(cx, cy - 10), "#80ee80", "#206010", `bold ${22 + 2 * Math.sin(this.animTime * 3)}px monospace`, "center");
             this.canvas.drawText("Both players must be ready to start", new Vector2D(cx, cy + 30), "#557744", "15px monospace", "center");
         }
+        if (this.autoStartIn > 0) {
+            this.canvas.drawText(`Auto-start in ${Math.ceil(this.autoStartIn)}s`, new Vector2D(cx, cy + 55), "#c8d860", "13px monospace", "center");
+        }
+    }
+    drawCountdown() {
+        const cx = this.canvas.getWidth() / 2;
+        const cy = this.canvas.getHeight() / 2;
+        const secs = Math.max(1, Math.ceil(this.countdown));
+        // Each number pops in large and shrinks through its second
+        const frac = this.countdown - Math.floor(this.countdown);
+        this.canvas.drawTextShadow(String(secs), new Vector2D(cx, cy + 30), "#ffee80", "#806010", `bold ${Math.round(80 + 60 * frac)}px monospace`, "center");
+        this.canvas.drawText("GET READY", new Vector2D(cx, cy - 70), "#c8d860", "bold 20px monospace", "center");
     }
     drawGameOver() {
         const ctx = this.canvas.getCtx();
@@ -666,17 +856,31 @@ export class Game {
                 this.drawWaitingScreen();
                 this.drawInGameUI();
                 break;
+            case "countdown":
+                this.drawPlayers();
+                this.drawInGameUI();
+                this.drawCountdown();
+                break;
             case "in_progress":
                 this.drawPlayers();
                 this.drawBullets();
                 this.drawInGameUI();
                 this.drawTimer();
                 break;
+            case "overtime":
+                this.drawOvertime();
+                this.drawPlayers();
+                this.drawBullets();
+                this.drawInGameUI();
+                this.drawTimer();
+                break;
             case "game_over":
                 this.drawGameOver();
                 this.drawInGameUI();
                 break;
         }
+        if (this.replay)
+            this.drawReplayBar();
     }
     // --- MAIN LOOP ---
     gameLoop() {
@@ -684,13 +888,10 @@ export class Game {
         this.canvas.initCanvas();
         const cx = this.canvas.getWidth() / 2;
         const cy = this.canvas.getHeight() / 2;
-        // Hide HTML search input when not in lobby
+        // Hide HTML search input when not in lobby; the search and page stay
+        // in place on the server for when we come back
         if (this.clientState !== "lobby" && this.searchInput) {
             this.searchInput.style.display = "none";
-            this.searchQuery = "";
-            if (this.searchInput)
-                this.searchInput.value = "";
-            this.lobbyScrollOffset = 0;
         }
         switch (this.clientState) {
             case "connecting":
@@ -466,6 +466,17 @@ export class Canvas {
     return this.canvas;
   }
 
+  // resize matches the canvas to the room's configured size. The default
+  // above is only used until the first game state arrives.
+  resize(width: number, height: number) {
+    if (width === this.width && height === this.height) return;
+    this.width = width;
+    this.height = height;
+    this.canvas.width = width;
+    this.canvas.height = height;
+    this._initBackgroundCache();
+  }
+
   getWidth() {
     return this.width;
   }
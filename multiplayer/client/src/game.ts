@@ -8,10 +8,12 @@ import { Vector2D } from "./vector2d.js";
 interface ServerMessage {
   type: string;
   payload: any;
+  seq?: number; // room list version, on room list messages only
 }
 
 interface ServerGameStatePayload {
   roomId: string;
+  roomCode: string;
   players: { [id: string]: PlayerState };
   bullets: { [id: string]: BulletState };
   state: string;
@@ -19,15 +21,44 @@ interface ServerGameStatePayload {
   readyPlayers: { [id: string]: boolean };
   timeRemaining: number;
   shootCooldownMax: number;
+  arena?: { x: number; y: number; w: number; h: number }; // playable area
+  canvasWidth?: number;
+  canvasHeight?: number;
+  countdown?: number; // seconds until the round starts
+  autoStartIn?: number; // seconds until the countdown starts on its own
 }
 
 interface RoomInfo {
   id: string;
+  code: string;
   name: string;
   playerCount: number;
   maxPlayers: number;
 }
 
+// One page of the lobby's list_rooms view
+interface RoomPage {
+  rooms: RoomInfo[];
+  total: number; // rooms matching the search across all pages
+  cursor?: string;
+  nextCursor?: string;
+}
+
+// Rooms per list_rooms page; as many rows as fit in the lobby panel
+const ROOM_PAGE_SIZE = 6;
+// Wheel travel that turns the page
+const ROOM_PAGE_WHEEL = 120;
+
+interface ReplayStatus {
+  matchId: string;
+  tick: number;
+  lastTick: number;
+  tickRate: number;
+  paused: boolean;
+  speed: number;
+  ended: boolean;
+}
+
 interface ClickableArea {
   x: number;
   y: number;
@@ -48,12 +79,21 @@ export class Game {
   private winnerId: string | null = null;
   private amIReady: boolean = false;
   private timeRemaining: number = 0;
+  private countdown: number = 0;
+  private arena: { x: number; y: number; w: number; h: number } | null = null;
+  private autoStartIn: number = 0;
   private shootCooldownMax: number = 2;
 
   // --- DATA ---
   private players: Map<string, Player> = new Map();
   private bullets: Map<string, Bullet> = new Map();
+  // The page of rooms the server sends for our list_rooms query
   private rooms: RoomInfo[] = [];
+  private roomTotal: number = 0;
+  private roomCursor: string = "";
+  private nextRoomCursor: string = "";
+  // Cursors of the pages before this one, for paging back
+  private prevRoomCursors: string[] = [];
 
   // --- INPUT & UI ---
   private keysPressed: { [key: string]: boolean } = {};
@@ -64,18 +104,30 @@ export class Game {
   private leaveRoomButtonArea: ClickableArea | null = null;
   private _waitingCopyArea: ClickableArea | null = null;
 
-  // --- LOBBY SCROLL & SEARCH ---
-  private lobbyScrollOffset: number = 0;
+  // --- LOBBY PAGING & SEARCH ---
+  private wheelTravel: number = 0;
   private searchQuery: string = "";
+  private searchTimer: number | undefined;
   private searchInput: HTMLInputElement | null = null;
   private copyToast: { text: string; expiry: number } | null = null;
   private currentRoomId: string | null = null;
+  private currentRoomCode: string | null = null;
+  // Room code from an invite link (/r/{code} redirects to /?room={code})
+  private pendingJoinCode: string | null = null;
+  // Match to watch from a replay link (/?replay={matchId})
+  private pendingReplayId: string | null = null;
+  // Set while watching a replay instead of playing
+  private replay: ReplayStatus | null = null;
+  // When the last replay_status arrived, in performance.now() time
+  private replayAt: number = 0;
 
   // --- ANIMATION ---
   private animTime: number = 0;
 
   constructor() {
     this.canvas = new Canvas();
+    this.pendingJoinCode = new URLSearchParams(location.search).get("room");
+    this.pendingReplayId = new URLSearchParams(location.search).get("replay");
     this.connectWebSocket();
     this.setupInputHandlers();
     this.setupSearchInput();
@@ -101,8 +153,15 @@ export class Game {
       letter-spacing: 1px;
     `;
     const syncQuery = () => {
-      this.searchQuery = input.value.toLowerCase();
-      this.lobbyScrollOffset = 0;
+      const query = input.value.trim().toLowerCase();
+      if (query === this.searchQuery) return;
+      this.searchQuery = query;
+      // The server does the searching; wait for a pause in typing
+      clearTimeout(this.searchTimer);
+      this.searchTimer = window.setTimeout(() => {
+        this.prevRoomCursors = [];
+        this.requestRooms("");
+      }, 200);
     };
     input.addEventListener("input", syncQuery);
     // keyup catches Backspace/Delete on some browsers where `input` event misfires
@@ -134,7 +193,11 @@ export class Game {
 
   private connectWebSocket() {
     const protocol = location.protocol === "https:" ? "wss:" : "ws:";
-    this.ws = new WebSocket(`${protocol}//${location.host}/ws`);
+    // Guests keep the session the server hands out, so chat mutes and
+    // kick bans follow them across reconnects
+    const guest = localStorage.getItem("guestToken");
+    const query = guest ? `?guest=${encodeURIComponent(guest)}` : "";
+    this.ws = new WebSocket(`${protocol}//${location.host}/ws${query}`);
 
     this.ws.onopen = () => {
       console.log("Connected to game server.");
@@ -161,15 +224,28 @@ export class Game {
     switch (msg.type) {
       case "welcome":
         this.myPlayerId = msg.payload.playerId;
+        if (msg.payload.guestToken) {
+          localStorage.setItem("guestToken", msg.payload.guestToken);
+        }
+        // From now on the server sends our page instead of the whole list
+        this.requestRooms("");
+        break;
+
+      // Room lists only go to clients in the lobby, which includes anyone
+      // watching a replay; those keep watching until they stop
+      case "room_page":
+        this.showRoomPage(msg.payload as RoomPage);
+        if (!this.replay) this.enterLobby();
         break;
 
       case "room_list":
-        this.clientState = "lobby";
-        this.currentRoomId = null;
-        this.rooms = (msg.payload as RoomInfo[]) || [];
-        this.players.clear();
-        this.bullets.clear();
-        this.keysPressed = {};
+        // Sent on connect, before our list_rooms query takes over
+        if (!this.replay) this.enterLobby();
+        break;
+
+      case "replay_status":
+        this.replay = msg.payload as ReplayStatus;
+        this.replayAt = performance.now();
         break;
 
       case "error":
@@ -180,10 +256,17 @@ export class Game {
         this.clientState = "in_game";
         const sp = msg.payload as ServerGameStatePayload;
         this.currentRoomId = sp.roomId || null;
+        this.currentRoomCode = sp.roomCode || null;
         this.roomState = sp.state;
         this.winnerId = sp.winnerId || null;
         this.timeRemaining = sp.timeRemaining ?? 0;
         this.shootCooldownMax = sp.shootCooldownMax ?? 2;
+        this.countdown = sp.countdown || 0;
+        this.arena = sp.arena || null;
+        if (sp.canvasWidth && sp.canvasHeight) {
+          this.canvas.resize(sp.canvasWidth, sp.canvasHeight);
+        }
+        this.autoStartIn = sp.autoStartIn || 0;
         this.amIReady = this.myPlayerId
           ? sp.readyPlayers[this.myPlayerId] || false
           : false;
@@ -207,6 +290,55 @@ export class Game {
     }
   }
 
+  // The server sends a room list whenever we arrive in the lobby
+  private enterLobby() {
+    this.clientState = "lobby";
+    this.currentRoomId = null;
+    this.currentRoomCode = null;
+    this.players.clear();
+    this.bullets.clear();
+    this.keysPressed = {};
+    // Follow an invite link once we're in the lobby
+    if (this.pendingJoinCode) {
+      this.sendWsMessage("join_room", { roomId: this.pendingJoinCode });
+      this.pendingJoinCode = null;
+      history.replaceState(null, "", "/");
+    } else if (this.pendingReplayId) {
+      this.sendWsMessage("watch_replay", { matchId: this.pendingReplayId });
+      this.pendingReplayId = null;
+      history.replaceState(null, "", "/");
+    }
+  }
+
+  // Ask for a page of rooms matching the search. The server keeps sending
+  // that page as rooms change, until we ask for another.
+  private requestRooms(cursor: string) {
+    this.sendWsMessage("list_rooms", { query: this.searchQuery, cursor, limit: ROOM_PAGE_SIZE });
+  }
+
+  private showRoomPage(page: RoomPage) {
+    this.rooms = page.rooms || [];
+    this.roomTotal = page.total;
+    this.roomCursor = page.cursor ?? "";
+    this.nextRoomCursor = page.nextCursor ?? "";
+    // Rooms closed under us and left this page empty; go back one
+    if (this.rooms.length === 0 && this.prevRoomCursors.length > 0) {
+      this.requestRooms(this.prevRoomCursors.pop()!);
+    }
+  }
+
+  private nextRoomPage() {
+    if (!this.nextRoomCursor) return;
+    this.prevRoomCursors.push(this.roomCursor);
+    this.requestRooms(this.nextRoomCursor);
+    this.nextRoomCursor = ""; // until the page arrives
+  }
+
+  private prevRoomPage() {
+    if (this.prevRoomCursors.length === 0) return;
+    this.requestRooms(this.prevRoomCursors.pop()!);
+  }
+
   private sendWsMessage(type: string, payload: any) {
     if (this.ws && this.ws.readyState === WebSocket.OPEN) {
       this.ws.send(JSON.stringify({ type, payload }));
@@ -217,13 +349,15 @@ export class Game {
 
   private setupInputHandlers() {
     window.addEventListener("keydown", (e: KeyboardEvent) => {
-      if (this.clientState === "in_game") {
+      if (this.replay) {
+        this.handleReplayKey(e.key);
+      } else if (this.clientState === "in_game") {
         this.keysPressed[e.key.toLowerCase()] = true;
         this.updatePlayerInput();
       }
     });
     window.addEventListener("keyup", (e: KeyboardEvent) => {
-      if (this.clientState === "in_game") {
+      if (this.clientState === "in_game" && !this.replay) {
         this.keysPressed[e.key.toLowerCase()] = false;
         this.updatePlayerInput();
       }
@@ -242,7 +376,14 @@ export class Game {
 
     this.canvas.getCanvas()?.addEventListener("wheel", (e: WheelEvent) => {
       if (this.clientState === "lobby") {
-        this.lobbyScrollOffset = Math.max(0, this.lobbyScrollOffset + e.deltaY * 0.5);
+        this.wheelTravel += e.deltaY;
+        if (this.wheelTravel >= ROOM_PAGE_WHEEL) {
+          this.wheelTravel = 0;
+          this.nextRoomPage();
+        } else if (this.wheelTravel <= -ROOM_PAGE_WHEEL) {
+          this.wheelTravel = 0;
+          this.prevRoomPage();
+        }
         e.preventDefault();
       }
     }, { passive: false });
@@ -265,11 +406,13 @@ export class Game {
       this.sendWsMessage("create_room", {});
       return;
     }
-    // Copy room ID chip
+    // Copy room code chip — copies the invite link
     for (const id in this.copyIdAreas) {
       if (this.inArea(pos, this.copyIdAreas[id])) {
-        navigator.clipboard.writeText(id).then(() => {
-          this.copyToast = { text: "Room ID copied!", expiry: Date.now() + 2000 };
+        const room = this.rooms.find((r) => r.id === id);
+        const link = room ? `${location.origin}/r/${room.code}` : id;
+        navigator.clipboard.writeText(link).then(() => {
+          this.copyToast = { text: "Invite link copied!", expiry: Date.now() + 2000 };
         });
         return;
       }
@@ -285,21 +428,32 @@ export class Game {
 
   private handleInGameClick(pos: Vector2D) {
     if (this.leaveRoomButtonArea && this.inArea(pos, this.leaveRoomButtonArea)) {
-      this.sendWsMessage("leave_room", {});
+      this.sendWsMessage(this.replay ? "stop_replay" : "leave_room", {});
+      // The room list the server answers with takes us back to the lobby
+      this.replay = null;
+      return;
+    }
+    if (this.replay) {
+      this.sendWsMessage("replay_control", { action: this.replay.paused ? "play" : "pause" });
       return;
     }
     switch (this.roomState) {
       case "waiting":
-        // Copy room ID chip
-        if (this._waitingCopyArea && this.inArea(pos, this._waitingCopyArea) && this.currentRoomId) {
-          navigator.clipboard.writeText(this.currentRoomId).then(() => {
-            this.copyToast = { text: "Room ID copied!", expiry: Date.now() + 2000 };
+        // Copy room code chip — copies the invite link
+        if (this._waitingCopyArea && this.inArea(pos, this._waitingCopyArea) && this.currentRoomCode) {
+          navigator.clipboard.writeText(`${location.origin}/r/${this.currentRoomCode}`).then(() => {
+            this.copyToast = { text: "Invite link copied!", expiry: Date.now() + 2000 };
           });
           return;
         }
-        if (!this.amIReady) this.sendWsMessage("ready", {});
+        this.sendWsMessage(this.amIReady ? "unready" : "ready", {});
+        break;
+      case "countdown":
+        // Clicking during the countdown backs out of it
+        if (this.amIReady) this.sendWsMessage("unready", {});
         break;
       case "in_progress":
+      case "overtime":
         if (!this.myPlayerId || !this.mousePosition) return;
         const me = this.players.get(this.myPlayerId);
         if (me && me.getShootingCoolDownTime() <= 0) {
@@ -317,8 +471,32 @@ export class Game {
       && pos.y >= area.y && pos.y <= area.y + area.height;
   }
 
+  // Space pauses, 1-4 pick the speed, arrows skip 5s back or forward
+  private handleReplayKey(key: string) {
+    const r = this.replay!;
+    const speeds: { [key: string]: number } = { "1": 0.5, "2": 1, "3": 2, "4": 4 };
+    if (key === " ") {
+      this.sendWsMessage("replay_control", { action: r.paused ? "play" : "pause" });
+    } else if (key in speeds) {
+      this.sendWsMessage("replay_control", { action: "speed", speed: speeds[key] });
+    } else if (key === "ArrowLeft" || key === "ArrowRight") {
+      const step = (key === "ArrowLeft" ? -5 : 5) * r.tickRate;
+      const tick = Math.min(Math.max(this.replayTick() + step, 0), r.lastTick);
+      this.sendWsMessage("replay_control", { action: "seek", tick: Math.round(tick) });
+    }
+  }
+
+  // Where the replay is now: the last reported tick, moved on by the time
+  // since while it plays
+  private replayTick(): number {
+    const r = this.replay!;
+    if (r.paused) return r.tick;
+    const elapsed = (performance.now() - this.replayAt) / 1000;
+    return Math.min(r.tick + elapsed * r.tickRate * r.speed, r.lastTick);
+  }
+
   private updatePlayerInput() {
-    if (!this.myPlayerId || this.clientState !== "in_game") return;
+    if (!this.myPlayerId || this.clientState !== "in_game" || this.replay) return;
     let x = 0, y = 0;
     if (this.keysPressed["w"]) y -= 1;
     if (this.keysPressed["s"]) y += 1;
@@ -446,17 +624,12 @@ export class Game {
     }
 
     // Section label
-    const filteredRooms = this.rooms.filter(r =>
-      !this.searchQuery ||
-      r.id.toLowerCase().includes(this.searchQuery) ||
-      r.name.toLowerCase().includes(this.searchQuery)
-    );
     const labelStr = this.searchQuery
-      ? `─── ${filteredRooms.length} RESULT${filteredRooms.length !== 1 ? "S" : ""} ───`
-      : `─── ACTIVE ROOMS (${this.rooms.length}) ───`;
+      ? `─── ${this.roomTotal} RESULT${this.roomTotal !== 1 ? "S" : ""} ───`
+      : `─── ACTIVE ROOMS (${this.roomTotal}) ───`;
     this.canvas.drawText(labelStr, new Vector2D(cx, 178), "#6a8a50", "13px monospace", "center");
 
-    // ── Scrollable room list ─────────────────────────────────
+    // ── Room list, one server page at a time ─────────────────
     const roomW = 680, roomH = 58;
     const roomX = cx - roomW / 2;
     const listTop = 186;
@@ -469,16 +642,16 @@ export class Game {
     ctx.rect(roomX - 5, listTop, roomW + 10, listHeight);
     ctx.clip();
 
-    let yOff = listTop - this.lobbyScrollOffset;
+    let yOff = listTop;
 
-    if (filteredRooms.length === 0) {
+    if (this.rooms.length === 0) {
       const msg = this.searchQuery ? "No rooms match your search." : "No rooms. Be the first to create one!";
       ctx.fillStyle = "rgba(255,255,255,0.03)";
       ctx.fillRect(roomX, yOff, roomW, 70);
       this.canvas.drawText(msg, new Vector2D(cx, yOff + 44), "#445534", "15px monospace", "center");
     }
 
-    filteredRooms.forEach((room) => {
+    this.rooms.forEach((room) => {
       const isFull = room.playerCount >= room.maxPlayers;
       const rowBottom = yOff + roomH;
 
@@ -501,8 +674,8 @@ export class Game {
       // Room name
       this.canvas.drawText(room.name, new Vector2D(roomX + 38, yOff + 22), isFull ? "#886666" : "#b8d090", "bold 15px monospace");
 
-      // Short room ID chip — click to copy
-      const shortId = room.id.substring(0, 8) + "…";
+      // Room code chip — click to copy invite link
+      const shortId = room.code;
       const chipX = roomX + 38, chipY = yOff + 30, chipW = 110, chipH = 18;
       ctx.fillStyle = "rgba(40,70,30,0.7)";
       ctx.fillRect(chipX, chipY, chipW, chipH);
@@ -530,24 +703,17 @@ export class Game {
       yOff += roomH + 6;
     });
 
-    // ── Scroll indicator ─────────────────────────────────────
-    const totalContentH = filteredRooms.length * (roomH + 6);
-    if (totalContentH > listHeight) {
-      const trackH = listHeight;
-      const thumbH = Math.max(30, (listHeight / totalContentH) * trackH);
-      const thumbY = listTop + (this.lobbyScrollOffset / (totalContentH - listHeight)) * (trackH - thumbH);
-      const trackX = roomX + roomW + 6;
-      ctx.fillStyle = "rgba(255,255,255,0.06)";
-      ctx.fillRect(trackX, listTop, 6, trackH);
-      ctx.fillStyle = "#3a6a28";
-      ctx.fillRect(trackX, thumbY, 6, thumbH);
-      // Clamp scroll
-      const maxScroll = Math.max(0, totalContentH - listHeight);
-      this.lobbyScrollOffset = Math.min(this.lobbyScrollOffset, maxScroll);
-    }
-
     ctx.restore(); // end clip
 
+    // ── Page indicator ───────────────────────────────────────
+    const pages = Math.ceil(this.roomTotal / ROOM_PAGE_SIZE);
+    if (pages > 1) {
+      const page = this.prevRoomCursors.length + 1;
+      const up = this.prevRoomCursors.length > 0 ? "▲" : " ";
+      const down = this.nextRoomCursor ? "▼" : " ";
+      this.canvas.drawText(`${up}  PAGE ${page} / ${pages}  ${down}`, new Vector2D(cx, listBottom - 4), "#5a7a48", "12px monospace", "center");
+    }
+
     // ── Copy toast ───────────────────────────────────────────
     if (this.copyToast && Date.now() < this.copyToast.expiry) {
       const fade = (this.copyToast.expiry - Date.now()) / 2000;
@@ -608,7 +774,7 @@ export class Game {
       );
 
       // Aiming laser — only for me
-      if (isMe && this.mousePosition && this.roomState === "in_progress") {
+      if (isMe && this.mousePosition && (this.roomState === "in_progress" || this.roomState === "overtime")) {
         const dir = new Vector2D(
           this.mousePosition.x - cx,
           this.mousePosition.y - cy
@@ -730,6 +896,50 @@ export class Game {
     );
   }
 
+  private drawReplayBar() {
+    const r = this.replay!;
+    const tick = this.replayTick();
+    const w = this.canvas.getWidth();
+    const h = this.canvas.getHeight();
+    const ctx = this.canvas.getCtx();
+    ctx.fillStyle = "rgba(10,20,8,0.75)";
+    ctx.fillRect(0, h - 30, w, 30);
+    // Progress through the match
+    ctx.fillStyle = "#2a5a20";
+    ctx.fillRect(0, h - 30, r.lastTick > 0 ? (w * tick) / r.lastTick : 0, 3);
+    const secs = (t: number) => {
+      const s = Math.floor(t / r.tickRate);
+      return `${Math.floor(s / 60)}:${String(s % 60).padStart(2, "0")}`;
+    };
+    this.canvas.drawText(
+      `REPLAY ${r.paused ? "PAUSED" : "\u25B6"} ${r.speed}x  ${secs(tick)} / ${secs(r.lastTick)}   [SPACE] pause  [1-4] speed  [\u2190/\u2192] seek`,
+      new Vector2D(w / 2, h - 10),
+      "#c8d860",
+      "bold 13px monospace",
+      "center"
+    );
+  }
+
+  private drawOvertime() {
+    const ctx = this.canvas.getCtx();
+    const w = this.canvas.getWidth();
+    const h = this.canvas.getHeight();
+    const a = this.arena;
+    // Shade everything outside a shrinking arena
+    if (a && (a.w < w || a.h < h)) {
+      ctx.fillStyle = "rgba(60,0,0,0.55)";
+      ctx.fillRect(0, 0, w, a.y);
+      ctx.fillRect(0, a.y + a.h, w, h - a.y - a.h);
+      ctx.fillRect(0, a.y, a.x, a.h);
+      ctx.fillRect(a.x + a.w, a.y, w - a.x - a.w, a.h);
+      ctx.strokeStyle = "#ee4444";
+      ctx.lineWidth = 2;
+      ctx.strokeRect(a.x, a.y, a.w, a.h);
+    }
+    const alpha = 0.7 + 0.3 * Math.sin(this.animTime * 6);
+    this.canvas.drawText("OVERTIME", new Vector2D(w / 2, 62), `rgba(255,120,80,${alpha})`, "bold 16px monospace", "center");
+  }
+
   private drawWaitingScreen() {
     const ctx = this.canvas.getCtx();
     const cx = this.canvas.getWidth() / 2;
@@ -756,10 +966,9 @@ export class Game {
         "bold 22px monospace",
         "center"
       );
-      // Show room ID chip — click to copy and send to friend
-      if (this.currentRoomId) {
+      // Show room code chip — click to copy invite link and send to friend
+      if (this.currentRoomCode) {
         const ctx = this.canvas.getCtx();
-        const shortId = this.currentRoomId.substring(0, 8) + "…";
         const chipW = 270, chipH = 24;
         const chipX = cx - chipW / 2, chipY = cy + 18;
         ctx.fillStyle = "rgba(30,60,20,0.85)";
@@ -768,7 +977,7 @@ export class Game {
         ctx.lineWidth = 1;
         ctx.strokeRect(chipX, chipY, chipW, chipH);
         this.canvas.drawText(
-          `📋 Room ID: ${shortId}  (click to copy)`,
+          `📋 Room code: ${this.currentRoomCode}  (click to copy link)`,
           new Vector2D(cx, chipY + 17),
           "#7aaa50",
           "12px monospace",
@@ -786,7 +995,7 @@ export class Game {
         "bold 22px monospace",
         "center"
       );
-      this.canvas.drawText("Enemy is preparing...", new Vector2D(cx, cy + 30), "#557744", "15px monospace", "center");
+      this.canvas.drawText("Enemy is preparing... (click to cancel)", new Vector2D(cx, cy + 30), "#557744", "15px monospace", "center");
     } else {
       this.canvas.drawTextShadow(
         "CLICK TO READY UP",
@@ -798,6 +1007,26 @@ export class Game {
       );
       this.canvas.drawText("Both players must be ready to start", new Vector2D(cx, cy + 30), "#557744", "15px monospace", "center");
     }
+    if (this.autoStartIn > 0) {
+      this.canvas.drawText(`Auto-start in ${Math.ceil(this.autoStartIn)}s`, new Vector2D(cx, cy + 55), "#c8d860", "13px monospace", "center");
+    }
+  }
+
+  private drawCountdown() {
+    const cx = this.canvas.getWidth() / 2;
+    const cy = this.canvas.getHeight() / 2;
+    const secs = Math.max(1, Math.ceil(this.countdown));
+    // Each number pops in large and shrinks through its second
+    const frac = this.countdown - Math.floor(this.countdown);
+    this.canvas.drawTextShadow(
+      String(secs),
+      new Vector2D(cx, cy + 30),
+      "#ffee80",
+      "#806010",
+      `bold ${Math.round(80 + 60 * frac)}px monospace`,
+      "center"
+    );
+    this.canvas.drawText("GET READY", new Vector2D(cx, cy - 70), "#c8d860", "bold 20px monospace", "center");
   }
 
   private drawGameOver() {
@@ -856,6 +1085,12 @@ export class Game {
         this.drawInGameUI();
         break;
 
+      case "countdown":
+        this.drawPlayers();
+        this.drawInGameUI();
+        this.drawCountdown();
+        break;
+
       case "in_progress":
         this.drawPlayers();
         this.drawBullets();
@@ -863,11 +1098,20 @@ export class Game {
         this.drawTimer();
         break;
 
+      case "overtime":
+        this.drawOvertime();
+        this.drawPlayers();
+        this.drawBullets();
+        this.drawInGameUI();
+        this.drawTimer();
+        break;
+
       case "game_over":
         this.drawGameOver();
         this.drawInGameUI();
         break;
     }
+    if (this.replay) this.drawReplayBar();
   }
 
   // --- MAIN LOOP ---
@@ -879,12 +1123,10 @@ export class Game {
     const cx = this.canvas.getWidth() / 2;
     const cy = this.canvas.getHeight() / 2;
 
-    // Hide HTML search input when not in lobby
+    // Hide HTML search input when not in lobby; the search and page stay
+    // in place on the server for when we come back
     if (this.clientState !== "lobby" && this.searchInput) {
       this.searchInput.style.display = "none";
-      this.searchQuery = "";
-      if (this.searchInput) this.searchInput.value = "";
-      this.lobbyScrollOffset = 0;
     }
 
     switch (this.clientState) {
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MinAccountPasswordLength = 8
+	MaxAccountPasswordLength = 128
+	// PasswordHashIterations is the PBKDF2 work factor for new hashes. Each
+	// hash records its own count, so raising this does not lock anyone out.
+	PasswordHashIterations = 600_000
+	passwordSaltLength     = 16
+	passwordKeyLength      = 32
+)
+
+// Account error codes
+const (
+	ErrCodeUsernameTaken   = "username_taken"
+	ErrCodePasswordInvalid = "password_invalid"
+	ErrCodeLoginFailed     = "login_failed"
+	ErrCodeAuthFailed      = "auth_failed"
+)
+
+// Account is a registered player. Its ID is the player's identity in rooms,
+// ratings and match history.
+type Account struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// AccountStore keeps accounts in memory, appending new ones to a JSON-lines
+// file when it has one.
+type AccountStore struct {
+	mu         sync.RWMutex
+	byID       map[string]*Account
+	byUsername map[string]*Account // keyed by nameKey
+	file       *os.File            // nil keeps accounts in memory only
+}
+
+func NewAccountStore() *AccountStore {
+	return &AccountStore{
+		byID:       make(map[string]*Account),
+		byUsername: make(map[string]*Account),
+	}
+}
+
+// OpenAccountStore opens or creates the accounts file at path and loads the
+// accounts already in it.
+func OpenAccountStore(path string) (*AccountStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := NewAccountStore()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var a Account
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			slog.Warn("Accounts file line unreadable, skipping", "path", path, "line", line, "err", err)
+			continue
+		}
+		s.add(&a)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.file = f
+	slog.Info("Loaded accounts", "count", len(s.byID), "path", path)
+	return s, nil
+}
+
+func (s *AccountStore) add(a *Account) {
+	s.byID[a.ID] = a
+	s.byUsername[nameKey(a.Username)] = a
+}
+
+// Register creates an account. On failure an error code and message are
+// returned instead.
+func (s *AccountStore) Register(username, password string) (*Account, string, string) {
+	username, code, message := validateName(username)
+	if code != "" {
+		return nil, code, message
+	}
+	if n := len(password); n < MinAccountPasswordLength || n > MaxAccountPasswordLength {
+		return nil, ErrCodePasswordInvalid, fmt.Sprintf("Passwords must be %d-%d characters long", MinAccountPasswordLength, MaxAccountPasswordLength)
+	}
+	// Hash before taking the lock; it is deliberately slow
+	hash := hashPassword(password)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.byUsername[nameKey(username)]; taken {
+		return nil, ErrCodeUsernameTaken, "That username is already registered"
+	}
+	a := &Account{ID: uuid.NewString(), Username: username, PasswordHash: hash, CreatedAt: time.Now().UTC()}
+	if s.file != nil {
+		data, err := json.Marshal(a)
+		if err == nil {
+			_, err = s.file.Write(append(data, '\n'))
+		}
+		if err == nil {
+			err = s.file.Sync()
+		}
+		if err != nil {
+			slog.Error("Failed to save account", "username", username, "err", err)
+			return nil, ErrCodeInternal, "Could not create the account"
+		}
+	}
+	s.add(a)
+	return a, "", ""
+}
+
+// Login returns the account if the username and password match.
+func (s *AccountStore) Login(username, password string) (*Account, bool) {
+	s.mu.RLock()
+	a, ok := s.byUsername[nameKey(strings.TrimSpace(username))]
+	s.mu.RUnlock()
+	if !ok {
+		// Same work either way so timing does not reveal which usernames exist
+		checkPassword(dummyPasswordHash(), password)
+		return nil, false
+	}
+	return a, checkPassword(a.PasswordHash, password)
+}
+
+// Get looks an account up by ID.
+func (s *AccountStore) Get(id string) (*Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.byID[id]
+	return a, ok
+}
+
+// Owner returns the ID of the account registered under a name, if any.
+func (s *AccountStore) Owner(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if a, ok := s.byUsername[nameKey(name)]; ok {
+		return a.ID, true
+	}
+	return "", false
+}
+
+func (s *AccountStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// dummyPasswordHash is checked against for unknown usernames.
+var dummyPasswordHash = sync.OnceValue(func() string { return hashPassword("not a real password") })
+
+// hashPassword returns "pbkdf2-sha256$iterations$salt$key" with base64 salt and key.
+func hashPassword(password string) string {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := pbkdf2SHA256([]byte(password), salt, PasswordHashIterations, passwordKeyLength)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", PasswordHashIterations, enc.EncodeToString(salt), enc.EncodeToString(key))
+}
+
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err1 := enc.DecodeString(parts[2])
+	want, err2 := enc.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got := pbkdf2SHA256([]byte(password), salt, iterations, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2SHA256 is PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	size := prf.Size()
+	blocks := (keyLen + size - 1) / size
+	key := make([]byte, 0, blocks*size)
+	u := make([]byte, size)
+	var counter [4]byte
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		key = prf.Sum(key)
+		t := key[len(key)-size:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return key[:keyLen]
+}
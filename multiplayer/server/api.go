@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Leaderboard categories
+const (
+	BoardWins       = "wins"
+	BoardRating     = "rating"
+	BoardAccuracy   = "accuracy"
+	BoardTrickShots = "trick_shots"
+)
+
+var validBoards = map[string]bool{
+	BoardWins: true, BoardRating: true, BoardAccuracy: true, BoardTrickShots: true,
+}
+
+const (
+	APIDefaultPageSize = 20
+	APIMaxPageSize     = 100
+	// MinAccuracyShots keeps players with a lucky handful of shots off the
+	// accuracy board.
+	MinAccuracyShots = 20
+	// How long clients may reuse a response without revalidating. Matches
+	// never change once saved.
+	APIStatsMaxAge = 10 * time.Second
+	APIMatchMaxAge = time.Hour
+)
+
+const (
+	ErrCodeMatchNotFound = "match_not_found"
+	ErrCodeInternal      = "internal"
+)
+
+// PlayerSummary is a player's totals over a set of matches.
+type PlayerSummary struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`   // as of their latest match
+	Rating  int    `json:"rating"` // after their latest match
+	Matches int    `json:"matches"`
+	Wins    int    `json:"wins"`
+	Losses  int    `json:"losses"`
+	Draws   int    `json:"draws"`
+	PlayerStats
+	Accuracy float64   `json:"accuracy"` // shotsHit / shotsFired
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type LeaderboardEntry struct {
+	Rank  int     `json:"rank"`
+	Value float64 `json:"value"` // the figure the board is sorted by
+	PlayerSummary
+}
+
+type Leaderboard struct {
+	Board   string             `json:"board"`
+	Window  string             `json:"window"`
+	Total   int                `json:"total"`
+	Offset  int                `json:"offset"`
+	Limit   int                `json:"limit"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+type PlayerProfile struct {
+	PlayerSummary
+	RecentMatches []MatchRecord `json:"recentMatches"`
+	Offset        int           `json:"offset"`
+	Limit         int           `json:"limit"`
+}
+
+// registerAPI adds the read-only stats API under /api/.
+func registerAPI(mux *http.ServeMux, store MatchStore) {
+	mux.HandleFunc("GET /api/leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		serveLeaderboard(w, r, store)
+	})
+	mux.HandleFunc("GET /api/players/{id}", func(w http.ResponseWriter, r *http.Request) {
+		servePlayer(w, r, store)
+	})
+	mux.HandleFunc("GET /api/matches/{id}", func(w http.ResponseWriter, r *http.Request) {
+		serveMatch(w, r, store)
+	})
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, ErrCodeInvalidRequest, "Unknown API endpoint")
+	})
+}
+
+// serveLeaderboard handles ?board=wins|rating|accuracy|trick_shots,
+// ?window=all|24h|7d|... and ?offset=&limit= paging.
+func serveLeaderboard(w http.ResponseWriter, r *http.Request, store MatchStore) {
+	q := r.URL.Query()
+	board := q.Get("board")
+	if board == "" {
+		board = BoardWins
+	}
+	if !validBoards[board] {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "board must be wins, rating, accuracy or trick_shots")
+		return
+	}
+	window, since, err := parseWindow(q.Get("window"), time.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	offset, limit, err := parsePage(q)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	matches, err := store.Matches(since)
+	if err != nil {
+		slog.Error("API: loading matches", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "Could not load matches")
+		return
+	}
+	entries := rankPlayers(summarize(matches), board)
+	total := len(entries)
+	entries = entries[min(offset, total):min(offset+limit, total)]
+	writeAPIJSON(w, r, APIStatsMaxAge, Leaderboard{
+		Board:   board,
+		Window:  window,
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Entries: entries,
+	})
+}
+
+// servePlayer returns a player's all-time totals and a page of their
+// matches, newest first.
+func servePlayer(w http.ResponseWriter, r *http.Request, store MatchStore) {
+	id := r.PathValue("id")
+	offset, limit, err := parsePage(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	// Totals need every match the player was in, not just this page
+	matches, err := store.PlayerMatches(id, 0)
+	if err != nil {
+		slog.Error("API: loading matches for player", "player", id, "err", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "Could not load matches")
+		return
+	}
+	if len(matches) == 0 {
+		writeAPIError(w, http.StatusNotFound, ErrCodePlayerNotFound, "No matches recorded for that player")
+		return
+	}
+	profile := PlayerProfile{
+		PlayerSummary: *summarize(matches)[id],
+		RecentMatches: matches[min(offset, len(matches)):min(offset+limit, len(matches))],
+		Offset:        offset,
+		Limit:         limit,
+	}
+	writeAPIJSON(w, r, APIStatsMaxAge, profile)
+}
+
+func serveMatch(w http.ResponseWriter, r *http.Request, store MatchStore) {
+	m, ok, err := store.Match(r.PathValue("id"))
+	if err != nil {
+		slog.Error("API: loading match", "match", r.PathValue("id"), "err", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "Could not load match")
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, ErrCodeMatchNotFound, "No such match")
+		return
+	}
+	writeAPIJSON(w, r, APIMatchMaxAge, m)
+}
+
+// summarize totals every player's matches. matches must be newest first.
+func summarize(matches []MatchRecord) map[string]*PlayerSummary {
+	players := make(map[string]*PlayerSummary)
+	for _, m := range matches {
+		for _, p := range m.Players {
+			s, ok := players[p.ID]
+			if !ok {
+				// First sighting is the latest match
+				s = &PlayerSummary{ID: p.ID, Name: p.Name, Rating: p.Rating + p.RatingChange, LastSeen: m.EndedAt}
+				players[p.ID] = s
+			}
+			s.Matches++
+			switch m.WinnerID {
+			case "":
+				s.Draws++
+			case p.ID:
+				s.Wins++
+			default:
+				s.Losses++
+			}
+			s.ShotsFired += p.ShotsFired
+			s.ShotsHit += p.ShotsHit
+			s.TrickShots += p.TrickShots
+			s.DamageDealt += p.DamageDealt
+			s.DamageTaken += p.DamageTaken
+		}
+	}
+	for _, s := range players {
+		if s.ShotsFired > 0 {
+			s.Accuracy = float64(s.ShotsHit) / float64(s.ShotsFired)
+		}
+	}
+	return players
+}
+
+// rankPlayers orders players for a board, best first. Ties go to whoever
+// played more recently, then by ID so pages are stable.
+func rankPlayers(players map[string]*PlayerSummary, board string) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(players))
+	for _, s := range players {
+		var value float64
+		switch board {
+		case BoardWins:
+			value = float64(s.Wins)
+		case BoardRating:
+			value = float64(s.Rating)
+		case BoardAccuracy:
+			if s.ShotsFired < MinAccuracyShots {
+				continue
+			}
+			value = s.Accuracy
+		case BoardTrickShots:
+			value = float64(s.TrickShots)
+		}
+		entries = append(entries, LeaderboardEntry{Value: value, PlayerSummary: *s})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		if !a.LastSeen.Equal(b.LastSeen) {
+			return a.LastSeen.After(b.LastSeen)
+		}
+		return a.ID < b.ID
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// parseWindow turns "all" (or nothing) into a zero time, and a duration such
+// as "24h" or "7d" into the start of that window.
+func parseWindow(s string, now time.Time) (string, time.Time, error) {
+	if s == "" || s == "all" {
+		return "all", time.Time{}, nil
+	}
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return "", time.Time{}, fmt.Errorf("window must be \"all\" or a duration such as 24h or 7d")
+	}
+	return s, now.Add(-d), nil
+}
+
+func parsePage(q url.Values) (offset, limit int, err error) {
+	limit = APIDefaultPageSize
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > APIMaxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", APIMaxPageSize)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return offset, limit, nil
+}
+
+// writeAPIJSON sends v with an ETag of its contents, or 304 Not Modified if
+// the client already has this version.
+func writeAPIJSON(w http.ResponseWriter, r *http.Request, maxAge time.Duration, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("API: encoding response", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "Could not encode response")
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	h.Set("Access-Control-Allow-Origin", "*")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// etagMatches checks an If-None-Match header, which may list several tags.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": message})
+}
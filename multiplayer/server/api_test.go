@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiTestServer serves the API over three matches between a, b and c:
+//
+//	m1, two days ago:      a beats b
+//	m2, an hour ago:       b beats c
+//	m3, half an hour ago:  a beats c
+func apiTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	now := time.Now()
+	store := NewMemoryMatchStore()
+	for _, m := range []MatchRecord{
+		{ID: "m1", EndedAt: now.Add(-48 * time.Hour), WinnerID: "a", Players: []MatchPlayer{
+			{ID: "a", Name: "Ann", Rating: 1500, RatingChange: 20, PlayerStats: PlayerStats{ShotsFired: 30, ShotsHit: 15}},
+			{ID: "b", Name: "Bob", Rating: 1500, RatingChange: -20, PlayerStats: PlayerStats{ShotsFired: 10, ShotsHit: 1}},
+		}},
+		{ID: "m2", EndedAt: now.Add(-time.Hour), WinnerID: "b", Players: []MatchPlayer{
+			{ID: "b", Name: "Bob", Rating: 1480, RatingChange: 40},
+			{ID: "c", Name: "Cat", Rating: 1500, RatingChange: -20, PlayerStats: PlayerStats{ShotsFired: 25, ShotsHit: 20, TrickShots: 3}},
+		}},
+		{ID: "m3", EndedAt: now.Add(-30 * time.Minute), WinnerID: "a", Players: []MatchPlayer{
+			{ID: "a", Name: "Ann", Rating: 1520, RatingChange: 15, PlayerStats: PlayerStats{TrickShots: 1}},
+			{ID: "c", Name: "Cat", Rating: 1480, RatingChange: -15},
+		}},
+	} {
+		if err := store.SaveMatch(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	registerAPI(mux, store)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// getAPI fetches path and decodes a 200 response into v.
+func getAPI(t *testing.T, srv *httptest.Server, path string, v interface{}) *http.Response {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return resp
+}
+
+func boardIDs(lb Leaderboard) string {
+	var out []string
+	for _, e := range lb.Entries {
+		out = append(out, e.ID)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestAPILeaderboardOrder(t *testing.T) {
+	srv := apiTestServer(t)
+	for _, tc := range []struct {
+		query string
+		want  string
+		total int
+	}{
+		{"", "a b c", 3},
+		{"?board=wins", "a b c", 3},
+		{"?board=rating", "a b c", 3},          // 1535, 1520, 1465
+		{"?board=accuracy", "c a", 2},          // b fired too few shots to rank
+		{"?board=trick_shots", "c a b", 3},     // 3, 1, 0
+		{"?board=wins&window=24h", "a b c", 3}, // a and b tied on one win; a played last
+		{"?board=trick_shots&window=7d", "c a b", 3},
+		{"?window=2h", "a b c", 3},
+		{"?window=45m", "a c", 2},
+	} {
+		var lb Leaderboard
+		resp := getAPI(t, srv, "/api/leaderboard"+tc.query, &lb)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", tc.query, resp.StatusCode)
+			continue
+		}
+		if got := boardIDs(lb); got != tc.want || lb.Total != tc.total {
+			t.Errorf("%s: order %q of %d, want %q of %d", tc.query, got, lb.Total, tc.want, tc.total)
+		}
+		for i, e := range lb.Entries {
+			if e.Rank != i+1 {
+				t.Errorf("%s: entry %d has rank %d", tc.query, i, e.Rank)
+			}
+		}
+	}
+
+	var lb Leaderboard
+	getAPI(t, srv, "/api/leaderboard?board=rating", &lb)
+	if lb.Entries[0].Value != 1535 || lb.Entries[0].Name != "Ann" {
+		t.Errorf("top of rating board = %+v, want Ann at 1535", lb.Entries[0])
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in     string
+		name   string
+		since  time.Time
+		errors bool
+	}{
+		{in: "", name: "all"},
+		{in: "all", name: "all"},
+		{in: "24h", name: "24h", since: now.Add(-24 * time.Hour)},
+		{in: "90m", name: "90m", since: now.Add(-90 * time.Minute)},
+		{in: "7d", name: "7d", since: now.AddDate(0, 0, -7)},
+		{in: "0d", errors: true},
+		{in: "-1h", errors: true},
+		{in: "d", errors: true},
+		{in: "week", errors: true},
+	} {
+		name, since, err := parseWindow(tc.in, now)
+		if tc.errors {
+			if err == nil {
+				t.Errorf("parseWindow(%q) = %q, %v; want an error", tc.in, name, since)
+			}
+			continue
+		}
+		if err != nil || name != tc.name || !since.Equal(tc.since) {
+			t.Errorf("parseWindow(%q) = %q, %v, %v; want %q, %v", tc.in, name, since, err, tc.name, tc.since)
+		}
+	}
+}
+
+func TestAPIPaging(t *testing.T) {
+	srv := apiTestServer(t)
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"?limit=1", "a"},
+		{"?limit=2&offset=1", "b c"},
+		{"?offset=2", "c"},
+		{"?offset=3", ""},
+		{"?offset=1000&limit=100", ""},
+	} {
+		var lb Leaderboard
+		resp := getAPI(t, srv, "/api/leaderboard"+tc.query, &lb)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", tc.query, resp.StatusCode)
+			continue
+		}
+		if got := boardIDs(lb); got != tc.want || lb.Total != 3 {
+			t.Errorf("%s: entries %q of %d, want %q of 3", tc.query, got, lb.Total, tc.want)
+		}
+		if lb.Entries == nil {
+			t.Errorf("%s: entries encoded as null", tc.query)
+		}
+	}
+
+	var p PlayerProfile
+	getAPI(t, srv, "/api/players/a?limit=1&offset=1", &p)
+	if len(p.RecentMatches) != 1 || p.RecentMatches[0].ID != "m1" || p.Matches != 2 {
+		t.Errorf("second page of a's matches = %+v, want m1 with totals over both", p)
+	}
+	p = PlayerProfile{}
+	getAPI(t, srv, "/api/players/a?offset=5", &p)
+	if p.RecentMatches == nil || len(p.RecentMatches) != 0 || p.Wins != 2 {
+		t.Errorf("past the end of a's matches = %+v, want an empty page and full totals", p)
+	}
+
+	for _, query := range []string{
+		"?limit=0", "?limit=101", "?limit=-1", "?limit=ten",
+		"?offset=-1", "?offset=x",
+		"?board=kills", "?window=forever",
+	} {
+		resp := getAPI(t, srv, "/api/leaderboard"+query, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, resp.StatusCode)
+		}
+	}
+	if resp := getAPI(t, srv, "/api/players/a?limit=500", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("player page over the limit: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestAPINotModified(t *testing.T) {
+	srv := apiTestServer(t)
+	for _, path := range []string{"/api/matches/m1", "/api/leaderboard?board=rating", "/api/players/b"} {
+		first := getAPI(t, srv, path, nil)
+		etag := first.Header.Get("ETag")
+		if first.StatusCode != http.StatusOK || etag == "" {
+			t.Fatalf("%s: status %d, ETag %q", path, first.StatusCode, etag)
+		}
+		for _, tc := range []struct {
+			ifNoneMatch string
+			want        int
+		}{
+			{etag, http.StatusNotModified},
+			{`"stale", W/` + etag, http.StatusNotModified},
+			{"*", http.StatusNotModified},
+			{`"stale"`, http.StatusOK},
+		} {
+			req, _ := http.NewRequest("GET", srv.URL+path, nil)
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("%s with If-None-Match %s: status %d, want %d", path, tc.ifNoneMatch, resp.StatusCode, tc.want)
+			}
+			if resp.StatusCode == http.StatusNotModified && len(body) != 0 {
+				t.Errorf("%s: 304 with a body", path)
+			}
+			if got := resp.Header.Get("ETag"); got != etag {
+				t.Errorf("%s: ETag changed from %s to %s", path, etag, got)
+			}
+		}
+	}
+}
+
+func TestAPINotFound(t *testing.T) {
+	srv := apiTestServer(t)
+	for _, tc := range []struct {
+		path string
+		code string
+	}{
+		{"/api/players/nobody", ErrCodePlayerNotFound},
+		{"/api/matches/nothing", ErrCodeMatchNotFound},
+		{"/api/nowhere", ErrCodeInvalidRequest},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct{ Code string }
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound || body.Code != tc.code {
+			t.Errorf("%s: %d %q, want 404 %q", tc.path, resp.StatusCode, body.Code, tc.code)
+		}
+	}
+}
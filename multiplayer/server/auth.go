@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	SessionTTL    = 30 * 24 * time.Hour
+	SessionCookie = "session"
+	// AuthMessageTimeout is how long a client connecting with ?auth=message
+	// has to send its auth message.
+	AuthMessageTimeout = 10 * time.Second
+	// SessionReplaceTimeout is how long a new sign-in waits for the same
+	// account's older connection to shut down.
+	SessionReplaceTimeout = 5 * time.Second
+	maxAuthBody           = 4 << 10
+)
+
+// RemovedSignedInElsewhere is the "removed" reason sent to a connection
+// replaced by a newer sign-in to the same account.
+const RemovedSignedInElsewhere = "signed_in_elsewhere"
+
+// TokenSigner issues and checks session tokens of the form
+// base64(accountID.expiry).base64(HMAC-SHA256).
+type TokenSigner struct {
+	secret []byte
+}
+
+func NewTokenSigner(secret []byte) *TokenSigner {
+	return &TokenSigner{secret: secret}
+}
+
+// randomSecret returns a fresh signing key. Tokens signed with it stop
+// working when the process exits.
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+func (t *TokenSigner) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Issue returns a token for accountID that expires after SessionTTL.
+func (t *TokenSigner) Issue(accountID string, now time.Time) string {
+	payload := accountID + "." + strconv.FormatInt(now.Add(SessionTTL).Unix(), 10)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(payload)) + "." + enc.EncodeToString(t.sign(payload))
+}
+
+// Verify returns the account ID in a token if its signature is good and it
+// has not expired.
+func (t *TokenSigner) Verify(token string, now time.Time) (string, bool) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	enc := base64.RawURLEncoding
+	payload, err1 := enc.DecodeString(encPayload)
+	sig, err2 := enc.DecodeString(encSig)
+	if err1 != nil || err2 != nil || !hmac.Equal(sig, t.sign(string(payload))) {
+		return "", false
+	}
+	accountID, expiry, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= exp {
+		return "", false
+	}
+	return accountID, true
+}
+
+// guestSessionPrefix marks the IDs in guest session tokens, which are signed like
+// account sessions but never name an account.
+const guestSessionPrefix = "guest:"
+
+// guestSession returns the guest identity in token if it is still valid, or
+// a new one, along with a renewed token for the client to keep.
+func (h *Hub) guestSession(token string) (id, renewed string) {
+	now := time.Now()
+	id, ok := h.tokens.Verify(token, now)
+	if !ok || !strings.HasPrefix(id, guestSessionPrefix) {
+		id = guestSessionPrefix + uuid.NewString()
+	}
+	return id, h.tokens.Issue(id, now)
+}
+
+// accountFromToken resolves a session token to a live account.
+func (h *Hub) accountFromToken(token string) (*Account, bool) {
+	id, ok := h.tokens.Verify(token, time.Now())
+	if !ok {
+		return nil, false
+	}
+	return h.accounts.Get(id)
+}
+
+// requestToken finds a session token in an Authorization: Bearer header, a
+// ?token= parameter or, for same-origin requests, the session cookie.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	// Any site may open a WebSocket to us, and the browser would attach the
+	// cookie, so it only counts when the page is our own
+	if cookie, err := r.Cookie(SessionCookie); err == nil && sameOrigin(r) {
+		return cookie.Value
+	}
+	return ""
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// authenticateWs works out which account, if any, a new WebSocket belongs
+// to. Clients that cannot send headers or cookies connect with ?auth=message
+// and send {"type":"auth","payload":{"token":...}} first. A bad or missing
+// token is not fatal: the client plays as a guest and failed is set so it
+// can be told why.
+func (h *Hub) authenticateWs(r *http.Request, conn *websocket.Conn) (account *Account, failed bool) {
+	token := requestToken(r)
+	if token == "" && r.URL.Query().Get("auth") == "message" {
+		conn.SetReadDeadline(time.Now().Add(AuthMessageTimeout))
+		var msg struct {
+			Type    string `json:"type"`
+			Payload struct {
+				Token string `json:"token"`
+			} `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != "auth" {
+			return nil, true
+		}
+		token = msg.Payload.Token
+	}
+	if token == "" {
+		return nil, false
+	}
+	account, ok := h.accountFromToken(token)
+	return account, !ok
+}
+
+// claimSession makes c the account's only connection, replacing any older
+// one. It waits for the old connection to leave so the two never share a
+// player ID in a room, and reports false if it did not go in time.
+func (h *Hub) claimSession(c *ClientConn) bool {
+	deadline := time.After(SessionReplaceTimeout)
+	for {
+		h.mu.Lock()
+		old, ok := h.sessions[c.id]
+		if !ok {
+			h.sessions[c.id] = c
+			h.mu.Unlock()
+			return true
+		}
+		h.mu.Unlock()
+
+		c.logger().Info("Account signed in again; closing older connection", "account", c.account, "old", old)
+		old.sendMessage("removed", map[string]string{
+			"reason":  RemovedSignedInElsewhere,
+			"message": "You signed in somewhere else",
+		})
+		conn := old.conn
+		time.AfterFunc(250*time.Millisecond, func() { conn.Close() })
+		select {
+		case <-old.released:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// releaseSession frees the account for a new connection once c has left.
+func (h *Hub) releaseSession(c *ClientConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions[c.id] == c {
+		delete(h.sessions, c.id)
+	}
+}
+
+type authRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type authResponse struct {
+	Token    string `json:"token"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// registerAuthAPI adds the account endpoints under /api/.
+func registerAuthAPI(mux *http.ServeMux, h *Hub) {
+	guard := newAuthGuard()
+	mux.HandleFunc("POST /api/register", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readAuthRequest(w, r)
+		if !ok {
+			return
+		}
+		ip := clientIP(r)
+		if !guard.byIP.allow(ip, time.Now()) {
+			slog.Warn("Too many account requests", "ip", ip)
+			writeTooManyAttempts(w, AuthIPRefillInterval)
+			return
+		}
+		release, ok := guard.acquireHash(r)
+		if !ok {
+			writeTooManyAttempts(w, time.Second)
+			return
+		}
+		account, code, message := h.accounts.Register(req.Username, req.Password)
+		release()
+		if code != "" {
+			status := http.StatusBadRequest
+			switch code {
+			case ErrCodeUsernameTaken:
+				status = http.StatusConflict
+			case ErrCodeInternal:
+				status = http.StatusInternalServerError
+			}
+			writeAPIError(w, status, code, message)
+			return
+		}
+		slog.Info("Registered account", "username", account.Username, "account", account.ID)
+		startSession(w, r, h, account, http.StatusCreated)
+	})
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readAuthRequest(w, r)
+		if !ok {
+			return
+		}
+		ip, user := clientIP(r), nameKey(strings.TrimSpace(req.Username))
+		now := time.Now()
+		if !guard.byIP.allow(ip, now) {
+			slog.Warn("Too many account requests", "ip", ip)
+			writeTooManyAttempts(w, AuthIPRefillInterval)
+			return
+		}
+		if guard.failures.exhausted(user, now) {
+			slog.Warn("Too many failed logins", "username", req.Username, "ip", ip)
+			writeTooManyAttempts(w, AuthFailureRefillInterval)
+			return
+		}
+		release, ok := guard.acquireHash(r)
+		if !ok {
+			writeTooManyAttempts(w, time.Second)
+			return
+		}
+		account, ok := h.accounts.Login(req.Username, req.Password)
+		release()
+		if !ok {
+			guard.failures.allow(user, time.Now())
+			writeAPIError(w, http.StatusUnauthorized, ErrCodeLoginFailed, "Wrong username or password")
+			return
+		}
+		startSession(w, r, h, account, http.StatusOK)
+	})
+	mux.HandleFunc("POST /api/logout", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, sessionCookie(r, "", -1))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/me", func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.accountFromToken(requestToken(r))
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, ErrCodeAuthFailed, "Not signed in")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": account.ID, "username": account.Username})
+	})
+}
+
+func readAuthRequest(w http.ResponseWriter, r *http.Request) (authRequest, bool) {
+	var req authRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthBody)).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "Expected a JSON body with username and password")
+		return req, false
+	}
+	return req, true
+}
+
+// startSession answers a successful register or login with a token, both
+// in the body for bots and as a cookie for the browser client.
+func startSession(w http.ResponseWriter, r *http.Request, h *Hub, account *Account, status int) {
+	token := h.tokens.Issue(account.ID, time.Now())
+	http.SetCookie(w, sessionCookie(r, token, int(SessionTTL.Seconds())))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(authResponse{Token: token, ID: account.ID, Username: account.Username})
+}
+
+func sessionCookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// RFC 7914 section 11 test vectors for PBKDF2-HMAC-SHA256.
+func TestPBKDF2SHA256(t *testing.T) {
+	for _, tc := range []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tc.password), []byte(tc.salt), tc.iterations, 64))
+		if got != tc.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %s, want %s", tc.password, tc.salt, tc.iterations, got, tc.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash := hashPassword("correct horse")
+	if !checkPassword(hash, "correct horse") {
+		t.Error("the right password was rejected")
+	}
+	if checkPassword(hash, "correct horsE") {
+		t.Error("a wrong password was accepted")
+	}
+	if hashPassword("correct horse") == hash {
+		t.Error("two hashes of the same password share a salt")
+	}
+	for _, bad := range []string{
+		"",
+		"correct horse",
+		"bcrypt$600000$c2FsdA$a2V5",
+		"pbkdf2-sha256$0$c2FsdA$a2V5",
+		"pbkdf2-sha256$many$c2FsdA$a2V5",
+		"pbkdf2-sha256$1$not base64!$a2V5",
+		"pbkdf2-sha256$1$c2FsdA",
+	} {
+		if checkPassword(bad, "correct horse") {
+			t.Errorf("malformed hash %q was accepted", bad)
+		}
+	}
+}
+
+func TestTokenVerify(t *testing.T) {
+	now := time.Now()
+	signer := NewTokenSigner([]byte("secret"))
+	token := signer.Issue("account-1", now)
+	if id, ok := signer.Verify(token, now); !ok || id != "account-1" {
+		t.Fatalf("Verify(fresh token) = %q, %v", id, ok)
+	}
+
+	enc := base64.RawURLEncoding
+	payload, sig, _ := strings.Cut(token, ".")
+	raw, _ := enc.DecodeString(payload)
+	forged := enc.EncodeToString([]byte(strings.Replace(string(raw), "account-1", "account-2", 1))) + "." + sig
+	sigBytes, _ := enc.DecodeString(sig)
+	sigBytes[0] ^= 1
+	flipped := payload + "." + enc.EncodeToString(sigBytes)
+
+	for name, tc := range map[string]struct {
+		signer *TokenSigner
+		token  string
+		at     time.Time
+	}{
+		"tampered payload":   {signer, forged, now},
+		"tampered signature": {signer, flipped, now},
+		"expired":            {signer, token, now.Add(SessionTTL)},
+		"another secret":     {NewTokenSigner([]byte("other")), token, now},
+		"no signature":       {signer, payload, now},
+		"not a token at all": {signer, "garbage", now},
+	} {
+		if id, ok := tc.signer.Verify(tc.token, tc.at); ok {
+			t.Errorf("%s: Verify accepted it as %q", name, id)
+		}
+	}
+}
+
+// testConn returns the server end of a real WebSocket connection.
+func testConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-accepted
+}
+
+func TestClaimSessionReplacesOlderConnection(t *testing.T) {
+	h := NewHub()
+	accountID := uuid.NewString()
+	newSession := func() *ClientConn {
+		return &ClientConn{
+			id:       accountID,
+			hub:      h,
+			conn:     testConn(t),
+			send:     make(chan *websocket.PreparedMessage, 8),
+			done:     make(chan struct{}),
+			released: make(chan struct{}),
+		}
+	}
+	old, fresh := newSession(), newSession()
+	if !h.claimSession(old) {
+		t.Fatal("first connection could not claim the account")
+	}
+
+	// The old connection is told it was replaced, then leaves
+	go func() {
+		<-old.send
+		h.releaseSession(old)
+		close(old.released)
+	}()
+	if !h.claimSession(fresh) {
+		t.Fatal("new connection could not replace the old one")
+	}
+	h.mu.Lock()
+	owner := h.sessions[accountID]
+	h.mu.Unlock()
+	if owner != fresh {
+		t.Error("the account's session is not the new connection")
+	}
+
+	// Releasing the old one late must not evict the new one
+	h.releaseSession(old)
+	if h.sessions[accountID] != fresh {
+		t.Error("releasing the replaced connection dropped the new session")
+	}
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	l := newKeyedLimiter(2, time.Minute)
+	now := time.Now()
+	if !l.allow("a", now) || !l.allow("a", now) {
+		t.Fatal("burst was not allowed")
+	}
+	if l.allow("a", now) || !l.exhausted("a", now) {
+		t.Error("third attempt in the burst was allowed")
+	}
+	if !l.allow("b", now) {
+		t.Error("one key's limit blocked another")
+	}
+	if !l.allow("a", now.Add(time.Minute)) {
+		t.Error("bucket did not refill")
+	}
+}
+
+func TestGuestSession(t *testing.T) {
+	h := NewHub()
+	id, token := h.guestSession("")
+	if !strings.HasPrefix(id, guestSessionPrefix) || token == "" {
+		t.Fatalf("new guest session = %q, %q", id, token)
+	}
+	if again, renewed := h.guestSession(token); again != id || renewed == "" {
+		t.Errorf("returning guest got %q, want %q", again, id)
+	}
+	if other, _ := h.guestSession(""); other == id {
+		t.Error("two new guests share an identity")
+	}
+
+	// Neither a forged token nor an account's session passes as a guest
+	forged := token[:len(token)-2] + "xx"
+	account := h.tokens.Issue(uuid.NewString(), time.Now())
+	for _, bad := range []string{forged, account, "junk"} {
+		if got, _ := h.guestSession(bad); got == id || !strings.HasPrefix(got, guestSessionPrefix) {
+			t.Errorf("token %q gave guest identity %q", bad, got)
+		}
+	}
+}
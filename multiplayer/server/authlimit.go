@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on the account endpoints. Every register and login attempt runs a
+// deliberately slow password hash, so without them one client could keep
+// every CPU busy and starve the rooms' tick loops.
+const (
+	// AuthIPBurst attempts from one address go through back to back, then
+	// one more every AuthIPRefillInterval.
+	AuthIPBurst          = 10
+	AuthIPRefillInterval = 6 * time.Second
+	// AuthFailureBurst wrong passwords for one username are allowed, then
+	// one more every AuthFailureRefillInterval, whatever address they come
+	// from.
+	AuthFailureBurst          = 5
+	AuthFailureRefillInterval = time.Minute
+	// MaxConcurrentHashes caps password hashes running at once. A request
+	// that cannot get a slot within AuthHashWait is turned away.
+	MaxConcurrentHashes = 2
+	AuthHashWait        = 2 * time.Second
+	// authLimiterSweep is how many keys a limiter holds before it forgets
+	// the ones that have refilled completely.
+	authLimiterSweep = 10_000
+)
+
+const ErrCodeTooManyAttempts = "too_many_attempts"
+
+// keyedLimiter is a token bucket per key, as chatLimiter is per client.
+type keyedLimiter struct {
+	burst    float64
+	interval time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*chatLimiter
+}
+
+func newKeyedLimiter(burst int, interval time.Duration) *keyedLimiter {
+	return &keyedLimiter{burst: float64(burst), interval: interval, buckets: make(map[string]*chatLimiter)}
+}
+
+// refill tops up key's bucket and returns it. Caller must hold l.mu.
+func (l *keyedLimiter) refill(key string, now time.Time) *chatLimiter {
+	if len(l.buckets) >= authLimiterSweep {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastRefill).Seconds()/l.interval.Seconds()+b.tokens >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &chatLimiter{tokens: l.burst, lastRefill: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.lastRefill).Seconds()/l.interval.Seconds())
+	b.lastRefill = now
+	return b
+}
+
+// allow takes a token from key's bucket if there is one.
+func (l *keyedLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(key, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// exhausted reports whether key's bucket is empty, without taking a token.
+func (l *keyedLimiter) exhausted(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.refill(key, now).tokens < 1
+}
+
+// authGuard applies the account endpoint limits.
+type authGuard struct {
+	byIP      *keyedLimiter
+	failures  *keyedLimiter // wrong passwords, keyed by nameKey(username)
+	hashSlots chan struct{}
+}
+
+func newAuthGuard() *authGuard {
+	return &authGuard{
+		byIP:      newKeyedLimiter(AuthIPBurst, AuthIPRefillInterval),
+		failures:  newKeyedLimiter(AuthFailureBurst, AuthFailureRefillInterval),
+		hashSlots: make(chan struct{}, MaxConcurrentHashes),
+	}
+}
+
+// acquireHash waits for a hashing slot. The caller must call the returned
+// release func if ok is true.
+func (g *authGuard) acquireHash(r *http.Request) (release func(), ok bool) {
+	timer := time.NewTimer(AuthHashWait)
+	defer timer.Stop()
+	select {
+	case g.hashSlots <- struct{}{}:
+		return func() { <-g.hashSlots }, true
+	case <-timer.C:
+		return nil, false
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+// clientIP is the address a request came from. Behind a proxy on the same
+// host or private network it is the last X-Forwarded-For entry, the one the
+// proxy added; anywhere else the header is ignored since clients can set it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !(ip.IsLoopback() || ip.IsPrivate()) {
+		return host
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return host
+	}
+	hops := strings.Split(forwarded[len(forwarded)-1], ",")
+	if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+		return last
+	}
+	return host
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	writeAPIError(w, http.StatusTooManyRequests, ErrCodeTooManyAttempts, "Too many attempts; try again later")
+}
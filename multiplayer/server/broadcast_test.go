@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// benchGameState builds a representative mid-round payload: two players and
+// a screen full of bullets.
+func benchGameState() GameState {
+	state := GameState{
+		RoomID:           "bench-room",
+		Players:          make(map[string]*Player),
+		Bullets:          make(map[string]*Bullet),
+		State:            StateInProgress,
+		ReadyPlayers:     map[string]bool{},
+		TimeRemaining:    97.5,
+		ShootCooldownMax: PlayerShootCooldown,
+	}
+	for i := 0; i < 2; i++ {
+		id := fmt.Sprintf("player-%d", i)
+		state.Players[id] = &Player{
+			ID: id, X: float64(i) * 400, Y: 300,
+			Width: PlayerWidth, Height: PlayerHeight, Color: playerColors[i],
+			CurrentHP: PlayerMaxHP, MaxHP: PlayerMaxHP,
+		}
+	}
+	for i := 0; i < 24; i++ {
+		id := fmt.Sprintf("bullet-%d", i)
+		state.Bullets[id] = &Bullet{
+			ID: id, OwnerID: "player-0", X: float64(i) * 50, Y: float64(i) * 20,
+			DirX: 0.6, DirY: 0.8, Radius: BulletRadius, TimesCollidedWall: i % 3,
+		}
+	}
+	return state
+}
+
+// benchConns opens n real WebSocket connections against a test server and
+// returns the server-side ends. The client ends drain and discard everything.
+func benchConns(b *testing.B, n int) []*websocket.Conn {
+	b.Helper()
+	accepted := make(chan *websocket.Conn, n)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			b.Errorf("upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	b.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conns := make([]*websocket.Conn, 0, n)
+	for i := 0; i < n; i++ {
+		clientConn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			b.Fatalf("dial: %v", err)
+		}
+		b.Cleanup(func() { clientConn.Close() })
+		go func() {
+			for {
+				if _, _, err := clientConn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+		serverConn := <-accepted
+		b.Cleanup(func() { serverConn.Close() })
+		conns = append(conns, serverConn)
+	}
+	return conns
+}
+
+var benchAudienceSizes = []int{2, 16, 64}
+
+// BenchmarkEncodePerClient is the old path: every writePump marshals the
+// shared payload again.
+func BenchmarkEncodePerClient(b *testing.B) {
+	state := benchGameState()
+	for _, n := range benchAudienceSizes {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for c := 0; c < n; c++ {
+					if _, err := json.Marshal(Message{Type: "gameState", Payload: state}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkEncodeOnce is the new path: one prepared frame per broadcast,
+// whatever the audience size.
+func BenchmarkEncodeOnce(b *testing.B) {
+	state := benchGameState()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encodeMessage("gameState", state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBroadcastPerClient writes a per-client encoding to real sockets.
+func BenchmarkBroadcastPerClient(b *testing.B) {
+	state := benchGameState()
+	for _, n := range benchAudienceSizes {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			conns := benchConns(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, conn := range conns {
+					msgBytes, err := json.Marshal(Message{Type: "gameState", Payload: state})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBroadcastPrepared writes one prepared frame to real sockets.
+func BenchmarkBroadcastPrepared(b *testing.B) {
+	state := benchGameState()
+	for _, n := range benchAudienceSizes {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			conns := benchConns(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				frame, err := encodeMessage("gameState", state)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, conn := range conns {
+					if err := conn.WritePreparedMessage(frame); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxChatLength      = 200 // runes per message
+	ChatHistorySize    = 50  // messages kept per room and for the lobby
+	ChatBurst          = 4   // messages a client may send back to back
+	ChatRefillInterval = 2 * time.Second
+	ChatStrikeLimit    = 3                // rejected messages before an automatic mute
+	ChatStrikeExpiry   = 10 * time.Minute // strikes are forgotten after this long without another
+	ChatAutoMute       = 60 * time.Second
+)
+
+// Chat scopes
+const (
+	ChatScopeLobby = "lobby"
+	ChatScopeRoom  = "room"
+)
+
+// Chat error codes
+const (
+	ErrCodeChatTooLong     = "chat_too_long"
+	ErrCodeChatRateLimited = "chat_rate_limited"
+	ErrCodeChatMuted       = "chat_muted"
+	ErrCodeChatRejected    = "chat_rejected"
+)
+
+// ChatMessage is delivered to every client in the sender's scope.
+type ChatMessage struct {
+	Scope  string `json:"scope"`
+	From   string `json:"from"`
+	Name   string `json:"name"`
+	Text   string `json:"text"`
+	SentAt int64  `json:"sentAt"` // unix milliseconds
+}
+
+// ErrChatRejected is returned by a ChatFilter that refuses a message outright.
+var ErrChatRejected = errors.New("message rejected by chat filter")
+
+// ChatFilter inspects a message before delivery. It returns the text to
+// deliver (possibly censored) or ErrChatRejected to drop the message.
+type ChatFilter interface {
+	Filter(from, text string) (string, error)
+}
+
+// ChatFilterChain applies filters in order; the first rejection wins.
+type ChatFilterChain []ChatFilter
+
+func (fc ChatFilterChain) Filter(from, text string) (string, error) {
+	var err error
+	for _, f := range fc {
+		if text, err = f.Filter(from, text); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// WordListFilter masks whole words from a list, case-insensitively.
+type WordListFilter struct {
+	pattern *regexp.Regexp
+}
+
+func NewWordListFilter(words []string) *WordListFilter {
+	if len(words) == 0 {
+		return &WordListFilter{}
+	}
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	return &WordListFilter{
+		pattern: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`),
+	}
+}
+
+func (f *WordListFilter) Filter(from, text string) (string, error) {
+	if f.pattern == nil {
+		return text, nil
+	}
+	return f.pattern.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	}), nil
+}
+
+// RegexFilter rejects any message matching one of its patterns.
+type RegexFilter struct {
+	patterns []*regexp.Regexp
+}
+
+func NewRegexFilter(patterns ...string) *RegexFilter {
+	f := &RegexFilter{}
+	for _, p := range patterns {
+		f.patterns = append(f.patterns, regexp.MustCompile(p))
+	}
+	return f
+}
+
+func (f *RegexFilter) Filter(from, text string) (string, error) {
+	for _, p := range f.patterns {
+		if p.MatchString(text) {
+			return "", ErrChatRejected
+		}
+	}
+	return text, nil
+}
+
+var defaultBlockedWords = []string{"fuck", "shit", "bitch", "cunt", "asshole"}
+
+// defaultChatFilter masks common profanity and rejects links.
+func defaultChatFilter() ChatFilter {
+	return ChatFilterChain{
+		NewWordListFilter(defaultBlockedWords),
+		NewRegexFilter(`(?i)\b(https?://|www\.)\S+`),
+	}
+}
+
+// chatHistory is a fixed-size ring of recent messages. Not safe for
+// concurrent use; the owner guards it.
+type chatHistory struct {
+	messages []ChatMessage
+	next     int
+	full     bool
+}
+
+func newChatHistory(size int) *chatHistory {
+	return &chatHistory{messages: make([]ChatMessage, size)}
+}
+
+func (ch *chatHistory) add(msg ChatMessage) {
+	ch.messages[ch.next] = msg
+	ch.next = (ch.next + 1) % len(ch.messages)
+	if ch.next == 0 {
+		ch.full = true
+	}
+}
+
+// snapshot returns the stored messages, oldest first.
+func (ch *chatHistory) snapshot() []ChatMessage {
+	if !ch.full {
+		return append([]ChatMessage{}, ch.messages[:ch.next]...)
+	}
+	out := make([]ChatMessage, 0, len(ch.messages))
+	out = append(out, ch.messages[ch.next:]...)
+	return append(out, ch.messages[:ch.next]...)
+}
+
+// chatLimiter is a per-client token bucket.
+type chatLimiter struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+func (l *chatLimiter) allow(now time.Time) bool {
+	if l.lastRefill.IsZero() {
+		l.tokens = ChatBurst
+	} else {
+		l.tokens += now.Sub(l.lastRefill).Seconds() / ChatRefillInterval.Seconds()
+		if l.tokens > ChatBurst {
+			l.tokens = ChatBurst
+		}
+	}
+	l.lastRefill = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// ChatModerator holds the filter and server-side mutes, which operators set
+// with Mute and which are applied automatically after repeated rejections.
+// Mutes are keyed by a client's identity, so they survive moving between the
+// lobby and rooms and, for signed-in players and guests alike, reconnecting.
+type ChatModerator struct {
+	mu      sync.Mutex
+	filter  ChatFilter
+	mutes   map[string]time.Time // identity -> muted until
+	strikes map[string]chatStrikes
+}
+
+// chatStrikes counts rejected messages in a row.
+type chatStrikes struct {
+	count int
+	last  time.Time
+}
+
+func NewChatModerator(filter ChatFilter) *ChatModerator {
+	return &ChatModerator{
+		filter:  filter,
+		mutes:   make(map[string]time.Time),
+		strikes: make(map[string]chatStrikes),
+	}
+}
+
+// Mute silences a client for d, replacing any mute it already has.
+func (cm *ChatModerator) Mute(identity string, d time.Duration) {
+	if d <= 0 {
+		cm.Unmute(identity)
+		return
+	}
+	cm.mu.Lock()
+	cm.mutes[identity] = time.Now().Add(d)
+	cm.mu.Unlock()
+}
+
+// Unmute lifts a client's mute and clears its strikes.
+func (cm *ChatModerator) Unmute(identity string) {
+	cm.mu.Lock()
+	delete(cm.mutes, identity)
+	delete(cm.strikes, identity)
+	cm.mu.Unlock()
+}
+
+// MutedUntil returns when the client's mute ends, or the zero time.
+func (cm *ChatModerator) MutedUntil(identity string) time.Time {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	until, ok := cm.mutes[identity]
+	if ok && time.Now().After(until) {
+		delete(cm.mutes, identity)
+		return time.Time{}
+	}
+	return until
+}
+
+// check runs the filter and counts strikes; too many rejections in a row
+// trigger an automatic mute. Strikes are kept across reconnects too, and
+// cleared by the next message that passes.
+func (cm *ChatModerator) check(identity, text string) (string, error) {
+	filtered, err := cm.filter.Filter(identity, text)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if err != nil {
+		now := time.Now()
+		s := cm.strikes[identity]
+		if now.Sub(s.last) > ChatStrikeExpiry {
+			s.count = 0
+		}
+		s.count++
+		s.last = now
+		if s.count >= ChatStrikeLimit {
+			cm.mutes[identity] = now.Add(ChatAutoMute)
+			delete(cm.strikes, identity)
+		} else {
+			cm.strikes[identity] = s
+		}
+		return "", err
+	}
+	delete(cm.strikes, identity)
+	return filtered, nil
+}
+
+// sweep drops mutes that have run out and strikes that have expired, for
+// clients that never chat again to have them cleared.
+func (cm *ChatModerator) sweep(now time.Time) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for identity, until := range cm.mutes {
+		if now.After(until) {
+			delete(cm.mutes, identity)
+		}
+	}
+	for identity, s := range cm.strikes {
+		if now.Sub(s.last) > ChatStrikeExpiry {
+			delete(cm.strikes, identity)
+		}
+	}
+}
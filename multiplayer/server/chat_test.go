@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWordListFilter(t *testing.T) {
+	f := NewWordListFilter([]string{"darn", "a.b"})
+	for _, tc := range []struct{ in, want string }{
+		{"well darn it", "well **** it"},
+		{"DARN", "****"},
+		{"darned", "darned"}, // whole words only
+		{"a.b and axb", "*** and axb"},
+		{"nothing to see", "nothing to see"},
+	} {
+		got, err := f.Filter("p", tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("Filter(%q) = %q, %v; want %q", tc.in, got, err, tc.want)
+		}
+	}
+
+	empty := NewWordListFilter(nil)
+	if got, err := empty.Filter("p", "darn"); err != nil || got != "darn" {
+		t.Errorf("empty list Filter = %q, %v; want the text unchanged", got, err)
+	}
+}
+
+func TestRegexFilter(t *testing.T) {
+	f := NewRegexFilter(`(?i)\b(https?://|www\.)\S+`, `^!`)
+	for _, tc := range []struct {
+		in     string
+		reject bool
+	}{
+		{"see http://example.com", true},
+		{"WWW.example.com", true},
+		{"!command", true},
+		{"hello!", false},
+		{"www", false},
+	} {
+		got, err := f.Filter("p", tc.in)
+		if tc.reject {
+			if !errors.Is(err, ErrChatRejected) {
+				t.Errorf("Filter(%q) = %q, %v; want ErrChatRejected", tc.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.in {
+			t.Errorf("Filter(%q) = %q, %v; want it passed through", tc.in, got, err)
+		}
+	}
+}
+
+func TestDefaultChatFilterChain(t *testing.T) {
+	f := defaultChatFilter()
+	if got, err := f.Filter("p", "oh shit"); err != nil || got != "oh ****" {
+		t.Errorf("profanity = %q, %v; want it masked", got, err)
+	}
+	if _, err := f.Filter("p", "shit www.example.com"); !errors.Is(err, ErrChatRejected) {
+		t.Errorf("link = %v, want ErrChatRejected", err)
+	}
+}
+
+func TestChatLimiter(t *testing.T) {
+	var l chatLimiter
+	now := time.Now()
+	for i := 0; i < ChatBurst; i++ {
+		if !l.allow(now) {
+			t.Fatalf("message %d of the burst refused", i+1)
+		}
+	}
+	if l.allow(now) {
+		t.Fatal("message past the burst allowed")
+	}
+	if l.allow(now.Add(ChatRefillInterval / 2)) {
+		t.Error("allowed before a token refilled")
+	}
+	now = now.Add(ChatRefillInterval)
+	if !l.allow(now) {
+		t.Error("refused after a token refilled")
+	}
+
+	// A long pause refills only up to the burst
+	now = now.Add(100 * ChatRefillInterval)
+	sent := 0
+	for l.allow(now) {
+		sent++
+	}
+	if sent != ChatBurst {
+		t.Errorf("sent %d after a long pause, want %d", sent, ChatBurst)
+	}
+}
+
+func TestChatStrikeAutoMute(t *testing.T) {
+	cm := NewChatModerator(NewRegexFilter(`bad`))
+
+	// A clean message in between clears the strikes
+	for i := 0; i < ChatStrikeLimit-1; i++ {
+		cm.check("guest:a", "bad")
+	}
+	if _, err := cm.check("guest:a", "good"); err != nil {
+		t.Fatal(err)
+	}
+	cm.check("guest:a", "bad")
+	if !cm.MutedUntil("guest:a").IsZero() {
+		t.Fatal("muted although the strikes were not in a row")
+	}
+
+	for i := 1; i < ChatStrikeLimit; i++ {
+		cm.check("guest:a", "bad")
+	}
+	until := cm.MutedUntil("guest:a")
+	if until.IsZero() {
+		t.Fatalf("not muted after %d rejections in a row", ChatStrikeLimit)
+	}
+	if d := time.Until(until); d <= 0 || d > ChatAutoMute {
+		t.Errorf("muted for %v, want up to %v", d, ChatAutoMute)
+	}
+	if !cm.MutedUntil("guest:b").IsZero() {
+		t.Error("mute applied to someone else")
+	}
+}
+
+func TestChatMuteAndUnmute(t *testing.T) {
+	cm := NewChatModerator(NewRegexFilter(`bad`))
+	cm.Mute("acct-1", time.Hour)
+	if d := time.Until(cm.MutedUntil("acct-1")); d <= 59*time.Minute || d > time.Hour {
+		t.Fatalf("operator mute lasts %v, want an hour", d)
+	}
+	if !cm.MutedUntil("acct-2").IsZero() {
+		t.Error("mute applied to someone else")
+	}
+
+	cm.check("acct-1", "bad")
+	cm.Unmute("acct-1")
+	if !cm.MutedUntil("acct-1").IsZero() {
+		t.Fatal("still muted after Unmute")
+	}
+	// Unmute also clears strikes, so it takes a full run of them to mute again
+	for i := 1; i < ChatStrikeLimit; i++ {
+		cm.check("acct-1", "bad")
+	}
+	if !cm.MutedUntil("acct-1").IsZero() {
+		t.Error("strikes from before Unmute still counted")
+	}
+
+	cm.Mute("acct-2", time.Hour)
+	cm.Mute("acct-2", 0)
+	if !cm.MutedUntil("acct-2").IsZero() {
+		t.Error("a zero-length mute did not lift the mute")
+	}
+}
+
+func TestChatModeratorSweep(t *testing.T) {
+	cm := NewChatModerator(NewRegexFilter(`bad`))
+	cm.Mute("short", time.Minute)
+	cm.Mute("long", time.Hour)
+	cm.check("striker", "bad")
+
+	cm.sweep(time.Now().Add(2 * time.Minute))
+	if _, ok := cm.mutes["short"]; ok {
+		t.Error("expired mute survived the sweep")
+	}
+	if _, ok := cm.mutes["long"]; !ok {
+		t.Error("running mute was swept")
+	}
+	if _, ok := cm.strikes["striker"]; !ok {
+		t.Error("recent strike was swept")
+	}
+
+	cm.sweep(time.Now().Add(ChatStrikeExpiry + time.Minute))
+	if len(cm.strikes) != 0 {
+		t.Errorf("stale strikes survived the sweep: %v", cm.strikes)
+	}
+}
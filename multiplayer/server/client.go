@@ -2,10 +2,15 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -21,37 +26,90 @@ var upgrader = websocket.Upgrader{
 
 // ClientConn represents a connected client
 type ClientConn struct {
-	id     string
+	id     string // account ID for signed-in players, random for guests
 	hub    *Hub
 	conn   *websocket.Conn
-	send   chan Message
+	send   chan *websocket.PreparedMessage
 	player *Player
 	room   *GameRoom
+	name   string     // nickname, unique among connected players; guarded by roomMu
 	roomMu sync.Mutex // protects room and player fields accessed from multiple goroutines
 	// Add close channel to coordinate goroutine shutdown
 	done chan struct{}
+	// chatLimiter is only touched from readPump
+	chatLimiter chatLimiter
+	lastActive  atomic.Int64  // unix nanoseconds of the last message received
+	account     string        // username if signed in, empty for guests; set before the pumps start
+	identity    string        // who chat mutes and kick bans apply to: the account ID, or the guest session
+	released    chan struct{} // closed once the connection has fully left
+	viewer      *replayViewer // replay being watched; only touched from readPump
 }
 
 // Message defines the structure for WebSocket communication
 type Message struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
+	Seq     uint64      `json:"seq,omitempty"` // room list version, on room list messages only
+}
+
+// encodeMessage serializes a message once into a prepared WebSocket frame.
+// The same frame can be queued to any number of clients; the library caches
+// the wire bytes so fan-out never re-encodes the payload.
+func encodeMessage(msgType string, payload interface{}) (*websocket.PreparedMessage, error) {
+	msgBytes, err := json.Marshal(Message{Type: msgType, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	return websocket.NewPreparedMessage(websocket.TextMessage, msgBytes)
+}
+
+// encodeEvent is encodeMessage for room list messages, which carry a
+// sequence number so clients can detect missed updates.
+func encodeEvent(msgType string, seq uint64, payload interface{}) (*websocket.PreparedMessage, error) {
+	msgBytes, err := json.Marshal(Message{Type: msgType, Payload: payload, Seq: seq})
+	if err != nil {
+		return nil, err
+	}
+	return websocket.NewPreparedMessage(websocket.TextMessage, msgBytes)
 }
 
 func newClientConn(conn *websocket.Conn, hub *Hub) *ClientConn {
-	return &ClientConn{
-		id:   uuid.NewString(),
-		hub:  hub,
-		conn: conn,
-		send: make(chan Message, 256), // Keep buffer size reasonable
-		room: nil,
-		done: make(chan struct{}),
+	c := &ClientConn{
+		id:       uuid.NewString(),
+		hub:      hub,
+		conn:     conn,
+		send:     make(chan *websocket.PreparedMessage, hub.config().Network.SendQueue),
+		room:     nil,
+		done:     make(chan struct{}),
+		released: make(chan struct{}),
 	}
+	c.touch()
+	return c
+}
+
+// Name returns the client's current nickname.
+func (c *ClientConn) Name() string {
+	c.roomMu.Lock()
+	defer c.roomMu.Unlock()
+	return c.name
+}
+
+func (c *ClientConn) setName(name string) {
+	c.roomMu.Lock()
+	c.name = name
+	c.roomMu.Unlock()
+}
+
+// String identifies the client in logs by nickname and short ID.
+func (c *ClientConn) String() string {
+	return fmt.Sprintf("%q (%s)", c.Name(), c.id[:8])
 }
 
 func (c *ClientConn) readPump() {
 	defer func() {
-		log.Printf("Client %s readPump is closing", c.id)
+		c.logger().Debug("readPump is closing")
+		c.hub.releaseName(c)
+		c.hub.dropLobbyView(c)
 
 		// Signal writePump to stop
 		close(c.done)
@@ -63,18 +121,24 @@ func (c *ClientConn) readPump() {
 
 		if room != nil {
 			// If client is in a room, notify the room about disconnection
-			room.unregister <- c
+			select {
+			case room.unregister <- c:
+			case <-room.done:
+			}
 		} else {
 			// If client is in lobby, notify the hub
 			c.hub.unregister <- c
 		}
 		c.conn.Close()
+		c.hub.releaseSession(c)
+		close(c.released)
 	}()
 
 	// Set read deadline and pong handler for connection health
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	pongWait := c.hub.config().Network.PongWait.std()
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
@@ -82,18 +146,21 @@ func (c *ClientConn) readPump() {
 		_, messageBytes, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Unexpected close error for client %s: %v", c.id, err)
+				c.logger().Warn("Unexpected close error", "err", err)
 			} else {
-				log.Printf("Client %s disconnected normally", c.id)
+				c.logger().Info("Disconnected normally")
 			}
 			break
 		}
 
+		c.touch()
+
 		var msg Message
 		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			log.Printf("Error unmarshalling message from client %s: %v", c.id, err)
+			c.logger().Warn("Error unmarshalling message", "err", err)
 			continue
 		}
+		c.hub.metrics.Received(msg.Type)
 
 		// Process message based on client's current context
 		c.roomMu.Lock()
@@ -122,13 +189,13 @@ func (c *ClientConn) handleRoomMessage(msg Message) {
 	case "input":
 		payloadMap, ok := msg.Payload.(map[string]interface{})
 		if !ok {
-			log.Printf("Invalid input payload format for player %s", c.id)
+			room.log.Warn("Invalid input payload format", "client", c)
 			return
 		}
 		inputX, xOk := payloadMap["x"].(float64)
 		inputY, yOk := payloadMap["y"].(float64)
 		if !xOk || !yOk {
-			log.Printf("Invalid input coordinate types for player %s", c.id)
+			room.log.Warn("Invalid input coordinate types", "client", c)
 			return
 		}
 		select {
@@ -137,19 +204,20 @@ func (c *ClientConn) handleRoomMessage(msg Message) {
 			Input:    NewVector2D(inputX, inputY),
 		}:
 		default:
-			log.Printf("Player input channel full for room %s", room.ID)
+			c.hub.metrics.Dropped(DropInputQueueFull)
+			room.log.Warn("Player input channel full")
 		}
 
 	case "shoot":
 		payloadMap, ok := msg.Payload.(map[string]interface{})
 		if !ok {
-			log.Printf("Invalid shoot payload format for player %s", c.id)
+			room.log.Warn("Invalid shoot payload format", "client", c)
 			return
 		}
 		targetX, xOk := payloadMap["x"].(float64)
 		targetY, yOk := payloadMap["y"].(float64)
 		if !xOk || !yOk {
-			log.Printf("Invalid shoot coordinate types for player %s", c.id)
+			room.log.Warn("Invalid shoot coordinate types", "client", c)
 			return
 		}
 		select {
@@ -158,61 +226,262 @@ func (c *ClientConn) handleRoomMessage(msg Message) {
 			TargetPos: NewVector2D(targetX, targetY),
 		}:
 		default:
-			log.Printf("Player shoot channel full for room %s", room.ID)
+			c.hub.metrics.Dropped(DropShootQueueFull)
+			room.log.Warn("Player shoot channel full")
 		}
 
 	case "ready":
 		select {
 		case room.playerReadyChan <- c.id:
 		default:
-			log.Printf("Player ready channel full for room %s", room.ID)
+			c.hub.metrics.Dropped(DropRoomQueueFull)
+			room.log.Warn("Player ready channel full")
 		}
 
 	case "restart":
 		select {
 		case room.playerRestartChan <- c.id:
 		default:
-			log.Printf("Player restart channel full for room %s", room.ID)
+			c.hub.metrics.Dropped(DropRoomQueueFull)
+			room.log.Warn("Player restart channel full")
+		}
+
+	case "unready":
+		select {
+		case room.playerUnreadyChan <- c.id:
+		default:
+			c.hub.metrics.Dropped(DropRoomQueueFull)
+			room.log.Warn("Player unready channel full")
 		}
 
 	case "leave_room":
-		log.Printf("Client %s requested to leave room %s", c.id, room.ID)
+		room.log.Info("Client requested to leave the room", "client", c)
 		c.hub.leaveRoom(c)
 
+	case "chat":
+		c.handleChat(msg, room)
+
+	case "kick_player", "lock_room", "change_settings", "transfer_host":
+		// Payloads: {playerId}, {locked}, {private, password, mode, map}, {playerId}.
+		// The room checks that the sender is its host.
+		payloadMap, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			c.sendError(ErrCodeInvalidRequest, "Invalid payload")
+			return
+		}
+		select {
+		case room.hostActions <- hostAction{client: c, kind: msg.Type, payload: payloadMap}:
+		default:
+			c.hub.metrics.Dropped(DropRoomQueueFull)
+			room.log.Warn("Host action channel full")
+		}
+
 	default:
-		log.Printf("Unknown room message type from player %s: %s", c.id, msg.Type)
+		room.log.Warn("Unknown room message type", "client", c, "type", msg.Type)
 	}
 }
 
 func (c *ClientConn) handleLobbyMessage(msg Message) {
 	switch msg.Type {
 	case "create_room":
-		log.Printf("Client %s requested to create a room", c.id)
-		c.hub.createRoom(c)
+		// Payload is optional: {"private": bool, "password": string, "mode", "map", "overtime", "swapSides": bool, "record": bool, "seed": number}
+		var opts RoomOptions
+		if payloadMap, ok := msg.Payload.(map[string]interface{}); ok {
+			opts.Private, _ = payloadMap["private"].(bool)
+			opts.Password, _ = payloadMap["password"].(string)
+			opts.Mode, _ = payloadMap["mode"].(string)
+			opts.Map, _ = payloadMap["map"].(string)
+			opts.Overtime, _ = payloadMap["overtime"].(string)
+			opts.SwapSides, _ = payloadMap["swapSides"].(bool)
+			if record, ok := payloadMap["record"].(bool); ok {
+				opts.NoReplay = !record
+			}
+			if seed, ok := payloadMap["seed"].(float64); ok {
+				opts.Seed = int64(seed)
+				if float64(opts.Seed) != seed {
+					opts.Seed = -1 // rejected by createRoom
+				}
+			}
+		}
+		c.logger().Info("Requested to create a room")
+		c.stopReplay()
+		c.hub.createRoom(c, opts)
 
 	case "join_room":
 		payloadMap, ok := msg.Payload.(map[string]interface{})
 		if !ok {
-			log.Printf("Invalid join_room payload from %s", c.id)
+			c.logger().Warn("Invalid join_room payload")
 			return
 		}
+		// roomId may be the full UUID or the short room code
 		roomID, ok := payloadMap["roomId"].(string)
 		if !ok {
-			log.Printf("Invalid roomID in join_room payload from %s", c.id)
+			roomID, ok = payloadMap["code"].(string)
+		}
+		if !ok {
+			c.logger().Warn("Invalid roomID in join_room payload")
+			return
+		}
+		password, _ := payloadMap["password"].(string)
+		c.logger().Info("Requested to join a room", "room", roomID)
+		c.stopReplay()
+		c.hub.joinRoom(c, roomID, password)
+
+	case "find_match":
+		// Payload is optional: {"mode": string}
+		var mode string
+		if payloadMap, ok := msg.Payload.(map[string]interface{}); ok {
+			mode, _ = payloadMap["mode"].(string)
+		}
+		c.logger().Info("Requested quick match")
+		c.stopReplay()
+		c.hub.findMatch(c, mode)
+
+	case "cancel_match":
+		c.hub.matchmaker.cancel <- c
+
+	case "watch_replay":
+		// Payload: {matchId, tick, speed}; tick and speed are optional
+		payloadMap, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			c.sendError(ErrCodeInvalidRequest, "Invalid payload")
+			return
+		}
+		c.watchReplay(payloadMap)
+
+	case "replay_control":
+		// Payload: {action: "pause"|"play"|"seek"|"speed", tick, speed}
+		payloadMap, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			c.sendError(ErrCodeInvalidRequest, "Invalid payload")
+			return
+		}
+		c.controlReplay(payloadMap)
+
+	case "stop_replay", "leave_room":
+		if c.stopReplay() {
+			go c.hub.sendRoomList(c)
+		}
+
+	case "chat":
+		c.handleChat(msg, nil)
+
+	case "list_rooms":
+		// Payload: {joinable, mode, map, hasPassword, sort, query, cursor, limit}, all optional
+		payloadMap, _ := msg.Payload.(map[string]interface{})
+		q, code, message := parseRoomQuery(payloadMap)
+		if code != "" {
+			c.sendError(code, message)
+			return
+		}
+		c.hub.setLobbyView(c, q)
+
+	case "set_name":
+		payloadMap, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			c.logger().Warn("Invalid set_name payload")
 			return
 		}
-		log.Printf("Client %s requested to join room %s", c.id, roomID)
-		c.hub.joinRoom(c, roomID)
+		requested, _ := payloadMap["name"].(string)
+		old := c.Name()
+		if code, message := c.hub.claimName(c, requested); code != "" {
+			c.sendError(code, message)
+			return
+		}
+		c.logger().Info("Changed name", "old", old)
+		c.sendMessage("name_set", map[string]string{"name": c.Name()})
+
+	case "resync":
+		// The client saw a gap in room list sequence numbers
+		go c.hub.sendRoomList(c)
+
+	default:
+		c.logger().Warn("Unknown lobby message type", "type", msg.Type)
+	}
+}
+
+// handleChat validates, rate-limits and filters a chat message, then hands it
+// to the room (if any) or the lobby for delivery.
+func (c *ClientConn) handleChat(msg Message, room *GameRoom) {
+	payloadMap, ok := msg.Payload.(map[string]interface{})
+	if !ok {
+		c.logger().Warn("Invalid chat payload format")
+		return
+	}
+	text, _ := payloadMap["text"].(string)
+	// Strip control characters so nobody can inject newlines or escapes
+	text = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text))
+	if text == "" {
+		return
+	}
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		c.sendError(ErrCodeChatTooLong, fmt.Sprintf("Messages are limited to %d characters", MaxChatLength))
+		return
+	}
+	if until := c.hub.chat.MutedUntil(c.identity); !until.IsZero() {
+		c.sendError(ErrCodeChatMuted, fmt.Sprintf("You are muted for %d more seconds", int(time.Until(until).Seconds())+1))
+		return
+	}
+	if !c.chatLimiter.allow(time.Now()) {
+		c.sendError(ErrCodeChatRateLimited, "You are sending messages too fast")
+		return
+	}
+	filtered, err := c.hub.chat.check(c.identity, text)
+	if err != nil {
+		c.logger().Info("Chat message rejected by filter")
+		c.sendError(ErrCodeChatRejected, "Message was blocked by the chat filter")
+		return
+	}
+
+	chatMsg := ChatMessage{From: c.id, Name: c.Name(), Text: filtered, SentAt: time.Now().UnixMilli()}
+	if room == nil {
+		chatMsg.Scope = ChatScopeLobby
+		c.hub.broadcastLobbyChat(chatMsg)
+		return
+	}
+	chatMsg.Scope = ChatScopeRoom
+	select {
+	case room.chatChan <- chatMsg:
+	default:
+		c.hub.metrics.Dropped(DropRoomQueueFull)
+		room.log.Warn("Chat channel full")
+	}
+}
 
+// sendMessage encodes a one-off message and queues it without blocking.
+// Returns false if encoding failed or the send buffer was full.
+func (c *ClientConn) sendMessage(msgType string, payload interface{}) bool {
+	frame, err := encodeMessage(msgType, payload)
+	if err != nil {
+		c.logger().Error("Error encoding message", "type", msgType, "err", err)
+		return false
+	}
+	select {
+	case c.send <- frame:
+		c.hub.metrics.Sent(msgType)
+		return true
 	default:
-		log.Printf("Unknown lobby message type from player %s: %s", c.id, msg.Type)
+		c.hub.metrics.Dropped(DropSendQueueFull)
+		return false
 	}
 }
 
+// sendError queues an error with a machine-readable code.
+func (c *ClientConn) sendError(code, message string) bool {
+	return c.sendMessage("error", map[string]string{"code": code, "message": message})
+}
+
 // writePump pumps messages from the hub/room to the WebSocket connection.
 func (c *ClientConn) writePump() {
 	// Send ping messages periodically
-	ticker := time.NewTicker(54 * time.Second)
+	net := c.hub.config().Network
+	ticker := time.NewTicker(net.PingPeriod.std())
+	writeWait := net.WriteWait.std()
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -220,27 +489,21 @@ func (c *ClientConn) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		case frame, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub/room closed the channel.
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			msgBytes, err := json.Marshal(message)
-			if err != nil {
-				log.Printf("Error marshalling message for client %s: %v", c.id, err)
-				return
-			}
-
-			if err := c.conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-				log.Printf("Error writing message to client %s: %v", c.id, err)
+			if err := c.conn.WritePreparedMessage(frame); err != nil {
+				c.logger().Warn("Error writing message", "err", err)
 				return
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -255,11 +518,50 @@ func (c *ClientConn) writePump() {
 func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		slog.Warn("WebSocket upgrade error", "err", err)
 		return
 	}
 
+	account, authFailed := hub.authenticateWs(r, conn)
 	client := newClientConn(conn, hub)
+	if account != nil {
+		// Signed-in players keep their account ID across connections
+		client.id = account.ID
+		client.account = account.Username
+		if !hub.claimSession(client) {
+			slog.Warn("Account is still connected elsewhere; refusing new connection", "username", account.Username)
+			conn.WriteJSON(Message{Type: "error", Payload: map[string]string{
+				"code":    ErrCodeAuthFailed,
+				"message": "This account is already connected",
+			}})
+			conn.Close()
+			return
+		}
+	}
+
+	// Chat mutes follow the account, or for guests a signed session the
+	// client keeps and sends back with ?guest=, so reconnecting does not
+	// lift them. A guest who throws the session away starts clean, much
+	// like one who makes a new account; keying by address instead would
+	// lump everyone behind one NAT together.
+	client.identity = client.id
+	var guestToken string
+	if account == nil {
+		client.identity, guestToken = hub.guestSession(r.URL.Query().Get("guest"))
+	}
+
+	// Nickname may be requested up front with /ws?name=...; signed-in
+	// players default to their username. Anyone who doesn't ask (or asks
+	// for a bad or taken name) starts as a guest.
+	hub.assignGuestName(client)
+	var nameErrCode, nameErrMessage string
+	requested := r.URL.Query().Get("name")
+	if requested == "" {
+		requested = client.account
+	}
+	if requested != "" {
+		nameErrCode, nameErrMessage = hub.claimName(client, requested)
+	}
 
 	// Start goroutines first
 	go client.writePump()
@@ -269,17 +571,30 @@ func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	hub.register <- client
 
 	// Send welcome message
-	welcomeMsg := Message{
-		Type:    "welcome",
-		Payload: map[string]string{"playerId": client.id},
+	welcome := map[string]string{"playerId": client.id, "name": client.Name(), "account": client.account}
+	if guestToken != "" {
+		welcome["guestToken"] = guestToken
+	}
+	welcomeFrame, err := encodeMessage("welcome", welcome)
+	if err != nil {
+		client.logger().Error("Error encoding welcome message", "err", err)
+		conn.Close()
+		return
 	}
 
 	// Use non-blocking send for welcome message
 	select {
-	case client.send <- welcomeMsg:
-		log.Printf("Client %s connected and registered with hub.", client.id)
+	case client.send <- welcomeFrame:
+		hub.metrics.Sent("welcome")
+		client.logger().Info("Connected and registered with hub")
+		if authFailed {
+			client.sendError(ErrCodeAuthFailed, "Your session is invalid or has expired; playing as a guest")
+		}
+		if nameErrCode != "" {
+			client.sendError(nameErrCode, nameErrMessage)
+		}
 	default:
-		log.Printf("Failed to send welcome message to client %s", client.id)
+		client.logger().Warn("Failed to send welcome message")
 		conn.Close()
 	}
 }
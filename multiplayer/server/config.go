@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EnvPrefix starts the environment variables that override config values.
+// The rest of the name is the setting's JSON path in upper snake case, so
+// game.tickRate is SERVER_GAME_TICK_RATE.
+const EnvPrefix = "SERVER_"
+
+// Config is everything the server can be tuned with. Values come from the
+// defaults, then the config file, then environment variables, then flags.
+type Config struct {
+	Addr       string        `json:"addr"`
+	AuthSecret string        `json:"authSecret"` // signs session tokens; random per process if empty
+	Game       GameConfig    `json:"game"`
+	Rooms      RoomConfig    `json:"rooms"`
+	Network    NetworkConfig `json:"network"`
+	Storage    StorageConfig `json:"storage"`
+	Log        LogConfig     `json:"log"`
+}
+
+// GameConfig is the gameplay a room is created with. Each room keeps its own
+// copy.
+type GameConfig struct {
+	TickRate         int      `json:"tickRate"`      // physics ticks per second during a round
+	BroadcastRate    int      `json:"broadcastRate"` // state broadcasts per second during a round
+	IdleTickRate     int      `json:"idleTickRate"`  // state broadcasts per second between rounds
+	CanvasWidth      float64  `json:"canvasWidth"`
+	CanvasHeight     float64  `json:"canvasHeight"`
+	MaxPlayers       int      `json:"maxPlayers"`
+	RoundDuration    Duration `json:"roundDuration"`
+	Countdown        Duration `json:"countdown"`        // from everyone ready to the round starting
+	OvertimeDuration Duration `json:"overtimeDuration"` // still level after this is a draw
+
+	PlayerMaxHP        int      `json:"playerMaxHP"`
+	PlayerWidth        float64  `json:"playerWidth"`
+	PlayerHeight       float64  `json:"playerHeight"`
+	PlayerMaxVelocity  float64  `json:"playerMaxVelocity"`  // pixels per second
+	PlayerAcceleration float64  `json:"playerAcceleration"` // pixels per second squared
+	PlayerFriction     float64  `json:"playerFriction"`     // share of speed kept every 1/60s without input
+	ShootCooldown      Duration `json:"shootCooldown"`
+
+	BulletRadius     float64 `json:"bulletRadius"`
+	BulletSpeed      float64 `json:"bulletSpeed"` // pixels per second
+	BulletDamage     int     `json:"bulletDamage"`
+	BulletMaxBounces int     `json:"bulletMaxBounces"` // wall bounces a bullet survives
+}
+
+// RoomConfig holds room defaults and the idle limits the hub enforces.
+type RoomConfig struct {
+	Overtime     string   `json:"overtime"`  // policy for rooms that do not pick one
+	Seed         int64    `json:"seed"`      // fixed RNG seed for rooms that do not set one; 0 seeds each round randomly
+	AutoStart    Duration `json:"autoStart"` // 0 disables
+	RoomIdle     Duration `json:"roomIdle"`  // for this and the rest, 0 disables the check
+	GameOverIdle Duration `json:"gameOverIdle"`
+	AFKWarn      Duration `json:"afkWarn"`
+	AFKKick      Duration `json:"afkKick"`
+	LobbyIdle    Duration `json:"lobbyIdle"`
+}
+
+type NetworkConfig struct {
+	ReadBufferSize   int      `json:"readBufferSize"` // bytes
+	WriteBufferSize  int      `json:"writeBufferSize"`
+	SendQueue        int      `json:"sendQueue"` // messages queued per client before frames are dropped
+	RoomQueue        int      `json:"roomQueue"` // inputs, shots and chat queued per room
+	HandshakeTimeout Duration `json:"handshakeTimeout"`
+	PongWait         Duration `json:"pongWait"`   // a client silent this long is dropped
+	PingPeriod       Duration `json:"pingPeriod"` // must be shorter than pongWait
+	WriteWait        Duration `json:"writeWait"`
+}
+
+// StorageConfig says where data outlives a restart. By default nothing is
+// written: matches and accounts stay in memory and no replay files are kept.
+type StorageConfig struct {
+	DataDir        string   `json:"dataDir"`  // keeps the stores below under their default names; relative paths are inside it
+	History        string   `json:"history"`  // match history file; with no dataDir, empty keeps matches in memory only
+	Accounts       string   `json:"accounts"` // accounts file; with no dataDir, empty keeps accounts in memory only
+	Replays        string   `json:"replays"`  // replay directory; with no dataDir, empty disables recording
+	ReplayMaxAge   Duration `json:"replayMaxAge"`
+	ReplayMaxFiles int      `json:"replayMaxFiles"`
+	ReplayFrames   bool     `json:"replayFrames"` // also save every sent frame, for smooth playback after restarts
+}
+
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info, warn or error; debug adds every shot and hit
+	Format string `json:"format"` // text or json
+	// Below error level, at most SampleBurst lines with the same message are
+	// written per SampleInterval; the rest are counted and dropped. 0 keeps
+	// every line.
+	SampleBurst    int      `json:"sampleBurst"`
+	SampleInterval Duration `json:"sampleInterval"`
+}
+
+func DefaultConfig() Config {
+	idle := DefaultIdleTimeouts()
+	return Config{
+		Addr: ":8080",
+		Game: GameConfig{
+			TickRate:           int(time.Second / GameTickRate),
+			BroadcastRate:      int(time.Second / BroadcastTickRate),
+			IdleTickRate:       int(time.Second / IdleTickRate),
+			CanvasWidth:        CanvasWidth,
+			CanvasHeight:       CanvasHeight,
+			MaxPlayers:         MaxPlayersPerRoom,
+			RoundDuration:      Duration(RoundDuration * time.Second),
+			Countdown:          Duration(CountdownDuration),
+			OvertimeDuration:   Duration(OvertimeDuration * time.Second),
+			PlayerMaxHP:        PlayerMaxHP,
+			PlayerWidth:        PlayerWidth,
+			PlayerHeight:       PlayerHeight,
+			PlayerMaxVelocity:  PlayerMaxVelocity,
+			PlayerAcceleration: PlayerAcceleration,
+			PlayerFriction:     PlayerFriction,
+			ShootCooldown:      Duration(PlayerShootCooldown * time.Second),
+			BulletRadius:       BulletRadius,
+			BulletSpeed:        BulletSpeed,
+			BulletDamage:       2,
+			BulletMaxBounces:   5,
+		},
+		Rooms: RoomConfig{
+			Overtime:     OvertimeSuddenDeath,
+			RoomIdle:     Duration(idle.RoomWaiting),
+			GameOverIdle: Duration(idle.RoomGameOver),
+			AFKWarn:      Duration(idle.AFKWarn),
+			AFKKick:      Duration(idle.AFKKick),
+			LobbyIdle:    Duration(idle.Lobby),
+		},
+		Network: NetworkConfig{
+			ReadBufferSize:   1024,
+			WriteBufferSize:  1024,
+			SendQueue:        256,
+			RoomQueue:        16,
+			HandshakeTimeout: Duration(45 * time.Second),
+			PongWait:         Duration(60 * time.Second),
+			PingPeriod:       Duration(54 * time.Second),
+			WriteWait:        Duration(10 * time.Second),
+		},
+		Storage: StorageConfig{
+			ReplayMaxAge:   Duration(7 * 24 * time.Hour),
+			ReplayMaxFiles: 1000,
+		},
+		Log: LogConfig{
+			Level:          "info",
+			Format:         LogFormatText,
+			SampleBurst:    100,
+			SampleInterval: Duration(time.Second),
+		},
+	}
+}
+
+// LoadConfig builds the effective configuration: defaults, then the file at
+// path if there is one, then environment variables, then any flags in fs that
+// were set on the command line. fs must have been set up with bindFlags.
+func LoadConfig(path string, lookupEnv func(string) (string, bool), fs *flag.FlagSet) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return cfg, err
+	}
+	if fs != nil {
+		set := flag.NewFlagSet("config", flag.ContinueOnError)
+		bindFlags(set, &cfg)
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			if err == nil && set.Lookup(f.Name) != nil {
+				err = set.Set(f.Name, f.Value.String())
+			}
+		})
+		if err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// bindFlags registers the command-line flags that predate the config file.
+// Their defaults are whatever c holds.
+func bindFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "http service address (overrides PORT env)")
+	fs.StringVar(&c.AuthSecret, "auth-secret", c.AuthSecret, "key for signing session tokens (overrides AUTH_SECRET env; random if neither is set)")
+	fs.DurationVar(c.Rooms.RoomIdle.ptr(), "room-idle", c.Rooms.RoomIdle.std(), "close rooms left waiting for players this long (0 disables)")
+	fs.DurationVar(c.Rooms.GameOverIdle.ptr(), "gameover-idle", c.Rooms.GameOverIdle.std(), "close rooms left on the game over screen this long (0 disables)")
+	fs.DurationVar(c.Rooms.AFKWarn.ptr(), "afk-warn", c.Rooms.AFKWarn.std(), "warn players with no input during a round after this long (0 disables)")
+	fs.DurationVar(c.Rooms.AFKKick.ptr(), "afk-kick", c.Rooms.AFKKick.std(), "remove players with no input during a round after this long (0 disables)")
+	fs.DurationVar(c.Rooms.LobbyIdle.ptr(), "lobby-idle", c.Rooms.LobbyIdle.std(), "disconnect lobby clients that send nothing for this long (0 disables)")
+	fs.DurationVar(c.Rooms.AutoStart.ptr(), "auto-start", c.Rooms.AutoStart.std(), "start the countdown this long after a room fills with a majority of its players ready (0 disables)")
+	fs.StringVar(&c.Rooms.Overtime, "overtime", c.Rooms.Overtime, "overtime policy when a round ends level: none, sudden_death, shrinking_arena or extra_time")
+	fs.Int64Var(&c.Rooms.Seed, "seed", c.Rooms.Seed, "fixed RNG seed for every round in rooms that do not set one (0 picks a random seed per round)")
+	fs.StringVar(&c.Storage.DataDir, "data-dir", c.Storage.DataDir, "directory match history, accounts and replays are kept in (empty keeps them in memory only)")
+	fs.StringVar(&c.Storage.History, "history", c.Storage.History, "file finished matches are appended to (default matches.jsonl in -data-dir; without one, empty keeps them in memory only)")
+	fs.StringVar(&c.Storage.Accounts, "accounts", c.Storage.Accounts, "file player accounts are stored in (default accounts.jsonl in -data-dir; without one, empty keeps them in memory only)")
+	fs.StringVar(&c.Storage.Replays, "replays", c.Storage.Replays, "directory round replays are saved to (default replays in -data-dir; without one, empty disables recording)")
+	fs.DurationVar(c.Storage.ReplayMaxAge.ptr(), "replay-max-age", c.Storage.ReplayMaxAge.std(), "delete replays older than this (0 keeps them)")
+	fs.IntVar(&c.Storage.ReplayMaxFiles, "replay-max-files", c.Storage.ReplayMaxFiles, "keep at most this many replays (0 for no limit)")
+	fs.BoolVar(&c.Storage.ReplayFrames, "replay-frames", c.Storage.ReplayFrames, "also save every sent frame in replays, for smooth playback after restarts (larger files)")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "log level: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log format: text or json")
+}
+
+// applyEnv overrides values from SERVER_* variables, plus PORT and
+// AUTH_SECRET, which deployments already set.
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	if port, ok := lookupEnv("PORT"); ok && port != "" {
+		c.Addr = ":" + port
+	}
+	if secret, ok := lookupEnv("AUTH_SECRET"); ok && secret != "" {
+		c.AuthSecret = secret
+	}
+	var problems []string
+	walkConfig(reflect.ValueOf(c).Elem(), "", func(path string, v reflect.Value) {
+		name := EnvPrefix + envName(path)
+		s, ok := lookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := setConfigValue(v, s); err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%q: %v", name, s, err))
+		}
+	})
+	if len(problems) > 0 {
+		return fmt.Errorf("bad environment overrides:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// walkConfig calls fn for every setting under v with its JSON path.
+func walkConfig(v reflect.Value, prefix string, fn func(path string, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		path := prefix + t.Field(i).Tag.Get("json")
+		if f := v.Field(i); f.Kind() == reflect.Struct {
+			walkConfig(f, path+".", fn)
+		} else {
+			fn(path, f)
+		}
+	}
+}
+
+// envName turns a JSON path such as "game.playerMaxHP" into GAME_PLAYER_MAX_HP.
+func envName(path string) string {
+	var b strings.Builder
+	runes := []rune(path)
+	for i, r := range runes {
+		if r == '.' {
+			b.WriteByte('_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("not true or false")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("not a whole number")
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, path, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	positive := func(d Duration, path string) {
+		check(d > 0, path, "must be positive")
+	}
+	notNegative := func(d Duration, path string) {
+		check(d >= 0, path, "must not be negative")
+	}
+
+	g := &c.Game
+	check(g.TickRate >= 1 && g.TickRate <= 240, "game.tickRate", "must be 1-240 ticks per second")
+	check(g.BroadcastRate >= 1 && g.BroadcastRate <= g.TickRate, "game.broadcastRate", "must be 1 to game.tickRate")
+	check(g.IdleTickRate >= 1 && g.IdleTickRate <= g.TickRate, "game.idleTickRate", "must be 1 to game.tickRate")
+	check(g.CanvasWidth > 0, "game.canvasWidth", "must be positive")
+	check(g.CanvasHeight > 0, "game.canvasHeight", "must be positive")
+	check(g.MaxPlayers >= 2 && g.MaxPlayers <= len(playerColors), "game.maxPlayers", "must be 2-%d", len(playerColors))
+	positive(g.RoundDuration, "game.roundDuration")
+	notNegative(g.Countdown, "game.countdown")
+	positive(g.OvertimeDuration, "game.overtimeDuration")
+	check(g.PlayerMaxHP >= 1, "game.playerMaxHP", "must be at least 1")
+	check(g.PlayerWidth > 0 && g.PlayerWidth < g.CanvasWidth/2, "game.playerWidth", "must be positive and under half of game.canvasWidth")
+	check(g.PlayerHeight > 0 && g.PlayerHeight < g.CanvasHeight, "game.playerHeight", "must be positive and under game.canvasHeight")
+	check(g.PlayerMaxVelocity > 0, "game.playerMaxVelocity", "must be positive")
+	check(g.PlayerAcceleration > 0, "game.playerAcceleration", "must be positive")
+	check(g.PlayerFriction > 0 && g.PlayerFriction <= 1, "game.playerFriction", "must be above 0 and at most 1")
+	notNegative(g.ShootCooldown, "game.shootCooldown")
+	check(g.BulletRadius > 0, "game.bulletRadius", "must be positive")
+	check(g.BulletSpeed > 0, "game.bulletSpeed", "must be positive")
+	check(g.BulletDamage >= 1, "game.bulletDamage", "must be at least 1")
+	check(g.BulletMaxBounces >= 1, "game.bulletMaxBounces", "must be at least 1; bullets only hurt after a bounce")
+
+	r := &c.Rooms
+	check(validOvertime[r.Overtime], "rooms.overtime", "must be none, sudden_death, shrinking_arena or extra_time")
+	check(validSeed(r.Seed), "rooms.seed", "must be 0 (random) or 1 to %d", int64(MaxSeed))
+	for path, d := range map[string]Duration{
+		"rooms.autoStart": r.AutoStart, "rooms.roomIdle": r.RoomIdle, "rooms.gameOverIdle": r.GameOverIdle,
+		"rooms.afkWarn": r.AFKWarn, "rooms.afkKick": r.AFKKick, "rooms.lobbyIdle": r.LobbyIdle,
+	} {
+		notNegative(d, path)
+	}
+	check(r.AFKWarn == 0 || r.AFKKick == 0 || r.AFKWarn < r.AFKKick, "rooms.afkWarn", "must be shorter than rooms.afkKick")
+
+	n := &c.Network
+	check(n.ReadBufferSize > 0, "network.readBufferSize", "must be positive")
+	check(n.WriteBufferSize > 0, "network.writeBufferSize", "must be positive")
+	check(n.SendQueue > 0, "network.sendQueue", "must be positive")
+	check(n.RoomQueue > 0, "network.roomQueue", "must be positive")
+	positive(n.HandshakeTimeout, "network.handshakeTimeout")
+	positive(n.PongWait, "network.pongWait")
+	check(n.PingPeriod > 0 && n.PingPeriod < n.PongWait, "network.pingPeriod", "must be positive and shorter than network.pongWait")
+	positive(n.WriteWait, "network.writeWait")
+
+	s := &c.Storage
+	notNegative(s.ReplayMaxAge, "storage.replayMaxAge")
+	check(s.ReplayMaxFiles >= 0, "storage.replayMaxFiles", "must not be negative")
+
+	l := &c.Log
+	_, err := parseLogLevel(l.Level)
+	check(err == nil, "log.level", "must be debug, info, warn or error")
+	check(l.Format == LogFormatText || l.Format == LogFormatJSON, "log.format", "must be text or json")
+	check(l.SampleBurst >= 0, "log.sampleBurst", "must not be negative")
+	check(l.SampleBurst == 0 || l.SampleInterval > 0, "log.sampleInterval", "must be positive when log.sampleBurst is set")
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// Redacted is the config with secrets blanked, for printing.
+func (c Config) Redacted() Config {
+	if c.AuthSecret != "" {
+		c.AuthSecret = "(redacted)"
+	}
+	return c
+}
+
+// configure sets the hub's config without logging it as a reload.
+func (h *Hub) configure(c Config) {
+	h.cfg.Store(&c)
+}
+
+// config returns the running config. It is never modified; a reload swaps
+// in a new one, so callers may keep it for as long as they need one
+// consistent view.
+func (h *Hub) config() *Config {
+	return h.cfg.Load()
+}
+
+// Names the stores get inside storage.dataDir when no path is set
+const (
+	HistoryFile  = "matches.jsonl"
+	AccountsFile = "accounts.jsonl"
+	ReplaysDir   = "replays"
+)
+
+// paths returns where match history, accounts and replays are kept. With a
+// data directory every store is kept, under its default name unless a path
+// is set; an empty result keeps that store in memory.
+func (s StorageConfig) paths() (history, accounts, replays string) {
+	resolve := func(path, name string) string {
+		if s.DataDir == "" {
+			return path
+		}
+		if path == "" {
+			path = name
+		}
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(s.DataDir, path)
+	}
+	return resolve(s.History, HistoryFile), resolve(s.Accounts, AccountsFile), resolve(s.Replays, ReplaysDir)
+}
+
+func (r RoomConfig) idleTimeouts() IdleTimeouts {
+	return IdleTimeouts{
+		RoomWaiting:  r.RoomIdle.std(),
+		RoomGameOver: r.GameOverIdle.std(),
+		AFKWarn:      r.AFKWarn.std(),
+		AFKKick:      r.AFKKick.std(),
+		Lobby:        r.LobbyIdle.std(),
+	}
+}
+
+// Duration is a time.Duration written as a string such as "30s" in config
+// files and environment variables.
+type Duration time.Duration
+
+func (d Duration) std() time.Duration           { return time.Duration(d) }
+func (d *Duration) ptr() *time.Duration         { return (*time.Duration)(d) }
+func (d Duration) Seconds() float64             { return time.Duration(d).Seconds() }
+func (d Duration) String() string               { return time.Duration(d).String() }
+func (d Duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 30s or 5m", b)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Derived values rooms use
+
+func (g GameConfig) tickInterval() time.Duration { return time.Second / time.Duration(g.TickRate) }
+func (g GameConfig) broadcastInterval() time.Duration {
+	return time.Second / time.Duration(g.BroadcastRate)
+}
+func (g GameConfig) idleInterval() time.Duration { return time.Second / time.Duration(g.IdleTickRate) }
+
+// arena is the whole canvas.
+func (g GameConfig) arena() Rect {
+	return Rect{X: 0, Y: 0, W: g.CanvasWidth, H: g.CanvasHeight}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	file := `{"addr": ":7000", "game": {"playerMaxHP": 6, "tickRate": 60}, "rooms": {"afkKick": "2m"}}`
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"PORT":                      "7100",
+		"SERVER_GAME_PLAYER_MAX_HP": "8",
+		"SERVER_NETWORK_SEND_QUEUE": "64",
+	}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	defaults := DefaultConfig()
+	bindFlags(fs, &defaults)
+	if err := fs.Parse([]string{"-afk-kick", "3m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path, lookup, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":7100" {
+		t.Errorf("addr = %q, want PORT to override the file", cfg.Addr)
+	}
+	if cfg.Game.PlayerMaxHP != 8 || cfg.Game.TickRate != 60 {
+		t.Errorf("playerMaxHP, tickRate = %d, %d, want 8 from env and 60 from file", cfg.Game.PlayerMaxHP, cfg.Game.TickRate)
+	}
+	if cfg.Network.SendQueue != 64 {
+		t.Errorf("sendQueue = %d, want 64", cfg.Network.SendQueue)
+	}
+	if cfg.Rooms.AFKKick.std() != 3*time.Minute {
+		t.Errorf("afkKick = %v, want the flag's 3m", cfg.Rooms.AFKKick)
+	}
+	if cfg.Rooms.AFKWarn != DefaultConfig().Rooms.AFKWarn {
+		t.Errorf("afkWarn = %v, want the default", cfg.Rooms.AFKWarn)
+	}
+
+	env["SERVER_GAME_BROADCAST_RATE"] = "90"
+	if _, err := LoadConfig(path, lookup, nil); err == nil {
+		t.Error("broadcast rate above the tick rate passed validation")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for path, want := range map[string]string{
+		"addr":                   "ADDR",
+		"game.playerMaxHP":       "GAME_PLAYER_MAX_HP",
+		"rooms.afkKick":          "ROOMS_AFK_KICK",
+		"network.readBufferSize": "NETWORK_READ_BUFFER_SIZE",
+	} {
+		if got := envName(path); got != want {
+			t.Errorf("envName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestHubReload(t *testing.T) {
+	h := NewHub()
+	before := NewGameRoom("before", "ABCDEF", h, RoomOptions{})
+
+	cfg := DefaultConfig()
+	cfg.Addr = ":9999"
+	cfg.Game.PlayerMaxHP = 9
+	cfg.Rooms.AutoStart = Duration(5 * time.Second)
+	h.reload(cfg)
+
+	after := NewGameRoom("after", "GHJKLM", h, RoomOptions{})
+	if before.cfg.PlayerMaxHP != DefaultConfig().Game.PlayerMaxHP {
+		t.Errorf("existing room's playerMaxHP = %d, want it unchanged", before.cfg.PlayerMaxHP)
+	}
+	if after.cfg.PlayerMaxHP != 9 || after.roomCfg.AutoStart.std() != 5*time.Second {
+		t.Errorf("new room got playerMaxHP %d, autoStart %v; want 9, 5s", after.cfg.PlayerMaxHP, after.roomCfg.AutoStart)
+	}
+	if got := h.config().Addr; got != DefaultConfig().Addr {
+		t.Errorf("addr = %q after reload, want the restart-only value kept", got)
+	}
+}
+
+func TestSpawnPointsDistinct(t *testing.T) {
+	g := DefaultConfig().Game
+	for max := 2; max <= len(playerColors); max++ {
+		g.MaxPlayers = max
+		for round := 0; round < 3; round++ {
+			seen := make(map[Vector2D]int)
+			for slot := 0; slot < max; slot++ {
+				p := g.spawnPoint(slot + round)
+				if other, ok := seen[p]; ok {
+					t.Fatalf("maxPlayers %d, round %d: slots %d and %d both spawn at %v", max, round, other, slot, p)
+				}
+				seen[p] = slot
+				if p.Y < 0 || p.Y+g.PlayerHeight > g.CanvasHeight {
+					t.Errorf("maxPlayers %d: slot %d spawns off the canvas at %v", max, slot, p)
+				}
+			}
+		}
+	}
+}
+
+func TestStoragePaths(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		s    StorageConfig
+		want [3]string
+	}{
+		{"defaults keep everything in memory", StorageConfig{}, [3]string{"", "", ""}},
+		{"paths without a data dir", StorageConfig{History: "m.jsonl"}, [3]string{"m.jsonl", "", ""}},
+		{"data dir", StorageConfig{DataDir: "/srv/game"},
+			[3]string{"/srv/game/matches.jsonl", "/srv/game/accounts.jsonl", "/srv/game/replays"}},
+		{"relative path in the data dir", StorageConfig{DataDir: "data", Replays: "rec"},
+			[3]string{"data/matches.jsonl", "data/accounts.jsonl", "data/rec"}},
+		{"absolute path outside it", StorageConfig{DataDir: "data", Accounts: "/var/accounts.jsonl"},
+			[3]string{"data/matches.jsonl", "/var/accounts.jsonl", "data/replays"}},
+	} {
+		h, a, r := tc.s.paths()
+		if got := [3]string{h, a, r}; got != tc.want {
+			t.Errorf("%s: paths %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+)
+
+// CountdownDuration is the default pause between everyone readying up and
+// the round starting. Players are frozen at their spawn points while it runs.
+const CountdownDuration = 3 * time.Second
+
+// startCountdown moves the room into StateCountdown.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) startCountdown(now time.Time) {
+	gr.State = StateCountdown
+	gr.WinnerID = ""
+	gr.ratingChanges = nil
+	gr.bullets = make(map[string]*Bullet)
+	gr.countdownEnds = now.Add(gr.cfg.Countdown.std())
+	gr.autoStartAt = time.Time{}
+	gr.moveToSpawns()
+	for _, p := range gr.players {
+		p.InputX = 0
+		p.InputY = 0
+	}
+	gr.idleSince = now
+	gr.log.Info("Round starts after countdown", "countdown", gr.cfg.Countdown)
+	gr.hub.roomChanged(gr)
+}
+
+// cancelCountdown returns the room to waiting after someone un-readied or left.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) cancelCountdown(reason string) {
+	gr.State = StateWaitingForPlayers
+	gr.countdownEnds = time.Time{}
+	gr.idleSince = time.Now()
+	gr.log.Info("Countdown cancelled", "reason", reason)
+	gr.hub.roomChanged(gr)
+}
+
+// updateAutoStart arms the auto-start timer once the room is full and a
+// majority of it is ready, and disarms it when that stops being true.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) updateAutoStart(now time.Time) {
+	delay := gr.roomCfg.AutoStart.std()
+	armed := delay > 0 &&
+		gr.State == StateWaitingForPlayers &&
+		len(gr.players) == gr.cfg.MaxPlayers &&
+		2*len(gr.readyPlayers) > len(gr.players)
+	switch {
+	case !armed:
+		gr.autoStartAt = time.Time{}
+	case gr.autoStartAt.IsZero():
+		gr.autoStartAt = now.Add(delay)
+		gr.log.Info("Auto-start armed", "delay", delay)
+	}
+}
+
+// advanceCountdown starts the countdown when the auto-start timer fires and
+// the round when the countdown ends. It reports whether the state changed.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) advanceCountdown(now time.Time) bool {
+	switch {
+	case gr.State == StateCountdown && !now.Before(gr.countdownEnds):
+		gr.log.Info("Countdown finished; starting game")
+		gr.startGame()
+		return true
+	case gr.State == StateWaitingForPlayers && !gr.autoStartAt.IsZero() && !now.Before(gr.autoStartAt):
+		gr.log.Info("Auto-starting", "ready", len(gr.readyPlayers), "players", len(gr.players))
+		gr.startCountdown(now)
+		return true
+	}
+	return false
+}
+
+// secondsUntil is the time left before t for display, or zero if t is unset.
+func secondsUntil(t, now time.Time) float64 {
+	if t.IsZero() || !now.Before(t) {
+		return 0
+	}
+	return t.Sub(now).Seconds()
+}
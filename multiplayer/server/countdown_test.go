@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countdownRoom returns a waiting room for maxPlayers with players seated,
+// auto-starting after delay.
+func countdownRoom(maxPlayers, players int, delay time.Duration) *GameRoom {
+	h := NewHub()
+	cfg := DefaultConfig()
+	cfg.Game.MaxPlayers = maxPlayers
+	cfg.Rooms.AutoStart = Duration(delay)
+	h.reload(cfg)
+	room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+	for i := 0; i < players; i++ {
+		id := fmt.Sprint("p", i)
+		room.players[id] = &Player{ID: id}
+		room.addToJoinOrder(id)
+	}
+	return room
+}
+
+func TestAutoStartThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		max, seated, ready int
+		delay              time.Duration
+		armed              bool
+	}{
+		{4, 4, 1, time.Minute, false},
+		{4, 4, 2, time.Minute, false}, // half is not a majority
+		{4, 4, 3, time.Minute, true},
+		{4, 4, 4, time.Minute, true},
+		{3, 3, 2, time.Minute, true},
+		{4, 3, 3, time.Minute, false}, // not full
+		{4, 4, 3, 0, false},           // auto-start disabled
+	} {
+		room := countdownRoom(tc.max, tc.seated, tc.delay)
+		now := time.Now()
+		for i := 0; i < tc.ready; i++ {
+			room.readyPlayers[fmt.Sprint("p", i)] = true
+		}
+		room.updateAutoStart(now)
+		if armed := !room.autoStartAt.IsZero(); armed != tc.armed {
+			t.Errorf("%d of %d seated (max %d) ready, delay %v: armed %v, want %v",
+				tc.ready, tc.seated, tc.max, tc.delay, armed, tc.armed)
+		}
+	}
+
+	// Dropping below the threshold disarms the timer, and arming again
+	// starts it afresh rather than resuming
+	room := countdownRoom(4, 4, time.Minute)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		room.readyPlayers[fmt.Sprint("p", i)] = true
+	}
+	room.updateAutoStart(start)
+	delete(room.readyPlayers, "p2")
+	room.updateAutoStart(start.Add(time.Second))
+	if !room.autoStartAt.IsZero() {
+		t.Fatal("auto-start still armed with only half the room ready")
+	}
+	room.readyPlayers["p3"] = true
+	room.updateAutoStart(start.Add(2 * time.Second))
+	if want := start.Add(2*time.Second + time.Minute); !room.autoStartAt.Equal(want) {
+		t.Errorf("re-armed for %v, want %v", room.autoStartAt, want)
+	}
+
+	// The timer firing starts the countdown
+	if room.advanceCountdown(room.autoStartAt.Add(-time.Millisecond)) || room.State != StateWaitingForPlayers {
+		t.Fatal("auto-start fired early")
+	}
+	if !room.advanceCountdown(room.autoStartAt) || room.State != StateCountdown {
+		t.Fatalf("state %s after the auto-start timer, want %s", room.State, StateCountdown)
+	}
+}
+
+func TestCountdownCancelsWhenAPlayerLeaves(t *testing.T) {
+	room := countdownRoom(4, 3, time.Minute)
+	room.startCountdown(time.Now())
+	room.dropPlayer("p1")
+	if room.State != StateWaitingForPlayers || !room.countdownEnds.IsZero() {
+		t.Fatalf("after a player left: state %s, countdown ends %v; want waiting and no countdown", room.State, room.countdownEnds)
+	}
+	if room.advanceCountdown(time.Now().Add(time.Hour)) {
+		t.Error("cancelled countdown still started the round")
+	}
+}
+
+func TestCountdownStartsTheRound(t *testing.T) {
+	room := countdownRoom(2, 2, 0)
+	start := time.Now()
+	room.startCountdown(start)
+	if room.State != StateCountdown {
+		t.Fatalf("state %s, want %s", room.State, StateCountdown)
+	}
+	ends := start.Add(room.cfg.Countdown.std())
+	if !room.countdownEnds.Equal(ends) {
+		t.Errorf("countdown ends %v, want %v", room.countdownEnds, ends)
+	}
+	if room.advanceCountdown(ends.Add(-time.Millisecond)) || room.State != StateCountdown {
+		t.Fatal("round started before the countdown ended")
+	}
+	if !room.advanceCountdown(ends) || room.State != StateInProgress {
+		t.Fatalf("state %s when the countdown ended, want %s", room.State, StateInProgress)
+	}
+}
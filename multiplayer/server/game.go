@@ -1,15 +1,20 @@
 package main
 
 import (
-	"log"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
 	"math"
-	"math/rand"
+	"math/rand/v2"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
+// Gameplay defaults. Rooms play by their GameConfig; see DefaultConfig.
 const (
 	PlayerMaxHP         = 10
 	PlayerWidth         = 50.0
@@ -31,7 +36,9 @@ const (
 // Game Room constants
 const (
 	StateWaitingForPlayers = "waiting"
+	StateCountdown         = "countdown"
 	StateInProgress        = "in_progress"
+	StateOvertime          = "overtime"
 	StateGameOver          = "game_over"
 )
 
@@ -39,6 +46,7 @@ var playerColors = []string{"blue", "red", "yellow", "purple", "orange", "cyan"}
 
 type Player struct {
 	ID               string  `json:"id"`
+	Name             string  `json:"name"`
 	X                float64 `json:"x"`
 	Y                float64 `json:"y"`
 	Width            float64 `json:"width"`
@@ -51,7 +59,12 @@ type Player struct {
 	InputX           float64 `json:"-"`
 	InputY           float64 `json:"-"`
 	ShootingCooldown float64 `json:"shootingCooldown"`
+	Rating           int     `json:"rating"`
+	Slot             int     `json:"slot"` // stable seat index: spawn side and color
 	conn             *ClientConn
+	lastInput        time.Time // last input or shot, for AFK detection
+	afkWarned        bool
+	stats            PlayerStats // this round's counters, for match history
 }
 
 type Bullet struct {
@@ -68,6 +81,7 @@ type Bullet struct {
 
 type GameState struct {
 	RoomID              string             `json:"roomId"`
+	RoomCode            string             `json:"roomCode"`
 	Players             map[string]*Player `json:"players"`
 	Bullets             map[string]*Bullet `json:"bullets"`
 	State               string             `json:"state"`
@@ -75,13 +89,60 @@ type GameState struct {
 	ReadyPlayers        map[string]bool    `json:"readyPlayers"`
 	TimeRemaining       float64            `json:"timeRemaining"`
 	ShootCooldownMax    float64            `json:"shootCooldownMax"`
+	Private             bool               `json:"private"`
+	HasPassword         bool               `json:"hasPassword"`
+	Mode                string             `json:"mode"`
+	Map                 string             `json:"map"`
+	HostID              string             `json:"hostId"`
+	Locked              bool               `json:"locked"`
+	Overtime            string             `json:"overtime"` // policy used if regular time ends level
+	Arena               Rect               `json:"arena"`    // playable area; shrinks in shrinking-arena overtime
+	CanvasWidth         float64            `json:"canvasWidth"`
+	CanvasHeight        float64            `json:"canvasHeight"`
+	SwapSides           bool               `json:"swapSides"`
+	Record              bool               `json:"record"` // rounds are saved as replays
+	Seed                int64              `json:"seed"`   // RNG seed of the current or last round
+	Countdown           float64            `json:"countdown,omitempty"`   // seconds until the round starts
+	AutoStartIn         float64            `json:"autoStartIn,omitempty"` // seconds until the countdown starts on its own
+	RatingChanges       map[string]int     `json:"ratingChanges,omitempty"` // set once a round has finished
 }
 
 type GameRoom struct {
-	ID  string
-	hub *Hub
+	ID   string
+	Code string // short human-friendly code, unique among live rooms
+	hub  *Hub
+	log  *slog.Logger // with the room ID attached
+
+	createdAt time.Time  // set once at creation, read without the room lock
+	cfg       GameConfig // the hub's settings when the room was created; kept across reloads
+	roomCfg   RoomConfig
 
 	sync.RWMutex
+
+	// Chosen by the creator; the host may change them while waiting
+	private      bool
+	mode         string
+	mapName      string
+	overtime     string
+	swapSides    bool // players change spawn side every round
+	hasPassword  bool
+	passwordHash [sha256.Size]byte
+
+	hostID    string
+	joinOrder []string        // player IDs, longest in the room first
+	locked    bool            // no new players may join
+	kicked    map[string]bool // identities the host removed; they may not rejoin
+
+	holds        map[string]time.Time // player ID -> seat held until, for accepted joiners
+	joinRequests chan joinRequest
+	releaseHold  chan string
+	done         chan struct{} // closed when Run returns
+
+	idleSince time.Time // last join or state change, for the idle room reaper
+
+	countdownEnds time.Time // when StateCountdown turns into StateInProgress
+	autoStartAt   time.Time // when a full room with a ready majority starts its countdown; zero if not armed
+
 	players           map[string]*Player
 	bullets           map[string]*Bullet
 	clients           map[*ClientConn]bool
@@ -91,11 +152,29 @@ type GameRoom struct {
 	playerShootChan   chan PlayerShootAction
 	playerReadyChan   chan string
 	playerRestartChan chan string
+	playerUnreadyChan chan string
+	chatChan          chan ChatMessage
+	hostActions       chan hostAction
+	chatLog           *chatHistory // owned by Run
 
 	State         string `json:"state"`
 	WinnerID      string `json:"winnerId"`
 	readyPlayers  map[string]bool
-	timeRemaining float64 // seconds remaining in current round
+	timeRemaining float64        // seconds remaining in current round or overtime
+	arena         Rect           // playable area
+	round         int            // rounds finished, for swapping sides
+	roundStart    time.Time      // when the current round left the countdown
+	hadOvertime   bool           // the current round went to overtime
+	matchID       string         // ID of the current or last round's match record
+	seed          int64          // RNG seed of the current or last round
+	seedFixed     bool           // every round reuses seed instead of picking one
+	rng           *rand.ChaCha8  // the round's random source; see seedRound
+	tick          int64          // physics ticks into the current round
+	record        bool           // save replays of this room's rounds
+	replay        *replayRecorder
+	readyLog      []ReplayEvent  // ready actions since the last round, for its replay
+	frames        *ReplayLog     // gameState messages sent this round, for playback
+	ratingChanges map[string]int // result of the last finished round
 }
 
 type PlayerInputAction struct {
@@ -116,115 +195,190 @@ type PlayerRestartAction struct {
 	PlayerID string
 }
 
-func NewGameRoom(id string, hub *Hub) *GameRoom {
-	return &GameRoom{
+func NewGameRoom(id, code string, hub *Hub, opts RoomOptions) *GameRoom {
+	cfg := hub.config()
+	gr := &GameRoom{
 		ID:                id,
+		Code:              code,
 		hub:               hub,
+		log:               slog.With("room", id),
+		private:           opts.Private,
+		mode:              opts.Mode,
+		mapName:           opts.Map,
+		overtime:          opts.Overtime,
+		swapSides:         opts.SwapSides,
+		record:            !opts.NoReplay,
+		seed:              opts.Seed,
+		seedFixed:         opts.Seed != 0,
+		cfg:               cfg.Game,
+		roomCfg:           cfg.Rooms,
+		arena:             cfg.Game.arena(),
+		createdAt:         time.Now(),
+		idleSince:         time.Now(),
 		players:           make(map[string]*Player),
 		bullets:           make(map[string]*Bullet),
 		clients:           make(map[*ClientConn]bool),
 		register:          make(chan *ClientConn, 4),
 		unregister:        make(chan *ClientConn, 4),
-		playerInputChan:   make(chan PlayerInputAction, 16),
-		playerShootChan:   make(chan PlayerShootAction, 16),
+		playerInputChan:   make(chan PlayerInputAction, cfg.Network.RoomQueue),
+		playerShootChan:   make(chan PlayerShootAction, cfg.Network.RoomQueue),
 		playerReadyChan:   make(chan string, 4),
 		playerRestartChan: make(chan string, 4),
+		playerUnreadyChan: make(chan string, 4),
+		chatChan:          make(chan ChatMessage, cfg.Network.RoomQueue),
+		hostActions:       make(chan hostAction, 4),
+		kicked:            make(map[string]bool),
+		holds:             make(map[string]time.Time),
+		joinRequests:      make(chan joinRequest, 16),
+		releaseHold:       make(chan string, 4),
+		done:              make(chan struct{}),
+		chatLog:           newChatHistory(ChatHistorySize),
 		State:             StateWaitingForPlayers,
 		readyPlayers:      make(map[string]bool),
 	}
+	if opts.Password != "" {
+		gr.hasPassword = true
+		gr.passwordHash = sha256.Sum256([]byte(opts.Password))
+	}
+	return gr
 }
 
-// getCreatorName returns a short player ID for display.
+// checkPassword reports whether password matches the room's password.
 // Caller must hold at least a read lock on gr.
-func (gr *GameRoom) getCreatorName() string {
-	for _, p := range gr.players {
-		return p.ID[:6]
-	}
-	return "Empty"
+func (gr *GameRoom) checkPassword(password string) bool {
+	hash := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(hash[:], gr.passwordHash[:]) == 1
 }
 
+
 func (gr *GameRoom) Run() {
 	// gameTicker drives physics at 60fps — only active during in_progress
-	gameTicker := time.NewTicker(GameTickRate)
+	gameTicker := time.NewTicker(gr.cfg.tickInterval())
 	defer gameTicker.Stop()
 
 	// idleTicker sends periodic state updates at 5fps when waiting/game_over
 	// so clients stay in sync without burning CPU on 60 goroutine wakeups/s
-	idleTicker := time.NewTicker(IdleTickRate)
+	idleTicker := time.NewTicker(gr.cfg.idleInterval())
 	defer idleTicker.Stop()
 
 	// broadcastTicker drives state broadcasts at 30fps — only active during in_progress
-	broadcastTicker := time.NewTicker(BroadcastTickRate)
+	broadcastTicker := time.NewTicker(gr.cfg.broadcastInterval())
 	defer broadcastTicker.Stop()
 
+	defer close(gr.done)
+
 	for {
 		select {
+		case req := <-gr.joinRequests:
+			res := gr.admit(req)
+			req.result <- res
+			if res.code == "" {
+				gr.hub.roomChanged(gr)
+			}
+
+		case playerID := <-gr.releaseHold:
+			gr.Lock()
+			delete(gr.holds, playerID)
+			empty := gr.isEmpty()
+			gr.Unlock()
+			if empty {
+				gr.log.Info("Room is now empty; signalling hub for removal")
+				go func() {
+					gr.hub.unregisterRoom <- gr
+				}()
+				return
+			}
+			gr.hub.roomChanged(gr)
+
 		case client := <-gr.register:
 			gr.Lock()
-			gr.clients[client] = true
+			if _, held := gr.holds[client.id]; held {
+				delete(gr.holds, client.id)
+			} else if gr.seatsTaken() >= gr.cfg.MaxPlayers {
+				// Only reachable if the client's hold expired on the way in
+				gr.Unlock()
+				gr.log.Warn("Client arrived at full room without a seat hold", "client", client)
+				client.sendError(ErrCodeRoomFull, "Room is full")
+				gr.hub.returnToLobby(client)
+				continue
+			}
 			playerID := client.id
+			slot := gr.freeSlot()
+			spawn := gr.spawnFor(slot)
 			newPlayer := &Player{
 				ID:               playerID,
-				X:                rand.Float64() * (CanvasWidth - PlayerWidth),
-				Y:                rand.Float64() * (CanvasHeight - PlayerHeight),
-				Width:            PlayerWidth,
-				Height:           PlayerHeight,
-				Color:            playerColors[rand.Intn(len(playerColors))],
-				CurrentHP:        PlayerMaxHP,
-				MaxHP:            PlayerMaxHP,
+				Name:             client.Name(),
+				X:                spawn.X,
+				Y:                spawn.Y,
+				Width:            gr.cfg.PlayerWidth,
+				Height:           gr.cfg.PlayerHeight,
+				Color:            slotColor(slot),
+				Slot:             slot,
+				CurrentHP:        gr.cfg.PlayerMaxHP,
+				MaxHP:            gr.cfg.PlayerMaxHP,
 				ShootingCooldown: 0,
+				Rating:           int(math.Round(gr.hub.ratings.Get(playerID).Rating)),
 				conn:             client,
 			}
-			gr.players[playerID] = newPlayer
+			// A client that disconnected on the way in has already looked for
+			// its room in readPump and found none, so nobody would unregister
+			// it. Checking done under roomMu closes that gap: either readPump
+			// sees the room set here, or the seat is given up now.
 			client.roomMu.Lock()
-			client.room = gr
-			client.player = newPlayer
+			gone := false
+			select {
+			case <-client.done:
+				gone = true
+			default:
+				client.room = gr
+				client.player = newPlayer
+			}
 			client.roomMu.Unlock()
-			log.Printf("Player %s registered and added to game room %s.", playerID, gr.ID)
+			if gone {
+				empty := gr.isEmpty() // its hold was released above
+				gr.Unlock()
+				gr.log.Info("Client disconnected before taking its seat", "client", client)
+				if empty {
+					gr.log.Info("Room is now empty; signalling hub for removal")
+					go func() {
+						gr.hub.unregisterRoom <- gr
+					}()
+					return
+				}
+				gr.hub.roomChanged(gr)
+				continue
+			}
+			gr.clients[client] = true
+			gr.players[playerID] = newPlayer
+			gr.addToJoinOrder(playerID)
+			gr.idleSince = time.Now()
+			gr.updateAutoStart(time.Now())
+			gr.log.Info("Player added to room", "client", client, "state", gr.State)
 			gr.Unlock()
+			gr.hub.roomChanged(gr)
 			// Immediately push current state to the new client
 			gr.broadcastGameState()
+			// Late joiners catch up on the conversation
+			client.sendMessage("chat_history", map[string]interface{}{
+				"scope":    ChatScopeRoom,
+				"messages": gr.chatLog.snapshot(),
+			})
 
 		case client := <-gr.unregister:
-			gr.Lock()
-
-			log.Printf("Processing unregister for client %s in room %s", client.id, gr.ID)
-
-			if _, ok := gr.clients[client]; !ok {
-				log.Printf("Client %s was not in room %s, skipping unregister", client.id, gr.ID)
-				gr.Unlock()
+			gr.log.Debug("Processing unregister", "client", client.id)
+			removed, empty := gr.removeClient(client)
+			if !removed {
+				gr.log.Debug("Client was not in room; skipping unregister", "client", client.id)
 				continue
 			}
-
-			wasInProgress := gr.State == StateInProgress || gr.State == StateGameOver
-
-			delete(gr.clients, client)
-			if client.player != nil {
-				log.Printf("Player %s (%s) unregistered from room %s", client.player.Color, client.id, gr.ID)
-				delete(gr.players, client.player.ID)
-				delete(gr.readyPlayers, client.player.ID)
-			}
-
-			client.roomMu.Lock()
-			client.room = nil
-			client.player = nil
-			client.roomMu.Unlock()
-
-			if wasInProgress && len(gr.players) < 2 {
-				log.Printf("Player left mid-game. Resetting room %s to waiting state.", gr.ID)
-				gr.resetGame()
-			}
-
-			if len(gr.clients) == 0 {
-				log.Printf("Room %s is now empty. Signalling hub for removal.", gr.ID)
-				gr.Unlock()
+			if empty {
+				gr.log.Info("Room is now empty; signalling hub for removal")
 				go func() {
 					gr.hub.unregisterRoom <- gr
 				}()
 				return
 			}
-
-			gr.Unlock()
+			gr.hub.roomChanged(gr)
 			gr.broadcastGameState()
 
 		case playerID := <-gr.playerReadyChan:
@@ -232,26 +386,44 @@ func (gr *GameRoom) Run() {
 			if gr.State == StateWaitingForPlayers || gr.State == StateGameOver {
 				if _, ok := gr.players[playerID]; ok {
 					gr.readyPlayers[playerID] = true
-					log.Printf("Player %s is ready.", playerID)
+					gr.recordReady(ReplayReady, playerID)
+					gr.log.Info("Player is ready", "player", playerID)
 
 					if len(gr.players) >= 2 && len(gr.readyPlayers) == len(gr.players) {
-						log.Println("Both players are ready. Starting game!")
-						gr.startGame()
+						gr.log.Info("All players are ready; starting countdown")
+						gr.startCountdown(time.Now())
 					}
+					gr.updateAutoStart(time.Now())
 				}
 			}
 			gr.Unlock()
 			// Push ready/game-start state immediately — no need to wait for idle tick
 			gr.broadcastGameState()
 
+		case playerID := <-gr.playerUnreadyChan:
+			gr.Lock()
+			if gr.State == StateWaitingForPlayers || gr.State == StateCountdown {
+				if gr.readyPlayers[playerID] {
+					delete(gr.readyPlayers, playerID)
+					gr.recordReady(ReplayUnready, playerID)
+					gr.log.Info("Player is no longer ready", "player", playerID)
+					if gr.State == StateCountdown {
+						gr.cancelCountdown("player un-readied")
+					}
+					gr.updateAutoStart(time.Now())
+				}
+			}
+			gr.Unlock()
+			gr.broadcastGameState()
+
 		case playerID := <-gr.playerRestartChan:
 			gr.Lock()
 			if gr.State == StateGameOver {
 				gr.readyPlayers[playerID] = true
-				log.Printf("Player %s wants to restart.", playerID)
+				gr.log.Info("Player wants to restart", "player", playerID)
 
 				if len(gr.readyPlayers) == len(gr.players) && len(gr.players) > 0 {
-					log.Println("All players agreed to restart. Resetting game.")
+					gr.log.Info("All players agreed to restart; resetting game")
 					gr.resetGame()
 				}
 			}
@@ -260,18 +432,23 @@ func (gr *GameRoom) Run() {
 
 		case inputAction := <-gr.playerInputChan:
 			gr.Lock()
-			if gr.State == StateInProgress {
+			if gr.inRound() {
 				if player, ok := gr.players[inputAction.PlayerID]; ok {
 					player.InputX = inputAction.Input.X
 					player.InputY = inputAction.Input.Y
+					player.lastInput = time.Now()
+					player.afkWarned = false
+					gr.recordEvent(ReplayInput, player.ID, player.InputX, player.InputY)
 				}
 			}
 			gr.Unlock()
 
 		case shootAction := <-gr.playerShootChan:
 			gr.Lock()
-			if gr.State == StateInProgress {
+			if gr.inRound() {
 				if player, ok := gr.players[shootAction.PlayerID]; ok {
+					player.lastInput = time.Now()
+					player.afkWarned = false
 					if player.ShootingCooldown <= 0 {
 						playerCenterX := player.X + player.Width/2
 						playerCenterY := player.Y + player.Height/2
@@ -279,7 +456,7 @@ func (gr *GameRoom) Run() {
 						rawDir := NewVector2D(shootAction.TargetPos.X-playerCenterX, shootAction.TargetPos.Y-playerCenterY)
 						if rawDir.Magnitude() >= 0.001 {
 							direction := rawDir.Normalize()
-							bulletID := uuid.NewString()
+							bulletID := gr.newID()
 							newBullet := &Bullet{
 								ID:                bulletID,
 								OwnerID:           player.ID,
@@ -287,23 +464,56 @@ func (gr *GameRoom) Run() {
 								Y:                 playerCenterY,
 								DirX:              direction.X,
 								DirY:              direction.Y,
-								Radius:            BulletRadius,
+								Radius:            gr.cfg.BulletRadius,
 								TimesCollidedWall: 0,
 							}
 							gr.bullets[bulletID] = newBullet
-							player.ShootingCooldown = PlayerShootCooldown
-							log.Printf("Player %s shot. Bullet %s created.", player.ID, bulletID)
+							player.ShootingCooldown = gr.shootCooldown()
+							player.stats.ShotsFired++
+							gr.recordEvent(ReplayShoot, player.ID, shootAction.TargetPos.X, shootAction.TargetPos.Y)
+							gr.log.Debug("Player shot", "player", player.ID, "bullet", bulletID, "tick", gr.tick)
 						}
 					}
 				}
 			}
 			gr.Unlock()
 
+		case action := <-gr.hostActions:
+			gr.handleHostAction(action)
+
+		case chatMsg := <-gr.chatChan:
+			gr.chatLog.add(chatMsg)
+			gr.broadcastChat(chatMsg)
+
 		case <-idleTicker.C:
+			// Give back seats whose joiners never arrived
+			now := time.Now()
+			gr.Lock()
+			expired := gr.expireHolds(now)
+			empty := gr.isEmpty()
+			changed := gr.advanceCountdown(now)
+			gr.Unlock()
+			if changed {
+				gr.broadcastGameState()
+			}
+			if empty {
+				// Holds expired, or the last player left through leave_room
+				gr.log.Info("Room is now empty; signalling hub for removal")
+			}
+			if empty || gr.checkIdle(now) {
+				go func() {
+					gr.hub.unregisterRoom <- gr
+				}()
+				return
+			}
+			if expired {
+				gr.hub.roomChanged(gr)
+			}
+
 			// Low-frequency heartbeat for waiting/game_over — skip during in_progress
 			// (gameTicker handles that path instead)
 			gr.RLock()
-			notInProgress := gr.State != StateInProgress
+			notInProgress := !gr.inRound()
 			gr.RUnlock()
 			if notInProgress {
 				gr.broadcastGameState()
@@ -312,7 +522,7 @@ func (gr *GameRoom) Run() {
 		case <-broadcastTicker.C:
 			// Broadcast state at 30fps — skip when not in_progress
 			gr.RLock()
-			notInProgress := gr.State != StateInProgress
+			notInProgress := !gr.inRound()
 			gr.RUnlock()
 			if notInProgress {
 				continue
@@ -322,60 +532,47 @@ func (gr *GameRoom) Run() {
 		case <-gameTicker.C:
 			// Physics tick — skip entirely when not in_progress
 			gr.RLock()
-			notInProgress := gr.State != StateInProgress
+			notInProgress := !gr.inRound()
 			gr.RUnlock()
 			if notInProgress {
 				continue
 			}
 
-			deltaTime := GameTickRate.Seconds()
+			tickStart := time.Now()
+			cfg := &gr.cfg
+			deltaTime := cfg.tickInterval().Seconds()
 			gr.Lock()
+			gr.tick++
 
 			// Update round timer
 			gr.timeRemaining -= deltaTime
 			if gr.timeRemaining <= 0 {
-				gr.timeRemaining = 0
-				// Determine winner by HP
-				var highestHP int = -1
-				var winnerId string
-				var tie bool
-				for _, p := range gr.players {
-					if p.CurrentHP > highestHP {
-						highestHP = p.CurrentHP
-						winnerId = p.ID
-						tie = false
-					} else if p.CurrentHP == highestHP {
-						tie = true
-					}
-				}
-				if !tie {
-					gr.WinnerID = winnerId
-				} else {
-					gr.WinnerID = "" // draw
-				}
-				gr.State = StateGameOver
-				log.Printf("Round time expired in room %s. WinnerID: %s (tie=%v)", gr.ID, gr.WinnerID, tie)
+				// Game over, or overtime if the players are level
+				gr.timeUp()
 				gr.Unlock()
+				gr.hub.metrics.tickDuration.observe(time.Since(tickStart))
 				gr.broadcastGameState()
 				continue
 			}
+			gr.shrinkArena()
+			arena := gr.arena
 
 			// Update Players
 			for _, player := range gr.players {
 				if player.InputX != 0 || player.InputY != 0 {
-					accelX := player.InputX * PlayerAcceleration * deltaTime
-					accelY := player.InputY * PlayerAcceleration * deltaTime
+					accelX := player.InputX * cfg.PlayerAcceleration * deltaTime
+					accelY := player.InputY * cfg.PlayerAcceleration * deltaTime
 					player.VelX += accelX
 					player.VelY += accelY
 
 					currentSpeed := math.Sqrt(player.VelX*player.VelX + player.VelY*player.VelY)
-					if currentSpeed > PlayerMaxVelocity {
-						player.VelX = (player.VelX / currentSpeed) * PlayerMaxVelocity
-						player.VelY = (player.VelY / currentSpeed) * PlayerMaxVelocity
+					if currentSpeed > cfg.PlayerMaxVelocity {
+						player.VelX = (player.VelX / currentSpeed) * cfg.PlayerMaxVelocity
+						player.VelY = (player.VelY / currentSpeed) * cfg.PlayerMaxVelocity
 					}
 				} else {
-					player.VelX *= (1.0 - (1.0-PlayerFriction)*deltaTime*60)
-					player.VelY *= (1.0 - (1.0-PlayerFriction)*deltaTime*60)
+					player.VelX *= (1.0 - (1.0-cfg.PlayerFriction)*deltaTime*60)
+					player.VelY *= (1.0 - (1.0-cfg.PlayerFriction)*deltaTime*60)
 					if math.Abs(player.VelX) < 0.1 {
 						player.VelX = 0
 					}
@@ -387,20 +584,20 @@ func (gr *GameRoom) Run() {
 				player.X += player.VelX * deltaTime
 				player.Y += player.VelY * deltaTime
 
-				if player.X < 0 {
-					player.X = 0
+				if player.X < arena.X {
+					player.X = arena.X
 					player.VelX = 0
 				}
-				if player.Y < 0 {
-					player.Y = 0
+				if player.Y < arena.Y {
+					player.Y = arena.Y
 					player.VelY = 0
 				}
-				if player.X+player.Width > CanvasWidth {
-					player.X = CanvasWidth - player.Width
+				if player.X+player.Width > arena.X+arena.W {
+					player.X = arena.X + arena.W - player.Width
 					player.VelX = 0
 				}
-				if player.Y+player.Height > CanvasHeight {
-					player.Y = CanvasHeight - player.Height
+				if player.Y+player.Height > arena.Y+arena.H {
+					player.Y = arena.Y + arena.H - player.Height
 					player.VelY = 0
 				}
 
@@ -415,25 +612,25 @@ func (gr *GameRoom) Run() {
 			// Update Bullets
 			activeBullets := make(map[string]*Bullet)
 			for id, bullet := range gr.bullets {
-				bullet.X += BulletSpeed * bullet.DirX * deltaTime
-				bullet.Y += BulletSpeed * bullet.DirY * deltaTime
+				bullet.X += cfg.BulletSpeed * bullet.DirX * deltaTime
+				bullet.Y += cfg.BulletSpeed * bullet.DirY * deltaTime
 
 				collidedThisFrame := false
-				if bullet.X-bullet.Radius < 0 {
-					bullet.X = bullet.Radius
+				if bullet.X-bullet.Radius < arena.X {
+					bullet.X = arena.X + bullet.Radius
 					bullet.DirX *= -1
 					collidedThisFrame = true
-				} else if bullet.X+bullet.Radius > CanvasWidth {
-					bullet.X = CanvasWidth - bullet.Radius
+				} else if bullet.X+bullet.Radius > arena.X+arena.W {
+					bullet.X = arena.X + arena.W - bullet.Radius
 					bullet.DirX *= -1
 					collidedThisFrame = true
 				}
-				if bullet.Y-bullet.Radius < 0 {
-					bullet.Y = bullet.Radius
+				if bullet.Y-bullet.Radius < arena.Y {
+					bullet.Y = arena.Y + bullet.Radius
 					bullet.DirY *= -1
 					collidedThisFrame = true
-				} else if bullet.Y+bullet.Radius > CanvasHeight {
-					bullet.Y = CanvasHeight - bullet.Radius
+				} else if bullet.Y+bullet.Radius > arena.Y+arena.H {
+					bullet.Y = arena.Y + arena.H - bullet.Radius
 					bullet.DirY *= -1
 					collidedThisFrame = true
 				}
@@ -453,15 +650,18 @@ func (gr *GameRoom) Run() {
 						canDamageBasedOnBounce := bullet.TimesCollidedWall >= 1
 
 						if canDamageBasedOnBounce {
-							log.Printf("Bullet %s hit player %s. Wall bounces: %d", bullet.ID, player.ID, bullet.TimesCollidedWall)
-							player.CurrentHP -= 2
+							damage := gr.hitDamage(player)
+							gr.log.Debug("Bullet hit player", "bullet", bullet.ID, "owner", bullet.OwnerID, "player", player.ID,
+								"bounces", bullet.TimesCollidedWall, "damage", damage, "tick", gr.tick)
+							gr.recordHit(bullet, player, damage)
+							player.CurrentHP -= damage
 							if player.CurrentHP <= 0 {
-								log.Printf("Player %s died. Game Over.", player.ID)
+								gr.log.Info("Player died; game over", "player", player.ID)
 								gr.State = StateGameOver
 								for _, p := range gr.players {
 									if p.ID != player.ID {
 										gr.WinnerID = p.ID
-										log.Printf("Player %s is the winner!", p.ID)
+										gr.log.Info("Round won", "winner", p.ID)
 										break
 									}
 								}
@@ -472,31 +672,93 @@ func (gr *GameRoom) Run() {
 					}
 				}
 
-				if bullet.TimesCollidedWall > 5 || bullet.toBeRemoved {
+				if bullet.TimesCollidedWall > cfg.BulletMaxBounces || bullet.toBeRemoved {
 				} else {
 					activeBullets[id] = bullet
 				}
 			}
 			gr.bullets = activeBullets
+			gr.recordKeyframe()
+
+			if gr.State == StateGameOver {
+				gr.finishRound()
+			}
 
 			gr.Unlock()
+			gr.hub.metrics.tickDuration.observe(time.Since(tickStart))
 			// Note: broadcast is now handled by broadcastTicker at 30fps
 		}
 	}
 }
 
 func (gr *GameRoom) broadcastGameState() {
+	start := time.Now()
 	// Hold lock only long enough to copy state — never while sending
 	gr.RLock()
+	currentGameState := gr.snapshot(time.Now())
+	clients := make([]*ClientConn, 0, len(gr.clients))
+	for client := range gr.clients {
+		clients = append(clients, client)
+	}
+	frames, tick := gr.frames, gr.tick
+	gr.RUnlock() // unlock before any sending
+
+	// Serialize once; every client gets the same pre-encoded frame
+	msgBytes, err := json.Marshal(Message{Type: "gameState", Payload: currentGameState})
+	if err == nil && frames != nil {
+		frames.add(tick, msgBytes)
+	}
+	var frame *websocket.PreparedMessage
+	if err == nil {
+		frame, err = websocket.NewPreparedMessage(websocket.TextMessage, msgBytes)
+	}
+	if err != nil {
+		gr.log.Error("Error encoding game state", "err", err)
+		return
+	}
+
+	metrics := gr.hub.metrics
+	for _, client := range clients {
+		select {
+		case client.send <- frame:
+			metrics.Sent("gameState")
+		default:
+			// Client send buffer full — drop this frame for that client
+			metrics.Dropped(DropSendQueueFull)
+		}
+	}
+	metrics.broadcastDuration.observe(time.Since(start))
+}
+
+// snapshot copies the room's state for sending or recording.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) snapshot(now time.Time) GameState {
 	currentGameState := GameState{
 		RoomID:           gr.ID,
+		RoomCode:         gr.Code,
 		Players:          make(map[string]*Player, len(gr.players)),
 		Bullets:          make(map[string]*Bullet),
 		State:            gr.State,
 		WinnerID:         gr.WinnerID,
 		ReadyPlayers:     make(map[string]bool, len(gr.readyPlayers)),
 		TimeRemaining:    gr.timeRemaining,
-		ShootCooldownMax: PlayerShootCooldown,
+		ShootCooldownMax: gr.shootCooldown(),
+		Private:          gr.private,
+		HasPassword:      gr.hasPassword,
+		Mode:             gr.mode,
+		Map:              gr.mapName,
+		HostID:           gr.hostID,
+		Locked:           gr.locked,
+		Overtime:         gr.overtime,
+		Arena:            gr.arena,
+		CanvasWidth:      gr.cfg.CanvasWidth,
+		CanvasHeight:     gr.cfg.CanvasHeight,
+		SwapSides:        gr.swapSides,
+		Record:           gr.record,
+		Seed:             gr.seed,
+		Countdown:        secondsUntil(gr.countdownEnds, now),
+		AutoStartIn:      secondsUntil(gr.autoStartAt, now),
+		RatingChanges:    gr.ratingChanges,
 	}
 	for id, ready := range gr.readyPlayers {
 		currentGameState.ReadyPlayers[id] = ready
@@ -506,26 +768,66 @@ func (gr *GameRoom) broadcastGameState() {
 		playerCopy.conn = nil
 		currentGameState.Players[id] = &playerCopy
 	}
-	if gr.State == StateInProgress {
+	if gr.inRound() {
 		currentGameState.Bullets = make(map[string]*Bullet, len(gr.bullets))
 		for id, b := range gr.bullets {
 			bulletCopy := *b
 			currentGameState.Bullets[id] = &bulletCopy
 		}
 	}
+	return currentGameState
+}
+
+// updateRatings scores the round for everyone in it with gr.WinnerID as the
+// winner, refreshes each player's displayed rating and returns the ratings
+// from before. Caller must hold gr's write lock.
+func (gr *GameRoom) updateRatings() map[string]int {
+	ids := make([]string, 0, len(gr.players))
+	ratingsBefore := make(map[string]int, len(gr.players))
+	for id, p := range gr.players {
+		ids = append(ids, id)
+		ratingsBefore[id] = p.Rating
+	}
+	gr.ratingChanges = gr.hub.ratings.RecordResult(ids, gr.WinnerID)
+	for id, p := range gr.players {
+		p.Rating = int(math.Round(gr.hub.ratings.Get(id).Rating))
+	}
+	gr.log.Info("Ratings updated", "match", gr.matchID, "changes", gr.ratingChanges)
+	return ratingsBefore
+}
+
+// finishRound records the outcome of a round that just ended and refreshes
+// every player's displayed rating. Caller must hold gr's write lock.
+func (gr *GameRoom) finishRound() {
+	ratingsBefore := gr.updateRatings()
+	reason := gr.endReason()
+	gr.saveMatch(gr.matchRecord(reason, ratingsBefore))
+	gr.stopRecording(&ReplayEvent{WinnerID: gr.WinnerID, Reason: reason})
+	gr.round++
+	gr.idleSince = time.Now()
+	gr.hub.roomChanged(gr)
+}
+
+// broadcastChat sends a chat message to everyone in the room.
+func (gr *GameRoom) broadcastChat(msg ChatMessage) {
+	gr.RLock()
 	clients := make([]*ClientConn, 0, len(gr.clients))
 	for client := range gr.clients {
 		clients = append(clients, client)
 	}
-	gr.RUnlock() // unlock before any sending
-
-	message := Message{Type: "gameState", Payload: currentGameState}
+	gr.RUnlock()
 
+	frame, err := encodeMessage("chat", msg)
+	if err != nil {
+		gr.log.Error("Error encoding chat", "err", err)
+		return
+	}
 	for _, client := range clients {
 		select {
-		case client.send <- message:
+		case client.send <- frame:
+			gr.hub.metrics.Sent("chat")
 		default:
-			// Client send buffer full — drop this frame for that client
+			gr.hub.metrics.Dropped(DropSendQueueFull)
 		}
 	}
 }
@@ -533,41 +835,55 @@ func (gr *GameRoom) broadcastGameState() {
 func (gr *GameRoom) startGame() {
 	gr.State = StateInProgress
 	gr.WinnerID = ""
-	gr.timeRemaining = RoundDuration
+	gr.ratingChanges = nil
+	gr.timeRemaining = gr.cfg.RoundDuration.Seconds()
 	gr.bullets = make(map[string]*Bullet)
-
-	// Fixed spawn points: left side and right side, vertically centered
-	spawnPoints := []Vector2D{
-		{X: CanvasWidth * 0.15, Y: (CanvasHeight - PlayerHeight) / 2},
-		{X: CanvasWidth * 0.80, Y: (CanvasHeight - PlayerHeight) / 2},
+	gr.arena = gr.cfg.arena()
+	gr.countdownEnds = time.Time{}
+	gr.roundStart = time.Now()
+	gr.hadOvertime = false
+	gr.matchID = uuid.NewString()
+	gr.seedRound()
+	gr.tick = 0
+	gr.moveToSpawns()
+	for _, p := range gr.players {
+		p.lastInput = time.Now()
+		p.afkWarned = false
+		p.stats = PlayerStats{}
 	}
-	i := 0
+	gr.startRecording()
+	gr.frames = newReplayLog(gr.matchID, gr.cfg.TickRate)
+	gr.frames.limit = ReplayRoundBytes
+	gr.idleSince = time.Now()
+	gr.hub.roomChanged(gr)
+}
+
+// moveToSpawns puts every player at full health and standing still on their
+// slot's spawn point. Caller must hold gr's write lock.
+func (gr *GameRoom) moveToSpawns() {
 	for _, p := range gr.players {
-		p.CurrentHP = PlayerMaxHP
-		p.X = spawnPoints[i%2].X
-		p.Y = spawnPoints[i%2].Y
+		spawn := gr.spawnFor(p.Slot)
+		p.CurrentHP = gr.cfg.PlayerMaxHP
+		p.X = spawn.X
+		p.Y = spawn.Y
 		p.VelX = 0
 		p.VelY = 0
 		p.ShootingCooldown = 0
-		i++
 	}
 }
 
 func (gr *GameRoom) resetGame() {
 	gr.State = StateWaitingForPlayers
 	gr.WinnerID = ""
+	gr.ratingChanges = nil
 	gr.readyPlayers = make(map[string]bool)
 	gr.bullets = make(map[string]*Bullet)
 	gr.timeRemaining = 0
-	spawnPoints := []Vector2D{
-		{X: CanvasWidth * 0.15, Y: (CanvasHeight - PlayerHeight) / 2},
-		{X: CanvasWidth * 0.80, Y: (CanvasHeight - PlayerHeight) / 2},
-	}
-	i := 0
-	for _, p := range gr.players {
-		p.CurrentHP = PlayerMaxHP
-		p.X = spawnPoints[i%2].X
-		p.Y = spawnPoints[i%2].Y
-		i++
-	}
+	gr.arena = gr.cfg.arena()
+	gr.countdownEnds = time.Time{}
+	gr.autoStartAt = time.Time{}
+	gr.stopRecording(nil)
+	gr.moveToSpawns()
+	gr.idleSince = time.Now()
+	gr.hub.roomChanged(gr)
 }
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Why a match ended
+const (
+	EndKnockout = "knockout" // a player ran out of HP
+	EndTime     = "time"     // the clock ran out and HP decided it
+	EndDraw     = "draw"     // the clock ran out with the players level
+	EndForfeit  = "forfeit"  // a player left mid-round
+)
+
+// TrickShotBounces is how many wall bounces make a hit count as a trick shot.
+const TrickShotBounces = 2
+
+// MatchQueueSize is how many finished matches may wait for the store before
+// the recorder starts queueing them on goroutines instead.
+const MatchQueueSize = 256
+
+// PlayerStats are a player's counters for one round.
+type PlayerStats struct {
+	ShotsFired  int `json:"shotsFired"`
+	ShotsHit    int `json:"shotsHit"`
+	TrickShots  int `json:"trickShots"`
+	DamageDealt int `json:"damageDealt"`
+	DamageTaken int `json:"damageTaken"`
+}
+
+// MatchPlayer is one participant's part in a finished match.
+type MatchPlayer struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Slot         int    `json:"slot"`
+	Rating       int    `json:"rating"` // before the match
+	RatingChange int    `json:"ratingChange"`
+	FinalHP      int    `json:"finalHP"`
+	PlayerStats
+}
+
+// MatchRecord is everything kept about a finished round.
+type MatchRecord struct {
+	ID             string        `json:"id"`
+	RoomID         string        `json:"roomId"`
+	Mode           string        `json:"mode"`
+	Map            string        `json:"map"`
+	Overtime       string        `json:"overtime"` // the room's overtime policy
+	SwapSides      bool          `json:"swapSides"`
+	Round          int           `json:"round"` // rounds the room had finished before this one
+	Seed           int64         `json:"seed"`  // RNG seed the round was played with
+	StartedAt      time.Time     `json:"startedAt"`
+	EndedAt        time.Time     `json:"endedAt"`
+	Duration       float64       `json:"duration"` // seconds
+	WentToOvertime bool          `json:"wentToOvertime"`
+	Replay         bool          `json:"replay"` // a replay was recorded
+	WinnerID       string        `json:"winnerId,omitempty"`
+	EndReason      string        `json:"endReason"`
+	Players        []MatchPlayer `json:"players"`
+}
+
+// Player returns the record for one participant.
+func (m *MatchRecord) Player(id string) (MatchPlayer, bool) {
+	for _, p := range m.Players {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return MatchPlayer{}, false
+}
+
+// MatchStore keeps finished matches.
+type MatchStore interface {
+	// SaveMatch stores a finished match.
+	SaveMatch(m MatchRecord) error
+	// Match looks a match up by ID.
+	Match(id string) (MatchRecord, bool, error)
+	// Matches returns every match that ended at or after since, newest first.
+	// A zero since returns all of them.
+	Matches(since time.Time) ([]MatchRecord, error)
+	// PlayerMatches returns up to limit of a player's most recent matches,
+	// newest first.
+	PlayerMatches(playerID string, limit int) ([]MatchRecord, error)
+}
+
+// MemoryMatchStore keeps matches in memory only. It is used by tests and is
+// the index behind FileMatchStore.
+type MemoryMatchStore struct {
+	mu      sync.RWMutex
+	matches []MatchRecord // in order of EndedAt
+	byID    map[string]int
+}
+
+func NewMemoryMatchStore() *MemoryMatchStore {
+	return &MemoryMatchStore{byID: make(map[string]int)}
+}
+
+func (s *MemoryMatchStore) SaveMatch(m MatchRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[m.ID]; ok {
+		return fmt.Errorf("match %s already saved", m.ID)
+	}
+	// Writes arrive almost in order; insert from the back to keep it sorted
+	i := len(s.matches)
+	for i > 0 && s.matches[i-1].EndedAt.After(m.EndedAt) {
+		i--
+	}
+	s.matches = append(s.matches, MatchRecord{})
+	copy(s.matches[i+1:], s.matches[i:])
+	s.matches[i] = m
+	for j := i; j < len(s.matches); j++ {
+		s.byID[s.matches[j].ID] = j
+	}
+	return nil
+}
+
+func (s *MemoryMatchStore) Match(id string) (MatchRecord, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.byID[id]
+	if !ok {
+		return MatchRecord{}, false, nil
+	}
+	return s.matches[i], true, nil
+}
+
+func (s *MemoryMatchStore) Matches(since time.Time) ([]MatchRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	first := sort.Search(len(s.matches), func(i int) bool {
+		return !s.matches[i].EndedAt.Before(since)
+	})
+	out := make([]MatchRecord, 0, len(s.matches)-first)
+	for i := len(s.matches) - 1; i >= first; i-- {
+		out = append(out, s.matches[i])
+	}
+	return out, nil
+}
+
+func (s *MemoryMatchStore) PlayerMatches(playerID string, limit int) ([]MatchRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []MatchRecord
+	for i := len(s.matches) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		if _, ok := s.matches[i].Player(playerID); ok {
+			out = append(out, s.matches[i])
+		}
+	}
+	return out, nil
+}
+
+// FileMatchStore appends matches to a JSON-lines file and serves reads from
+// an in-memory index loaded when the file is opened.
+type FileMatchStore struct {
+	*MemoryMatchStore
+	mu   sync.Mutex // serializes appends
+	file *os.File
+}
+
+// OpenFileMatchStore opens or creates the history file at path and loads
+// the matches already in it.
+func OpenFileMatchStore(path string) (*FileMatchStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileMatchStore{MemoryMatchStore: NewMemoryMatchStore(), file: f}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var m MatchRecord
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// Most likely a write cut short by a crash; keep what we can
+			slog.Warn("Match history line unreadable, skipping", "path", path, "line", line, "err", err)
+			continue
+		}
+		if err := s.MemoryMatchStore.SaveMatch(m); err != nil {
+			slog.Warn("Match history line rejected", "path", path, "line", line, "err", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	slog.Info("Loaded matches", "count", len(s.matches), "path", path)
+	return s, nil
+}
+
+func (s *FileMatchStore) SaveMatch(m MatchRecord) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok, _ := s.MemoryMatchStore.Match(m.ID); ok {
+		return fmt.Errorf("match %s already saved", m.ID)
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return s.MemoryMatchStore.SaveMatch(m)
+}
+
+func (s *FileMatchStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// MatchRecorder writes finished matches to a store on its own goroutine so
+// rooms never wait on storage.
+type MatchRecorder struct {
+	store MatchStore
+	queue chan MatchRecord
+}
+
+func NewMatchRecorder(store MatchStore) *MatchRecorder {
+	return &MatchRecorder{store: store, queue: make(chan MatchRecord, MatchQueueSize)}
+}
+
+// Store is the store matches are written to, for reads.
+func (r *MatchRecorder) Store() MatchStore {
+	return r.store
+}
+
+func (r *MatchRecorder) Run() {
+	for m := range r.queue {
+		if err := r.store.SaveMatch(m); err != nil {
+			slog.Error("Failed to save match", "match", m.ID, "room", m.RoomID, "err", err)
+			continue
+		}
+		slog.Info("Saved match", "match", m.ID, "room", m.RoomID, "reason", m.EndReason, "winner", m.WinnerID)
+	}
+}
+
+// Record queues a match for saving. It never blocks: if the store has
+// fallen far behind, the match waits on its own goroutine instead.
+func (r *MatchRecorder) Record(m MatchRecord) {
+	select {
+	case r.queue <- m:
+	default:
+		slog.Warn("Match history queue full; match will be saved late", "match", m.ID)
+		go func() { r.queue <- m }()
+	}
+}
+
+// saveMatch records a round that just ended. While the round is being
+// recorded the replay writer saves it instead, once the file is complete or
+// has been dropped, so Replay is only set for replays that exist. Call it
+// before stopRecording. Caller must hold gr's write lock.
+func (gr *GameRoom) saveMatch(m MatchRecord) {
+	if gr.replay == nil {
+		gr.hub.history.Record(m)
+		return
+	}
+	gr.replay.match = &m
+	gr.replay.history = gr.hub.history
+}
+
+// matchRecord snapshots the round that just ended.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) matchRecord(reason string, ratingsBefore map[string]int) MatchRecord {
+	now := time.Now()
+	m := MatchRecord{
+		ID:             gr.matchID,
+		RoomID:         gr.ID,
+		Mode:           gr.mode,
+		Map:            gr.mapName,
+		Overtime:       gr.overtime,
+		SwapSides:      gr.swapSides,
+		Round:          gr.round,
+		Seed:           gr.seed,
+		StartedAt:      gr.roundStart,
+		EndedAt:        now,
+		Duration:       now.Sub(gr.roundStart).Seconds(),
+		WentToOvertime: gr.hadOvertime,
+		WinnerID:       gr.WinnerID,
+		EndReason:      reason,
+		Players:        make([]MatchPlayer, 0, len(gr.players)),
+	}
+	for _, p := range gr.players {
+		m.Players = append(m.Players, MatchPlayer{
+			ID:           p.ID,
+			Name:         p.Name,
+			Slot:         p.Slot,
+			Rating:       ratingsBefore[p.ID],
+			RatingChange: gr.ratingChanges[p.ID],
+			FinalHP:      p.CurrentHP,
+			PlayerStats:  p.stats,
+		})
+	}
+	sort.Slice(m.Players, func(i, j int) bool { return m.Players[i].Slot < m.Players[j].Slot })
+	return m
+}
+
+// endReason works out why a round that reached StateGameOver ended.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) endReason() string {
+	if gr.WinnerID == "" {
+		return EndDraw
+	}
+	for _, p := range gr.players {
+		if p.CurrentHP <= 0 {
+			return EndKnockout
+		}
+	}
+	return EndTime
+}
+
+// recordForfeit saves a two-player round when one player walks out of it.
+// The leaver loses to the player who stayed, and both ratings move as for
+// any other result. Rounds with more players carry on without the leaver,
+// so they never end in a forfeit.
+// Caller must hold gr's write lock, and the leaver must still be in gr.players.
+func (gr *GameRoom) recordForfeit(leaverID string) {
+	if len(gr.players) != 2 {
+		return
+	}
+	if _, ok := gr.players[leaverID]; !ok {
+		return
+	}
+	for id := range gr.players {
+		if id != leaverID {
+			gr.WinnerID = id
+		}
+	}
+	ratingsBefore := gr.updateRatings()
+	gr.saveMatch(gr.matchRecord(EndForfeit, ratingsBefore))
+	gr.stopRecording(&ReplayEvent{WinnerID: gr.WinnerID, Reason: EndForfeit})
+}
+
+// recordHit updates both players' counters for a bullet that did damage.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) recordHit(b *Bullet, victim *Player, damage int) {
+	victim.stats.DamageTaken += damage
+	shooter, ok := gr.players[b.OwnerID]
+	if !ok || shooter == victim {
+		return
+	}
+	shooter.stats.ShotsHit++
+	shooter.stats.DamageDealt += damage
+	if b.TimesCollidedWall >= TrickShotBounces {
+		shooter.stats.TrickShots++
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testMatch(id string, ended time.Time, players ...string) MatchRecord {
+	m := MatchRecord{ID: id, EndedAt: ended, EndReason: EndKnockout}
+	for i, p := range players {
+		m.Players = append(m.Players, MatchPlayer{ID: p, Slot: i})
+	}
+	return m
+}
+
+func TestFileMatchStoreReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matches.jsonl")
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	s, err := OpenFileMatchStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []MatchRecord{
+		testMatch("m1", base, "a", "b"),
+		testMatch("m3", base.Add(2*time.Minute), "a", "c"),
+		testMatch("m2", base.Add(time.Minute), "b", "c"),
+	} {
+		if err := s.SaveMatch(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveMatch(testMatch("m1", base)); err == nil {
+		t.Error("saving a duplicate match ID succeeded")
+	}
+	s.Close()
+
+	s, err = OpenFileMatchStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if m, ok, _ := s.Match("m2"); !ok || len(m.Players) != 2 {
+		t.Fatalf("reloaded m2 = %+v, %v", m, ok)
+	}
+	all, _ := s.Matches(time.Time{})
+	if got := ids(all); got != "m3 m2 m1" {
+		t.Errorf("Matches = %s, want newest first", got)
+	}
+	recent, _ := s.Matches(base.Add(time.Minute))
+	if got := ids(recent); got != "m3 m2" {
+		t.Errorf("Matches since m2 = %s", got)
+	}
+	mine, _ := s.PlayerMatches("a", 1)
+	if got := ids(mine); got != "m3" {
+		t.Errorf("PlayerMatches(a, 1) = %s", got)
+	}
+}
+
+func ids(ms []MatchRecord) string {
+	out := ""
+	for i, m := range ms {
+		if i > 0 {
+			out += " "
+		}
+		out += m.ID
+	}
+	return out
+}
+
+func TestRecordForfeit(t *testing.T) {
+	h := NewHub()
+	room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+	room.State = StateInProgress
+	for i, id := range []string{"a", "b"} {
+		room.players[id] = &Player{ID: id, Slot: i, Rating: InitialRating}
+	}
+	room.dropPlayer("a")
+
+	var m MatchRecord
+	select {
+	case m = <-h.history.queue:
+	default:
+		t.Fatal("forfeit did not save the match")
+	}
+	if m.WinnerID != "b" || m.EndReason != EndForfeit {
+		t.Errorf("winner, reason = %q, %q, want b, %q", m.WinnerID, m.EndReason, EndForfeit)
+	}
+	leaver, _ := m.Player("a")
+	stayer, _ := m.Player("b")
+	if leaver.RatingChange >= 0 || stayer.RatingChange <= 0 {
+		t.Errorf("rating changes = %d for the leaver, %d for the winner", leaver.RatingChange, stayer.RatingChange)
+	}
+	if r := h.ratings.Get("a"); r.Losses != 1 {
+		t.Errorf("leaver's rating record = %+v, want one loss", r)
+	}
+
+	// With three players the round goes on, so leaving is not a forfeit
+	room = NewGameRoom("r2", "GHJKLM", h, RoomOptions{})
+	room.State = StateInProgress
+	for i, id := range []string{"c", "d", "e"} {
+		room.players[id] = &Player{ID: id, Slot: i, Rating: InitialRating}
+	}
+	room.dropPlayer("c")
+	select {
+	case m := <-h.history.queue:
+		t.Errorf("leaving a three-player round saved match %+v", m)
+	default:
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+// Host error codes
+const (
+	ErrCodeNotHost        = "not_host"
+	ErrCodeRoomLocked     = "room_locked"
+	ErrCodeKicked         = "kicked"
+	ErrCodeWrongState     = "wrong_state"
+	ErrCodePlayerNotFound = "player_not_found"
+)
+
+// hostAction is a host-only request, checked and applied by the room goroutine.
+type hostAction struct {
+	client  *ClientConn
+	kind    string // kick_player, lock_room, change_settings or transfer_host
+	payload map[string]interface{}
+}
+
+// addToJoinOrder records a newly seated player; the first one becomes host.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) addToJoinOrder(playerID string) {
+	gr.joinOrder = append(gr.joinOrder, playerID)
+	if gr.hostID == "" {
+		gr.hostID = playerID
+	}
+}
+
+// dropPlayer removes a player from the room. If they were host, the role
+// passes to whoever has been in the room longest.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) dropPlayer(playerID string) {
+	if gr.inRound() {
+		gr.recordForfeit(playerID)
+	}
+	delete(gr.players, playerID)
+	delete(gr.readyPlayers, playerID)
+	if gr.State == StateCountdown {
+		gr.cancelCountdown("player left")
+	}
+	gr.updateAutoStart(time.Now())
+	for i, id := range gr.joinOrder {
+		if id == playerID {
+			gr.joinOrder = append(gr.joinOrder[:i], gr.joinOrder[i+1:]...)
+			break
+		}
+	}
+	if gr.hostID != playerID {
+		return
+	}
+	gr.hostID = ""
+	if len(gr.joinOrder) > 0 {
+		gr.hostID = gr.joinOrder[0]
+		gr.log.Info("Host left; handing over", "host", gr.hostID)
+	}
+}
+
+// getHostName returns the host's nickname for display.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) getHostName() string {
+	if p, ok := gr.players[gr.hostID]; ok {
+		return p.Name
+	}
+	return "Empty"
+}
+
+// handleHostAction applies a host-only request, or tells the sender why not.
+func (gr *GameRoom) handleHostAction(a hostAction) {
+	gr.Lock()
+	if a.client.id != gr.hostID {
+		gr.Unlock()
+		a.client.sendError(ErrCodeNotHost, "Only the host can do that")
+		return
+	}
+
+	var kicked *ClientConn
+	code, message := "", ""
+	switch a.kind {
+	case "kick_player":
+		targetID, _ := a.payload["playerId"].(string)
+		target, ok := gr.players[targetID]
+		if !ok || targetID == gr.hostID {
+			code, message = ErrCodePlayerNotFound, "No such player in this room"
+			break
+		}
+		gr.kicked[target.conn.identity] = true
+		kicked = target.conn
+		gr.log.Info("Host kicked a player", "client", a.client, "target", kicked)
+
+	case "lock_room":
+		gr.locked, _ = a.payload["locked"].(bool)
+		gr.log.Info("Host set room lock", "client", a.client, "locked", gr.locked)
+
+	case "change_settings":
+		code, message = gr.changeSettings(a.payload)
+		if code == "" {
+			gr.log.Info("Host changed room settings", "client", a.client, "private", gr.private, "password", gr.hasPassword,
+				"mode", gr.mode, "map", gr.mapName, "overtime", gr.overtime, "swapSides", gr.swapSides)
+		}
+
+	case "transfer_host":
+		targetID, _ := a.payload["playerId"].(string)
+		if _, ok := gr.players[targetID]; !ok || targetID == gr.hostID {
+			code, message = ErrCodePlayerNotFound, "No such player in this room"
+			break
+		}
+		gr.hostID = targetID
+		gr.log.Info("Host handed the room over", "client", a.client, "host", targetID)
+	}
+	gr.Unlock()
+
+	if code != "" {
+		a.client.sendError(code, message)
+		return
+	}
+	if kicked != nil {
+		// The host is still seated, so the room cannot empty here
+		gr.removeClient(kicked)
+		kicked.sendMessage("kicked", map[string]string{"roomId": gr.ID})
+		gr.hub.returnToLobby(kicked)
+	}
+	gr.hub.roomChanged(gr)
+	gr.broadcastGameState()
+}
+
+// changeSettings validates and applies a change_settings payload. Every
+// field is optional: {private, password, mode, map, overtime, swapSides}; an empty password
+// removes it. Nothing is applied unless the whole payload is valid.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) changeSettings(payload map[string]interface{}) (string, string) {
+	if gr.State != StateWaitingForPlayers {
+		return ErrCodeWrongState, "Settings can only be changed while waiting for players"
+	}
+	password, setPassword := payload["password"].(string)
+	if len(password) > MaxPasswordLength {
+		return ErrCodeInvalidRequest, "Password is too long"
+	}
+	mode, _ := payload["mode"].(string)
+	if mode != "" && !validModes[mode] {
+		return ErrCodeUnknownMode, "Unknown game mode"
+	}
+	mapName, _ := payload["map"].(string)
+	if mapName != "" && !validMaps[mapName] {
+		return ErrCodeUnknownMap, "Unknown map"
+	}
+	overtime, _ := payload["overtime"].(string)
+	if overtime != "" && !validOvertime[overtime] {
+		return ErrCodeInvalidRequest, "Unknown overtime policy"
+	}
+
+	if private, ok := payload["private"].(bool); ok {
+		gr.private = private
+	}
+	if setPassword {
+		gr.hasPassword = password != ""
+		gr.passwordHash = sha256.Sum256([]byte(password))
+	}
+	if mode != "" {
+		gr.mode = mode
+	}
+	if mapName != "" {
+		gr.mapName = mapName
+	}
+	if overtime != "" {
+		gr.overtime = overtime
+	}
+	if swapSides, ok := payload["swapSides"].(bool); ok {
+		gr.swapSides = swapSides
+	}
+	if record, ok := payload["record"].(bool); ok {
+		gr.record = record
+	}
+	return "", ""
+}
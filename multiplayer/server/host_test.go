@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// seat puts c in room as a player, in join order.
+func seat(room *GameRoom, c *ClientConn) {
+	room.Lock()
+	room.players[c.id] = &Player{ID: c.id, Name: c.Name(), conn: c}
+	room.addToJoinOrder(c.id)
+	room.Unlock()
+}
+
+// readError reads the next message and returns its error code.
+func readError(t *testing.T, ws *websocket.Conn) string {
+	t.Helper()
+	ev := readEvents(t, ws, 1)[0]
+	var payload struct{ Code string }
+	json.Unmarshal(ev.Payload, &payload)
+	if ev.Type != "error" {
+		t.Fatalf("got %s, want an error", ev.Type)
+	}
+	return payload.Code
+}
+
+func TestHostActionsRequireHost(t *testing.T) {
+	h := NewHub()
+	room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+	host, hostWS := lobbyWsClient(t, h)
+	guest, guestWS := lobbyWsClient(t, h)
+	seat(room, host)
+	seat(room, guest)
+
+	for _, a := range []hostAction{
+		{kind: "kick_player", payload: map[string]interface{}{"playerId": host.id}},
+		{kind: "lock_room", payload: map[string]interface{}{"locked": true}},
+		{kind: "change_settings", payload: map[string]interface{}{"private": true}},
+		{kind: "transfer_host", payload: map[string]interface{}{"playerId": guest.id}},
+	} {
+		a.client = guest
+		room.handleHostAction(a)
+		if code := readError(t, guestWS); code != ErrCodeNotHost {
+			t.Errorf("%s by a guest: error %q, want %q", a.kind, code, ErrCodeNotHost)
+		}
+	}
+	if room.hostID != host.id || room.locked || room.private || room.kicked[host.identity] {
+		t.Fatalf("a guest's host actions changed the room: host %s, locked %v, private %v, kicked %v",
+			room.hostID, room.locked, room.private, room.kicked)
+	}
+
+	// Even the host may only change settings while waiting for players
+	for _, state := range []string{StateCountdown, StateInProgress, StateOvertime, StateGameOver} {
+		room.State = state
+		room.handleHostAction(hostAction{client: host, kind: "change_settings", payload: map[string]interface{}{"private": true}})
+		if code := readError(t, hostWS); code != ErrCodeWrongState {
+			t.Errorf("change_settings in %s: error %q, want %q", state, code, ErrCodeWrongState)
+		}
+		if room.private {
+			t.Fatalf("change_settings in %s was applied", state)
+		}
+	}
+	room.State = StateWaitingForPlayers
+
+	// The host cannot hand the room to someone who is not in it
+	room.handleHostAction(hostAction{client: host, kind: "transfer_host", payload: map[string]interface{}{"playerId": "stranger"}})
+	if code := readError(t, hostWS); code != ErrCodePlayerNotFound {
+		t.Errorf("transfer_host to a stranger: error %q, want %q", code, ErrCodePlayerNotFound)
+	}
+
+	room.handleHostAction(hostAction{client: host, kind: "transfer_host", payload: map[string]interface{}{"playerId": guest.id}})
+	if room.hostID != guest.id {
+		t.Fatalf("host = %s after transfer_host, want the guest", room.hostID)
+	}
+	// The old host has lost the role
+	room.handleHostAction(hostAction{client: host, kind: "lock_room", payload: map[string]interface{}{"locked": true}})
+	if code := readError(t, hostWS); code != ErrCodeNotHost || room.locked {
+		t.Errorf("lock_room by the former host: error %q, locked %v", code, room.locked)
+	}
+}
+
+func TestHostMigratesInJoinOrder(t *testing.T) {
+	h := NewHub()
+	room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+	clients := make([]*ClientConn, 4)
+	for i := range clients {
+		clients[i], _ = lobbyWsClient(t, h)
+	}
+	a, b, c, d := clients[0], clients[1], clients[2], clients[3]
+	seat(room, a)
+	seat(room, b)
+	seat(room, c)
+
+	steps := []struct {
+		leave *ClientConn
+		join  *ClientConn
+		host  *ClientConn
+	}{
+		{leave: a, host: b},   // host leaves: next in join order
+		{join: d, host: b},    // joining never takes the role
+		{leave: c, host: b},   // a non-host leaving changes nothing
+		{leave: b, host: d},   // d is the only one left
+		{leave: d, host: nil}, // empty room has no host
+		{join: a, host: a},    // first one back becomes host
+	}
+	for i, s := range steps {
+		room.Lock()
+		if s.leave != nil {
+			room.dropPlayer(s.leave.id)
+		}
+		room.Unlock()
+		if s.join != nil {
+			seat(room, s.join)
+		}
+		want := ""
+		if s.host != nil {
+			want = s.host.id
+		}
+		if room.hostID != want {
+			t.Fatalf("step %d: host = %q, want %q", i, room.hostID, want)
+		}
+	}
+}
+
+func TestKickedGuestCannotRejoin(t *testing.T) {
+	h := NewHub()
+	room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+	host, _ := lobbyWsClient(t, h)
+	guest, guestWS := lobbyWsClient(t, h)
+	guest.identity = "guest:kicked"
+	for _, c := range []*ClientConn{host, guest} {
+		seat(room, c)
+		room.clients[c] = true
+		c.room, c.player = room, room.players[c.id]
+	}
+
+	room.handleHostAction(hostAction{client: host, kind: "kick_player", payload: map[string]interface{}{"playerId": guest.id}})
+	readUntil(t, guestWS, "kicked")
+	if _, ok := room.players[guest.id]; ok || room.clients[guest] {
+		t.Fatal("kicked guest is still in the room")
+	}
+	h.mu.Lock()
+	inLobby := h.clients[guest]
+	h.mu.Unlock()
+	if !inLobby {
+		t.Error("kicked guest was not returned to the lobby")
+	}
+
+	// The same guest session on a new connection has a new client ID
+	back := newLobbyClient(h)
+	back.identity = guest.identity
+	if res := room.admit(joinRequest{client: back}); res.code != ErrCodeKicked {
+		t.Errorf("reconnecting kicked guest: code %q, want %q", res.code, ErrCodeKicked)
+	}
+	if res := room.admit(joinRequest{client: newLobbyClient(h)}); res.code != "" {
+		t.Errorf("another guest was turned away: %s", res.code)
+	}
+}
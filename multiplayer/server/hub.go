@@ -1,46 +1,148 @@
 package main
 
 import (
-	"log"
+	"crypto/rand"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 
-const MaxPlayersPerRoom = 2
+const (
+	MaxPlayersPerRoom = 2 // default for GameConfig.MaxPlayers
+	MaxPasswordLength = 64
+)
+
+// Room codes use an alphabet without look-alike characters (no 0/O, 1/I) so
+// they can be read aloud over voice chat and typed back without confusion.
+// The alphabet has exactly 32 symbols, so a random byte maps onto it evenly.
+const (
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	RoomCodeLength   = 6
+)
+
+// Error codes carried in "error" payloads so clients can react without
+// parsing the human-readable message.
+const (
+	ErrCodeRoomNotFound     = "room_not_found"
+	ErrCodeRoomFull         = "room_full"
+	ErrCodePasswordRequired = "password_required"
+	ErrCodeWrongPassword    = "wrong_password"
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeUnknownMode      = "unknown_mode"
+	ErrCodeUnknownMap       = "unknown_map"
+)
+
+// Game modes. There is a single ruleset today; the mode is carried through
+// matchmaking and room listings so quick-match only pairs compatible players.
+const ModeClassic = "classic"
+
+var validModes = map[string]bool{ModeClassic: true}
+
+// Maps. Only the open arena exists so far; like modes, it is part of the
+// room's identity so lists can be filtered by it. Maps are built in rather
+// than loaded from files, so a config reload has none to pick up.
+const MapArena = "arena"
+
+var validMaps = map[string]bool{MapArena: true}
 
 // RoomInfo is a light-weight struct for broadcasting room list
 type RoomInfo struct {
 	ID          string `json:"id"`
+	Code        string `json:"code"`
 	Name        string `json:"name"`
 	PlayerCount int    `json:"playerCount"`
 	MaxPlayers  int    `json:"maxPlayers"`
+	HasPassword bool   `json:"hasPassword"`
+	Locked      bool   `json:"locked"`
+	Reserved    int    `json:"reserved"` // seats held for accepted joiners on their way in
+	Mode        string `json:"mode"`
+	Map         string `json:"map"`
+	State       string `json:"state"`
+	Rating      int    `json:"rating"`    // average rating of the players in the room
+	CreatedAt   int64  `json:"createdAt"` // unix milliseconds
+}
+
+// RoomOptions are chosen by the creator when a room is made.
+// Private rooms never appear in room_list and can only be joined by ID.
+type RoomOptions struct {
+	Private   bool
+	Password  string
+	Mode      string
+	Map       string
+	Overtime  string // empty means the server default
+	Seed      int64  // fixed RNG seed for every round; 0 means the server default
+	SwapSides bool
+	NoReplay  bool // opt this room out of replay recording
 }
 
 // Hub maintains the set of active clients and rooms.
 type Hub struct {
 	clients        map[*ClientConn]bool
 	rooms          map[string]*GameRoom
+	roomCodes      map[string]*GameRoom       // short code -> room, for live rooms only
+	names          map[string]*ClientConn     // lower-cased nickname -> owner, for every connected client
+	lobbyViews     map[*ClientConn]*lobbyView // clients that asked for a filtered, paged list
+	published      map[string]RoomInfo        // public rooms as lobby clients last saw them
+	roomSeq        uint64                     // sequence number of the last room list event
 	register       chan *ClientConn
 	unregister     chan *ClientConn
 	unregisterRoom chan *GameRoom
+	roomsDirty     chan struct{}
+	matchmaker     *Matchmaker
+	ratings        *RatingStore
+	chat           *ChatModerator
+	lobbyChat      *chatHistory // guarded by mu
+	cfg            atomic.Pointer[Config]
+	metrics        *Metrics
+	history        *MatchRecorder
+	replays        *ReplayStore // nil disables replay recording
+	replayLogs     *ReplayLibrary
+	accounts       *AccountStore
+	tokens         *TokenSigner
+	sessions       map[string]*ClientConn // signed-in connections by account ID; guarded by mu
 	mu             sync.RWMutex
+	dirtyMu        sync.Mutex
+	dirtyRooms     map[*GameRoom]bool // guarded by dirtyMu
 	shutdown       bool
 }
 
 func NewHub() *Hub {
-	return &Hub{
+	h := &Hub{
 		clients:        make(map[*ClientConn]bool),
 		rooms:          make(map[string]*GameRoom),
+		roomCodes:      make(map[string]*GameRoom),
+		names:          make(map[string]*ClientConn),
+		lobbyViews:     make(map[*ClientConn]*lobbyView),
+		published:      make(map[string]RoomInfo),
+		dirtyRooms:     make(map[*GameRoom]bool),
+		roomsDirty:     make(chan struct{}, 1),
 		register:       make(chan *ClientConn, 512),
 		unregister:     make(chan *ClientConn, 512),
 		unregisterRoom: make(chan *GameRoom, 128),
+		ratings:        NewRatingStore(),
+		chat:           NewChatModerator(defaultChatFilter()),
+		lobbyChat:      newChatHistory(ChatHistorySize),
+		history:        NewMatchRecorder(NewMemoryMatchStore()),
+		replayLogs:     NewReplayLibrary(ReplayMemoryBytes),
+		accounts:       NewAccountStore(),
+		tokens:         NewTokenSigner(randomSecret()),
+		sessions:       make(map[string]*ClientConn),
+		metrics:        NewMetrics(),
 	}
+	h.configure(DefaultConfig())
+	h.matchmaker = NewMatchmaker(h)
+	return h
 }
 
 func (h *Hub) Run() {
+	idleCheck := time.NewTicker(LobbyIdleCheckInterval)
+	defer idleCheck.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
@@ -52,11 +154,12 @@ func (h *Hub) Run() {
 				continue
 			}
 			h.clients[client] = true
-			log.Printf("Client %s registered with Hub. Now %d clients in lobby.", client.id, len(h.clients))
+			client.logger().Info("Registered with hub", "lobby", len(h.clients))
 			h.mu.Unlock()
 
 			// Send room list in a separate goroutine to avoid blocking
 			go h.sendRoomList(client)
+			h.sendLobbyChatHistory(client)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
@@ -69,152 +172,514 @@ func (h *Hub) Run() {
 				default:
 					close(client.send)
 				}
-				log.Printf("Client %s disconnected from Hub. Now %d clients in lobby.", client.id, len(h.clients))
+				client.logger().Info("Disconnected from hub", "lobby", len(h.clients))
 			}
 			h.mu.Unlock()
+			h.matchmaker.remove <- client
 
 		case room := <-h.unregisterRoom:
 			h.mu.Lock()
 			if _, ok := h.rooms[room.ID]; ok {
 				delete(h.rooms, room.ID)
+				delete(h.roomCodes, room.Code)
 				room.RLock()
-				name := room.getCreatorName()
+				name := room.getHostName()
 				room.RUnlock()
-				log.Printf("Room %s (%s) was empty and has been removed.", room.ID, name)
+				room.log.Info("Empty room removed", "host", name)
 			}
 			h.mu.Unlock()
+			h.roomChanged(room)
 
-			// Broadcast room list update in separate goroutine
-			go h.broadcastRoomList()
+		case <-h.roomsDirty:
+			h.publishRoomChanges()
+
+		case now := <-idleCheck.C:
+			go h.reapIdleClients(now)
+			h.chat.sweep(now)
 		}
 	}
 }
 
-func (h *Hub) broadcastRoomList() {
-	h.mu.RLock()
+// roomChanged marks a room's lobby entry as possibly stale. Bursts of changes
+// are coalesced and published from the hub goroutine, in order. It never
+// blocks, so it is safe to call with the room lock held.
+func (h *Hub) roomChanged(room *GameRoom) {
+	h.dirtyMu.Lock()
+	h.dirtyRooms[room] = true
+	h.dirtyMu.Unlock()
+	select {
+	case h.roomsDirty <- struct{}{}:
+	default:
+		// A publish is already pending and will pick this room up
+	}
+}
+
+// roomEvent is one incremental change to the public room list.
+type roomEvent struct {
+	kind    string // room_added, room_updated or room_removed
+	seq     uint64
+	payload interface{}
+}
+
+// publishRoomChanges diffs every dirty room against what lobby clients were
+// last told and sends one versioned event per real change. Only the hub
+// goroutine calls this, so events go out in sequence order.
+func (h *Hub) publishRoomChanges() {
+	h.dirtyMu.Lock()
+	dirty := h.dirtyRooms
+	h.dirtyRooms = make(map[*GameRoom]bool)
+	h.dirtyMu.Unlock()
+
+	h.mu.Lock()
 	if h.shutdown {
-		h.mu.RUnlock()
+		h.mu.Unlock()
+		return
+	}
+	var events []roomEvent
+	for room := range dirty {
+		prev, wasPublished := h.published[room.ID]
+		info, private := h.roomInfo(room)
+		if live, ok := h.rooms[room.ID]; !ok || live != room || private {
+			if wasPublished {
+				delete(h.published, room.ID)
+				h.roomSeq++
+				events = append(events, roomEvent{"room_removed", h.roomSeq, map[string]string{"id": room.ID}})
+			}
+			continue
+		}
+		if wasPublished && info == prev {
+			continue
+		}
+		h.published[room.ID] = info
+		h.roomSeq++
+		kind := "room_updated"
+		if !wasPublished {
+			kind = "room_added"
+		}
+		events = append(events, roomEvent{kind, h.roomSeq, info})
+	}
+	if len(events) == 0 {
+		h.mu.Unlock()
 		return
 	}
 
+	// Clients with a list_rooms view only hear about changes to their own
+	// page; everyone else gets the events.
+	// Create a slice of clients to avoid holding the lock while sending
 	roomInfos := h.getRoomInfoList()
-	message := Message{Type: "room_list", Payload: roomInfos}
+	clients := make([]*ClientConn, 0, len(h.clients))
+	pages := make(map[*ClientConn]RoomPage)
+	for client := range h.clients {
+		view, ok := h.lobbyViews[client]
+		if !ok {
+			clients = append(clients, client)
+			continue
+		}
+		if page, changed := view.refresh(roomInfos); changed {
+			pages[client] = page
+		}
+	}
+	h.mu.Unlock()
 
-	// Create a slice of clients to avoid holding the lock while sending
+	// Encode each event once for every lobby client; send without holding
+	// the main lock — non-blocking, drop if full. A client that misses one
+	// sees a gap in seq and asks for a resync.
+	for _, ev := range events {
+		frame, err := encodeEvent(ev.kind, ev.seq, ev.payload)
+		if err != nil {
+			slog.Error("Error encoding room list event", "type", ev.kind, "err", err)
+			continue
+		}
+		for _, client := range clients {
+			select {
+			case client.send <- frame:
+				h.metrics.Sent(ev.kind)
+			default:
+				h.metrics.Dropped(DropSendQueueFull)
+			}
+		}
+	}
+	for client, page := range pages {
+		client.sendMessage("room_page", page)
+	}
+	slog.Debug("Published room list events", "events", len(events), "seq", events[len(events)-1].seq, "lobby", len(clients), "pages", len(pages))
+}
+
+// setLobbyView installs a client's list_rooms query and sends its first page.
+func (h *Hub) setLobbyView(client *ClientConn, q RoomQuery) {
+	if _, err := q.apply(nil); err != nil {
+		client.sendError(ErrCodeInvalidRequest, "Invalid cursor")
+		return
+	}
+
+	h.mu.Lock()
+	view := &lobbyView{query: q}
+	page, _ := view.refresh(h.getRoomInfoList())
+	h.lobbyViews[client] = view
+	h.mu.Unlock()
+	client.sendMessage("room_page", page)
+}
+
+// dropLobbyView forgets a disconnected client's list_rooms query.
+func (h *Hub) dropLobbyView(client *ClientConn) {
+	h.mu.Lock()
+	delete(h.lobbyViews, client)
+	h.mu.Unlock()
+}
+
+// sendRoomList sends a full snapshot, stamped with the current sequence
+// number. Used on connect, on returning to the lobby and on resync.
+func (h *Hub) sendRoomList(client *ClientConn) {
+	h.mu.Lock()
+	roomInfos := h.getRoomInfoList()
+	seq := h.roomSeq
+	view, paged := h.lobbyViews[client]
+	var page RoomPage
+	if paged {
+		view.lastSent = nil
+		page, _ = view.refresh(roomInfos)
+	}
+	h.mu.Unlock()
+
+	if paged {
+		client.sendMessage("room_page", page)
+		return
+	}
+
+	frame, err := encodeEvent("room_list", seq, roomInfos)
+	if err != nil {
+		client.logger().Error("Error encoding room list", "err", err)
+		return
+	}
+	select {
+	case client.send <- frame:
+		h.metrics.Sent("room_list")
+		client.logger().Debug("Sent room list")
+	case <-time.After(5 * time.Second):
+		client.logger().Warn("Timeout sending room list")
+	}
+}
+
+// broadcastLobbyChat records a lobby chat message and sends it to every
+// client currently in the lobby.
+func (h *Hub) broadcastLobbyChat(msg ChatMessage) {
+	h.mu.Lock()
+	h.lobbyChat.add(msg)
 	clients := make([]*ClientConn, 0, len(h.clients))
 	for client := range h.clients {
 		clients = append(clients, client)
 	}
-	h.mu.RUnlock()
+	h.mu.Unlock()
 
-	// Send to clients without holding the main lock — non-blocking, drop if full
+	frame, err := encodeMessage("chat", msg)
+	if err != nil {
+		slog.Error("Error encoding lobby chat", "err", err)
+		return
+	}
 	for _, client := range clients {
 		select {
-		case client.send <- message:
+		case client.send <- frame:
+			h.metrics.Sent("chat")
 		default:
+			h.metrics.Dropped(DropSendQueueFull)
 		}
 	}
-	log.Printf("Broadcasted room list to %d clients in lobby.", len(clients))
 }
 
-func (h *Hub) sendRoomList(client *ClientConn) {
+func (h *Hub) sendLobbyChatHistory(client *ClientConn) {
 	h.mu.RLock()
-	roomInfos := h.getRoomInfoList()
+	messages := h.lobbyChat.snapshot()
 	h.mu.RUnlock()
-
-	message := Message{Type: "room_list", Payload: roomInfos}
-	select {
-	case client.send <- message:
-		log.Printf("Sent room list to client %s", client.id)
-	case <-time.After(5 * time.Second):
-		log.Printf("Timeout sending room list to client %s", client.id)
-	}
+	client.sendMessage("chat_history", map[string]interface{}{
+		"scope":    ChatScopeLobby,
+		"messages": messages,
+	})
 }
 
+// getRoomInfoList returns the public room list as of the last published event.
+// Caller must hold at least a read lock on h.mu.
 func (h *Hub) getRoomInfoList() []RoomInfo {
-	roomInfos := make([]RoomInfo, 0, len(h.rooms))
-	for _, room := range h.rooms {
-		room.RLock()
-		playerCount := len(room.players)
-		creatorName := room.getCreatorName()
-		room.RUnlock()
-
-		roomInfos = append(roomInfos, RoomInfo{
-			ID:          room.ID,
-			Name:        "Room by " + creatorName,
-			PlayerCount: playerCount,
-			MaxPlayers:  MaxPlayersPerRoom,
-		})
+	roomInfos := make([]RoomInfo, 0, len(h.published))
+	for _, info := range h.published {
+		roomInfos = append(roomInfos, info)
 	}
 	return roomInfos
 }
 
-func (h *Hub) createRoom(creator *ClientConn) {
+// roomInfo builds a room's current lobby entry and reports whether the room
+// is private and so kept out of the list.
+func (h *Hub) roomInfo(room *GameRoom) (RoomInfo, bool) {
+	room.RLock()
+	defer room.RUnlock()
+
+	ratingSum := 0
+	for _, p := range room.players {
+		ratingSum += p.Rating
+	}
+	avgRating := 0
+	if len(room.players) > 0 {
+		avgRating = ratingSum / len(room.players)
+	}
+
+	return RoomInfo{
+		ID:          room.ID,
+		Code:        room.Code,
+		Name:        "Room by " + room.getHostName(),
+		PlayerCount: len(room.players),
+		Reserved:    len(room.holds),
+		MaxPlayers:  room.cfg.MaxPlayers,
+		HasPassword: room.hasPassword,
+		Locked:      room.locked,
+		Mode:        room.mode,
+		Map:         room.mapName,
+		State:       room.State,
+		Rating:      avgRating,
+		CreatedAt:   room.createdAt.UnixMilli(),
+	}, room.private
+}
+
+// newRoomCode returns a short code that no live room is using.
+// Caller must hold h.mu.
+func (h *Hub) newRoomCode() string {
+	buf := make([]byte, RoomCodeLength)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		for i, b := range buf {
+			buf[i] = roomCodeAlphabet[int(b)%len(roomCodeAlphabet)]
+		}
+		code := string(buf)
+		if _, taken := h.roomCodes[code]; !taken {
+			return code
+		}
+	}
+}
+
+// normalizeRoomCode upper-cases and trims user input so "abc 123" style
+// typos from voice chat still resolve.
+func normalizeRoomCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// validRoomCode reports whether code, already normalized, is something
+// newRoomCode could have produced.
+func validRoomCode(code string) bool {
+	if len(code) != RoomCodeLength {
+		return false
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(roomCodeAlphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// findRoom resolves either a room UUID or a short room code.
+// Caller must hold at least a read lock on h.mu.
+func (h *Hub) findRoom(idOrCode string) (*GameRoom, bool) {
+	if room, ok := h.rooms[idOrCode]; ok {
+		return room, true
+	}
+	room, ok := h.roomCodes[normalizeRoomCode(idOrCode)]
+	return room, ok
+}
+
+// addRoom creates a room and makes it reachable by ID and code.
+// Caller must hold h.mu and start room.Run.
+func (h *Hub) addRoom(opts RoomOptions) *GameRoom {
+	if opts.Mode == "" {
+		opts.Mode = ModeClassic
+	}
+	if opts.Map == "" {
+		opts.Map = MapArena
+	}
+	cfg := h.config()
+	if opts.Overtime == "" {
+		opts.Overtime = cfg.Rooms.Overtime
+	}
+	if opts.Seed == 0 {
+		opts.Seed = cfg.Rooms.Seed
+	}
+	room := NewGameRoom(uuid.NewString(), h.newRoomCode(), h, opts)
+	h.rooms[room.ID] = room
+	h.roomCodes[room.Code] = room
+	return room
+}
+
+func (h *Hub) createRoom(creator *ClientConn, opts RoomOptions) {
+	if len(opts.Password) > MaxPasswordLength {
+		creator.sendError(ErrCodeInvalidRequest, "Password is too long")
+		return
+	}
+	if opts.Mode != "" && !validModes[opts.Mode] {
+		creator.sendError(ErrCodeUnknownMode, "Unknown game mode")
+		return
+	}
+	if opts.Map != "" && !validMaps[opts.Map] {
+		creator.sendError(ErrCodeUnknownMap, "Unknown map")
+		return
+	}
+	if opts.Overtime != "" && !validOvertime[opts.Overtime] {
+		creator.sendError(ErrCodeInvalidRequest, "Unknown overtime policy")
+		return
+	}
+	if !validSeed(opts.Seed) {
+		creator.sendError(ErrCodeInvalidRequest, "Seed must be a whole number from 1 to 2^53-1")
+		return
+	}
+
 	h.mu.Lock()
 	if h.shutdown {
 		h.mu.Unlock()
 		return
 	}
 
-	roomID := uuid.NewString()
-	room := NewGameRoom(roomID, h)
-	h.rooms[roomID] = room
+	room := h.addRoom(opts)
+	room.holdSeat(creator.id)
 	delete(h.clients, creator)
 	h.mu.Unlock() // ← unlock hub NGAY, không giữ trong khi setup room
+	h.matchmaker.remove <- creator
 
 	go room.Run()
-	log.Printf("Client %s created a new room %s", creator.id, roomID)
+	room.log.Info("Room created", "client", creator, "code", room.Code, "private", opts.Private, "password", opts.Password != "")
 
-	// Register qua channel — room.Run() xử lý, consistent state
-	// Chạy trong goroutine riêng, broadcast SAU KHI creator thật sự vào room
+	// Register qua channel — room.Run() xử lý, consistent state.
+	// The room announces itself once the creator is actually in it.
 	go func() {
 		room.register <- creator
-		go h.broadcastRoomList()
 	}()
 }
 
-func (h *Hub) joinRoom(client *ClientConn, roomID string) {
-	h.mu.Lock()
+// joinRoom moves a lobby client into a room identified by UUID or short code.
+// The room's own goroutine decides whether there is a seat, so concurrent
+// joiners can never overfill it.
+func (h *Hub) joinRoom(client *ClientConn, roomRef string, password string) {
+	h.mu.RLock()
 	if h.shutdown {
-		h.mu.Unlock()
+		h.mu.RUnlock()
 		return
 	}
-
-	room, ok := h.rooms[roomID]
+	room, ok := h.findRoom(roomRef)
+	h.mu.RUnlock()
 	if !ok {
-		h.mu.Unlock()
-		log.Printf("Client %s failed to join non-existent room %s", client.id, roomID)
-		select {
-		case client.send <- Message{Type: "error", Payload: map[string]string{"message": "Room not found"}}:
-		default:
-		}
+		client.logger().Info("Failed to join non-existent room", "room", roomRef)
+		client.sendError(ErrCodeRoomNotFound, "Room not found")
 		return
 	}
 
-	room.RLock()
-	isFull := len(room.players) >= MaxPlayersPerRoom
-	room.RUnlock()
-
-	if isFull {
-		h.mu.Unlock()
-		log.Printf("Client %s failed to join full room %s", client.id, roomID)
+	req := joinRequest{client: client, password: password, result: make(chan joinResult, 1)}
+	res := joinResult{ErrCodeRoomNotFound, "Room not found"}
+	select {
+	case room.joinRequests <- req:
 		select {
-		case client.send <- Message{Type: "error", Payload: map[string]string{"message": "Room is full"}}:
-		default:
+		case res = <-req.result:
+		case <-room.done:
 		}
+	case <-room.done:
+	}
+	if res.code != "" {
+		room.log.Info("Client was refused a seat", "client", client, "code", res.code)
+		client.sendError(res.code, res.message)
 		return
 	}
 
+	h.mu.Lock()
+	inLobby := h.clients[client] && !h.shutdown
 	delete(h.clients, client)
-	h.mu.Unlock() // ← unlock hub trước khi gửi vào room.register channel
+	h.mu.Unlock()
+	if !inLobby {
+		// Matched into another room while we waited; give the seat back
+		select {
+		case room.releaseHold <- client.id:
+		case <-room.done:
+		}
+		return
+	}
+	h.matchmaker.remove <- client
 
-	log.Printf("Client %s is joining room %s", client.id, roomID)
+	room.log.Info("Client is joining", "client", client)
 
 	// Gửi vào room.register — block goroutine này (readPump), không block Hub
-	room.register <- client
+	select {
+	case room.register <- client:
+	case <-room.done:
+		client.sendError(ErrCodeRoomNotFound, "Room not found")
+		h.returnToLobby(client)
+	}
+}
 
-	// Broadcast SAU KHI client đã vào room → playerCount đúng
-	go h.broadcastRoomList()
+// findMatch puts a lobby client into the quick-match queue for a mode.
+func (h *Hub) findMatch(client *ClientConn, mode string) {
+	if mode == "" {
+		mode = ModeClassic
+	}
+	if !validModes[mode] {
+		client.sendError(ErrCodeUnknownMode, "Unknown game mode")
+		return
+	}
+	h.matchmaker.enqueue <- matchRequest{client: client, mode: mode}
+}
+
+// createMatchRoom creates a room for a quick-match group and moves every
+// player into it. If any of them already left the lobby nothing is created
+// and the missing clients are returned so the matchmaker can drop them.
+func (h *Hub) createMatchRoom(mode string, players []*ClientConn) (*GameRoom, []*ClientConn) {
+	h.mu.Lock()
+	if h.shutdown {
+		h.mu.Unlock()
+		return nil, nil
+	}
+	var gone []*ClientConn
+	for _, c := range players {
+		if !h.clients[c] {
+			gone = append(gone, c)
+		}
+	}
+	if len(gone) > 0 {
+		h.mu.Unlock()
+		return nil, gone
+	}
+
+	room := h.addRoom(RoomOptions{Mode: mode})
+	for _, c := range players {
+		room.holdSeat(c.id)
+		delete(h.clients, c)
+	}
+	h.mu.Unlock()
+
+	go room.Run()
+
+	go func() {
+		for _, c := range players {
+			c.sendMessage("match_found", MatchFound{
+				RoomID:   room.ID,
+				RoomCode: room.Code,
+				Mode:     mode,
+			})
+			room.register <- c
+		}
+	}()
+	return room, nil
+}
+
+// returnToLobby puts a client that is no longer in any room back in the
+// lobby and sends it the current room list.
+func (h *Hub) returnToLobby(client *ClientConn) {
+	h.mu.Lock()
+	select {
+	case <-client.done:
+		// Disconnected on the way; readPump already cleaned up
+		h.mu.Unlock()
+		return
+	default:
+	}
+	h.clients[client] = true
+	h.mu.Unlock()
+
+	go h.sendRoomList(client)
+	h.sendLobbyChatHistory(client)
 }
 
 func (h *Hub) leaveRoom(client *ClientConn) {
@@ -223,26 +688,26 @@ func (h *Hub) leaveRoom(client *ClientConn) {
 	client.roomMu.Unlock()
 
 	if room == nil {
-		log.Printf("Client %s attempted to leave room but is not in any room", client.id)
+		client.logger().Warn("Attempted to leave room but is not in any room")
 		return
 	}
-	log.Printf("Client %s is leaving room %s", client.id, room.ID)
+	room.log.Info("Client is leaving", "client", client)
 
 	// Step 1: remove from room (room lock only)
 	room.Lock()
 	if _, ok := room.clients[client]; ok {
 		delete(room.clients, client)
-		if client.player != nil {
-			delete(room.players, client.player.ID)
-			delete(room.readyPlayers, client.player.ID)
+		// A newer connection for the same account may already own the ID
+		if client.player != nil && room.players[client.player.ID] == client.player {
+			room.dropPlayer(client.player.ID)
 		}
 	}
-	wasInProgress := room.State == StateInProgress || room.State == StateGameOver
+	wasInProgress := room.inRound() || room.State == StateGameOver
 	if wasInProgress && len(room.players) < 2 {
-		log.Printf("Player left mid-game. Resetting room %s to waiting state.", room.ID)
+		room.log.Info("Player left mid-game; resetting room to waiting", "state", room.State)
 		room.resetGame()
 	}
-	roomShouldBeRemoved := len(room.clients) == 0
+	roomShouldBeRemoved := room.isEmpty()
 	room.Unlock()
 
 	// Step 2: clear client's room ref
@@ -252,20 +717,15 @@ func (h *Hub) leaveRoom(client *ClientConn) {
 	client.roomMu.Unlock()
 
 	// Step 3: add back to hub lobby (hub lock only)
-	h.mu.Lock()
-	h.clients[client] = true
-	h.mu.Unlock()
-
-	log.Printf("Client %s successfully returned to lobby", client.id)
-
-	go h.sendRoomList(client)
+	h.returnToLobby(client)
+	client.logger().Info("Returned to lobby")
 
 	if roomShouldBeRemoved {
-		log.Printf("Room %s is now empty, signaling for removal", room.ID)
+		room.log.Info("Room is now empty; signalling hub for removal")
 		go func() {
 			h.unregisterRoom <- room
 		}()
 	} else {
-		go h.broadcastRoomList()
+		h.roomChanged(room)
 	}
 }
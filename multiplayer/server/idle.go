@@ -0,0 +1,150 @@
+package main
+
+import (
+	"time"
+)
+
+// IdleTimeouts control how long rooms, players and lobby clients may sit
+// idle before the server moves them along. A zero duration disables that check.
+type IdleTimeouts struct {
+	RoomWaiting  time.Duration // room in StateWaitingForPlayers with no join or state change
+	RoomGameOver time.Duration // room left on the game over screen
+	AFKWarn      time.Duration // no input during a round before a warning
+	AFKKick      time.Duration // no input during a round before removal
+	Lobby        time.Duration // lobby client that sends nothing
+}
+
+func DefaultIdleTimeouts() IdleTimeouts {
+	return IdleTimeouts{
+		RoomWaiting:  10 * time.Minute,
+		RoomGameOver: 5 * time.Minute,
+		AFKWarn:      20 * time.Second,
+		AFKKick:      40 * time.Second,
+		Lobby:        30 * time.Minute,
+	}
+}
+
+// LobbyIdleCheckInterval is how often the hub looks for idle lobby clients.
+const LobbyIdleCheckInterval = 15 * time.Second
+
+// Reasons carried in "removed" messages
+const (
+	RemovedRoomIdle  = "room_idle"
+	RemovedAFK       = "afk"
+	RemovedLobbyIdle = "lobby_idle"
+)
+
+// touch records client activity for the lobby idle check.
+func (c *ClientConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the client has sent nothing.
+func (c *ClientConn) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.lastActive.Load()))
+}
+
+// checkIdle runs on the room's idle tick. It warns and removes AFK players and
+// reports whether the whole room was closed for sitting idle.
+func (gr *GameRoom) checkIdle(now time.Time) bool {
+	timeouts := gr.roomCfg.idleTimeouts()
+	var warn, afk []*ClientConn
+
+	gr.Lock()
+	limit := time.Duration(0)
+	switch gr.State {
+	case StateWaitingForPlayers:
+		limit = timeouts.RoomWaiting
+	case StateGameOver:
+		limit = timeouts.RoomGameOver
+	case StateInProgress, StateOvertime:
+		for _, p := range gr.players {
+			if p.InputX != 0 || p.InputY != 0 {
+				// Holding a direction key sends nothing but is not idle
+				p.lastInput = now
+				p.afkWarned = false
+			}
+			idle := now.Sub(p.lastInput)
+			switch {
+			case timeouts.AFKKick > 0 && idle >= timeouts.AFKKick:
+				afk = append(afk, p.conn)
+			case timeouts.AFKWarn > 0 && idle >= timeouts.AFKWarn && !p.afkWarned:
+				p.afkWarned = true
+				warn = append(warn, p.conn)
+			}
+		}
+	}
+	idleFor := now.Sub(gr.idleSince)
+	if limit == 0 || idleFor < limit {
+		gr.Unlock()
+		for _, c := range warn {
+			gr.log.Info("Player has sent no input; warning", "client", c, "after", timeouts.AFKWarn)
+			c.sendMessage("afk_warning", map[string]interface{}{
+				"secondsLeft": int((timeouts.AFKKick - timeouts.AFKWarn).Seconds()),
+			})
+		}
+		for _, c := range afk {
+			gr.log.Info("Removing AFK player", "client", c)
+			c.sendMessage("removed", map[string]string{
+				"reason":  RemovedAFK,
+				"message": "You were removed for being inactive",
+			})
+			// Synchronous so the next tick cannot remove them twice
+			gr.hub.leaveRoom(c)
+		}
+		return false
+	}
+
+	// Close the room: everyone goes back to the lobby
+	clients := make([]*ClientConn, 0, len(gr.clients))
+	for c := range gr.clients {
+		clients = append(clients, c)
+		c.roomMu.Lock()
+		c.room = nil
+		c.player = nil
+		c.roomMu.Unlock()
+	}
+	gr.clients = make(map[*ClientConn]bool)
+	gr.players = make(map[string]*Player)
+	gr.holds = make(map[string]time.Time)
+	state := gr.State
+	gr.Unlock()
+
+	gr.log.Info("Closing idle room", "state", state, "idle", idleFor.Round(time.Second))
+	for _, c := range clients {
+		c.sendMessage("removed", map[string]string{
+			"reason":  RemovedRoomIdle,
+			"message": "The room was closed for inactivity",
+		})
+		gr.hub.returnToLobby(c)
+	}
+	return true
+}
+
+// reapIdleClients disconnects lobby clients that have sent nothing for too long.
+func (h *Hub) reapIdleClients(now time.Time) {
+	limit := h.config().Rooms.LobbyIdle.std()
+	if limit <= 0 {
+		return
+	}
+	h.mu.RLock()
+	var idle []*ClientConn
+	for c := range h.clients {
+		if c.idleFor(now) >= limit {
+			idle = append(idle, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range idle {
+		c.logger().Info("Disconnecting client idle in the lobby", "idle", c.idleFor(now).Round(time.Second))
+		c.sendMessage("removed", map[string]string{
+			"reason":  RemovedLobbyIdle,
+			"message": "Disconnected for inactivity",
+		})
+		// Closing after the writer has had a moment lets the notice go out;
+		// readPump then unregisters the client as usual.
+		conn := c.conn
+		time.AfterFunc(time.Second, func() { conn.Close() })
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// seatConnected puts c in room the way register does, so leaving and
+// closing the room find it.
+func seatConnected(room *GameRoom, c *ClientConn) {
+	seat(room, c)
+	room.Lock()
+	room.clients[c] = true
+	room.Unlock()
+	c.roomMu.Lock()
+	c.room, c.player = room, room.players[c.id]
+	c.roomMu.Unlock()
+	room.hub.mu.Lock()
+	delete(room.hub.clients, c)
+	room.hub.mu.Unlock()
+}
+
+// removedReason returns the reason a "removed" message gives.
+func removedReason(t *testing.T, payload json.RawMessage) string {
+	t.Helper()
+	var p struct{ Reason string }
+	if err := json.Unmarshal(payload, &p); err != nil {
+		t.Fatal(err)
+	}
+	return p.Reason
+}
+
+func TestAFKPlayersAreWarnedThenRemoved(t *testing.T) {
+	h := NewHub()
+	room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+	afk, afkWS := lobbyWsClient(t, h)
+	busy, _ := lobbyWsClient(t, h)
+	seatConnected(room, afk)
+	seatConnected(room, busy)
+	timeouts := room.roomCfg.idleTimeouts()
+
+	start := time.Now()
+	room.State = StateInProgress
+	for _, p := range room.players {
+		p.lastInput = start
+	}
+	// Holding a direction key sends nothing, but is not idle
+	room.players[busy.id].InputX = 1
+
+	for i := 0; i < 2; i++ { // the second check must not warn again
+		if room.checkIdle(start.Add(timeouts.AFKWarn)) {
+			t.Fatal("room closed during a round")
+		}
+	}
+	var warning struct{ SecondsLeft int }
+	json.Unmarshal(readUntil(t, afkWS, "afk_warning"), &warning)
+	if want := int((timeouts.AFKKick - timeouts.AFKWarn).Seconds()); warning.SecondsLeft != want {
+		t.Errorf("warning gives %d seconds, want %d", warning.SecondsLeft, want)
+	}
+	if room.players[busy.id].afkWarned {
+		t.Error("player holding a key was warned")
+	}
+
+	room.checkIdle(start.Add(timeouts.AFKKick))
+	for {
+		ev := readEvents(t, afkWS, 1)[0]
+		if ev.Type == "afk_warning" {
+			t.Fatal("AFK player was warned twice")
+		}
+		if ev.Type == "removed" {
+			if reason := removedReason(t, ev.Payload); reason != RemovedAFK {
+				t.Errorf("removed for %q, want %q", reason, RemovedAFK)
+			}
+			break
+		}
+	}
+	if _, ok := room.players[afk.id]; ok || afk.room != nil {
+		t.Fatal("AFK player is still in the room")
+	}
+	h.mu.RLock()
+	inLobby := h.clients[afk]
+	h.mu.RUnlock()
+	if !inLobby {
+		t.Error("AFK player was not returned to the lobby")
+	}
+	if _, ok := room.players[busy.id]; !ok || room.State != StateWaitingForPlayers {
+		t.Errorf("after the removal: busy player seated %v, state %s; want seated and waiting", ok, room.State)
+	}
+}
+
+func TestIdleRoomsClose(t *testing.T) {
+	timeouts := DefaultConfig().Rooms.idleTimeouts()
+	for _, tc := range []struct {
+		state string
+		limit time.Duration
+	}{
+		{StateWaitingForPlayers, timeouts.RoomWaiting},
+		{StateGameOver, timeouts.RoomGameOver},
+	} {
+		h := NewHub()
+		room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+		a, aWS := lobbyWsClient(t, h)
+		b, bWS := lobbyWsClient(t, h)
+		seatConnected(room, a)
+		seatConnected(room, b)
+		start := time.Now()
+		room.State, room.idleSince = tc.state, start
+
+		if room.checkIdle(start.Add(tc.limit - time.Second)) {
+			t.Fatalf("%s: room closed before its limit", tc.state)
+		}
+		if !room.checkIdle(start.Add(tc.limit)) {
+			t.Fatalf("%s: room still open after %v idle", tc.state, tc.limit)
+		}
+		for _, ws := range []*websocket.Conn{aWS, bWS} {
+			if reason := removedReason(t, readUntil(t, ws, "removed")); reason != RemovedRoomIdle {
+				t.Errorf("%s: removed for %q, want %q", tc.state, reason, RemovedRoomIdle)
+			}
+		}
+		h.mu.RLock()
+		inLobby := h.clients[a] && h.clients[b]
+		h.mu.RUnlock()
+		if !inLobby || a.room != nil || b.room != nil || len(room.players) != 0 {
+			t.Errorf("%s: closed room left players behind", tc.state)
+		}
+	}
+}
+
+func TestIdleLobbyClientsAreDisconnected(t *testing.T) {
+	h := NewHub()
+	limit := h.config().Rooms.LobbyIdle.std()
+	idle, idleWS := lobbyWsClient(t, h)
+	active := newLobbyClient(h)
+
+	start := time.Now()
+	idle.lastActive.Store(start.UnixNano())
+	active.lastActive.Store(start.Add(limit / 2).UnixNano())
+	h.reapIdleClients(start.Add(limit))
+
+	if reason := removedReason(t, readUntil(t, idleWS, "removed")); reason != RemovedLobbyIdle {
+		t.Errorf("removed for %q, want %q", reason, RemovedLobbyIdle)
+	}
+	// The connection closes once the notice has had time to go out
+	idleWS.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := idleWS.ReadMessage(); err == nil {
+		t.Error("idle client's connection was left open")
+	} else if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+		t.Error("idle client's connection was never closed")
+	}
+	if len(active.send) != 0 {
+		t.Error("active lobby client was disconnected")
+	}
+}
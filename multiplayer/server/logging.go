@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel is shared by every logger, so a reload can change it in place.
+var logLevel = new(slog.LevelVar)
+
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// setupLogging makes slog's default logger write to w as c describes. The
+// standard log package goes through it too, at info level.
+func setupLogging(w io.Writer, c LogConfig) {
+	level, _ := parseLogLevel(c.Level)
+	logLevel.Set(level)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if c.Format == LogFormatJSON {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	if c.SampleBurst > 0 {
+		h = &samplingHandler{Handler: h, s: newLogSampler(c.SampleBurst, c.SampleInterval.std())}
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// samplingHandler drops lines below error level that repeat too often. The
+// first line written after some were dropped carries how many.
+type samplingHandler struct {
+	slog.Handler
+	s *logSampler // shared with every handler derived from this one
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelError {
+		ok, dropped := h.s.allow(r.Message, r.Time)
+		if !ok {
+			return nil
+		}
+		if dropped > 0 {
+			r.AddAttrs(slog.Int("sampledOut", dropped))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), s: h.s}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), s: h.s}
+}
+
+// logSampler counts lines per message in fixed windows. Messages are
+// constant strings, so the map stays small.
+type logSampler struct {
+	burst    int
+	interval time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+type sampleWindow struct {
+	start   time.Time
+	written int
+	dropped int // in this window, not yet reported
+}
+
+func newLogSampler(burst int, interval time.Duration) *logSampler {
+	return &logSampler{burst: burst, interval: interval, windows: make(map[string]*sampleWindow)}
+}
+
+// allow reports whether a line may be written, and if so how many lines
+// with the same message were dropped before it.
+func (s *logSampler) allow(msg string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[msg]
+	if !ok {
+		w = &sampleWindow{start: now}
+		s.windows[msg] = w
+	}
+	dropped := 0
+	if now.Sub(w.start) >= s.interval {
+		dropped = w.dropped
+		*w = sampleWindow{start: now}
+	}
+	if w.written >= s.burst {
+		w.dropped++
+		return false, 0
+	}
+	w.written++
+	return true, dropped
+}
+
+// LogValue lets a client be logged as "client", c: its ID and current name.
+func (c *ClientConn) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("id", c.id), slog.String("name", c.Name()))
+}
+
+// logger is the default logger with the client attached.
+func (c *ClientConn) logger() *slog.Logger {
+	return slog.With("client", c)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	sampler := newLogSampler(2, time.Second)
+	logger := slog.New(&samplingHandler{Handler: slog.NewTextHandler(&buf, nil), s: sampler})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		logger.Info("Player shot")
+	}
+	logger.Error("Disk full") // never sampled
+	if got := strings.Count(buf.String(), "Player shot"); got != 2 {
+		t.Errorf("wrote %d of 5 repeated lines in one window, want 2", got)
+	}
+	if !strings.Contains(buf.String(), "Disk full") {
+		t.Error("error line was sampled out")
+	}
+
+	// The next window reports what the last one dropped
+	if ok, dropped := sampler.allow("Player shot", start.Add(2*time.Second)); !ok || dropped != 3 {
+		t.Errorf("allow in next window = %v, %d dropped; want true, 3", ok, dropped)
+	}
+}
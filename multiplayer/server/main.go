@@ -1,35 +1,108 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 )
 
-var addr = flag.String("addr", "", "http service address (overrides PORT env)")
+var (
+	configPath  = flag.String("config", os.Getenv(EnvPrefix+"CONFIG"), "JSON config file; see -print-config for every setting (default $"+EnvPrefix+"CONFIG)")
+	printConfig = flag.Bool("print-config", false, "print the effective configuration as JSON and exit")
+)
 
 func main() {
+	defaults := DefaultConfig()
+	bindFlags(flag.CommandLine, &defaults)
 	flag.Parse()
 
-	// Determine listen address: flag > PORT env > default :8080
-	listenAddr := *addr
-	if listenAddr == "" {
-		if port := os.Getenv("PORT"); port != "" {
-			listenAddr = ":" + port
-		} else {
-			listenAddr = ":8080"
-		}
+	cfg, err := LoadConfig(*configPath, os.LookupEnv, flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Config: %v", err)
+	}
+	if *printConfig {
+		out, _ := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	setupLogging(os.Stderr, cfg.Log)
+	if *configPath != "" {
+		slog.Info("Loaded config", "path", *configPath)
 	}
 
 	hub := NewHub()
+	hub.configure(cfg)
+	upgrader.ReadBufferSize = cfg.Network.ReadBufferSize
+	upgrader.WriteBufferSize = cfg.Network.WriteBufferSize
+	upgrader.HandshakeTimeout = cfg.Network.HandshakeTimeout.std()
+	storage := cfg.Storage
+	if storage.DataDir != "" {
+		if err := os.MkdirAll(storage.DataDir, 0o755); err != nil {
+			fatal("Creating data directory", "err", err)
+		}
+	}
+	historyPath, accountsPath, replayDir := storage.paths()
+	if historyPath != "" {
+		store, err := OpenFileMatchStore(historyPath)
+		if err != nil {
+			fatal("Opening match history", "err", err)
+		}
+		defer store.Close()
+		hub.history = NewMatchRecorder(store)
+	}
+	if accountsPath != "" {
+		accounts, err := OpenAccountStore(accountsPath)
+		if err != nil {
+			fatal("Opening accounts", "err", err)
+		}
+		defer accounts.Close()
+		hub.accounts = accounts
+	}
+	if replayDir != "" {
+		replays, err := OpenReplayStore(replayDir, storage.ReplayMaxAge.std(), storage.ReplayMaxFiles, storage.ReplayFrames)
+		if err != nil {
+			fatal("Opening replay directory", "err", err)
+		}
+		hub.replays = replays
+	}
+	if cfg.AuthSecret != "" {
+		hub.tokens = NewTokenSigner([]byte(cfg.AuthSecret))
+	} else {
+		slog.Warn("No auth secret set; sessions will not survive a restart")
+	}
 	go hub.Run()
+	go hub.matchmaker.Run()
+	go hub.history.Run()
+	go watchReload(hub, *configPath, flag.CommandLine)
 
 	// WebSocket endpoint
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		serveWs(hub, w, r)
 	})
 
+	// Stats for the website and chat bots
+	registerAPI(http.DefaultServeMux, hub.history.Store())
+	registerAuthAPI(http.DefaultServeMux, hub)
+
+	// Prometheus scrape target
+	registerMetrics(http.DefaultServeMux, hub)
+
+	// Invite links: /r/{code} opens the client straight into that room.
+	// The client reads ?room= and joins once it reaches the lobby.
+	http.HandleFunc("GET /r/{code}", func(w http.ResponseWriter, r *http.Request) {
+		code := normalizeRoomCode(r.PathValue("code"))
+		if !validRoomCode(code) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/?room="+code, http.StatusFound)
+	})
+
 	// Serve client static files
 	// Try ./client first (Railway/Docker), fallback to ../client (local dev)
 	clientDir := "./client"
@@ -39,9 +112,19 @@ func main() {
 	fs := http.FileServer(http.Dir(clientDir))
 	http.Handle("/", fs)
 
-	log.Println("Server starting on", listenAddr)
-	err := http.ListenAndServe(listenAddr, nil)
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		fatal("Listen", "err", err)
+	}
+	slog.Info("Server starting", "addr", cfg.Addr)
+	err = http.Serve(countingListener{ln, &hub.metrics.bytesSent}, nil)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		fatal("Serve", "err", err)
 	}
 }
+
+// fatal logs an error and exits, like log.Fatal.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
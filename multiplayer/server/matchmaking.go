@@ -0,0 +1,275 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	MatchStatusInterval = 1 * time.Second // how often queued clients get position/wait updates
+	matchWaitSmoothing  = 0.2             // EWMA weight of the newest wait when estimating
+)
+
+// Rating window: two tickets match only if their rating gap fits inside both
+// players' windows. A window starts narrow and widens the longer a player
+// waits, so nobody is stuck in the queue forever.
+const (
+	MatchRatingWindowBase   = 100.0 // rating points accepted immediately
+	MatchRatingWindowGrowth = 25.0  // extra points per second waited
+	MatchRatingWindowMax    = 800.0
+)
+
+// Match status values sent in "match_status" payloads.
+const (
+	MatchStatusSearching = "searching"
+	MatchStatusCancelled = "cancelled"
+)
+
+// MatchStatus tells a queued client where it stands.
+type MatchStatus struct {
+	Status        string   `json:"status"`
+	Mode          string   `json:"mode"`
+	Position      int      `json:"position,omitempty"` // 1-based place in the mode's queue
+	QueueSize     int      `json:"queueSize,omitempty"`
+	Waited        float64  `json:"waited"` // seconds since find_match
+	Rating        int      `json:"rating"`
+	RatingWindow  int      `json:"ratingWindow"`            // ± rating points currently accepted
+	EstimatedWait *float64 `json:"estimatedWait,omitempty"` // seconds; absent until we have history
+}
+
+// MatchFound is sent to both players right before they are moved into the room.
+type MatchFound struct {
+	RoomID   string `json:"roomId"`
+	RoomCode string `json:"roomCode"`
+	Mode     string `json:"mode"`
+}
+
+type matchTicket struct {
+	client   *ClientConn
+	mode     string
+	rating   float64
+	queuedAt time.Time
+}
+
+// window returns the rating gap this ticket accepts right now.
+func (t *matchTicket) window(now time.Time) float64 {
+	w := MatchRatingWindowBase + MatchRatingWindowGrowth*now.Sub(t.queuedAt).Seconds()
+	return math.Min(w, MatchRatingWindowMax)
+}
+
+// accepts reports whether two tickets are close enough in rating to play.
+func (t *matchTicket) accepts(other *matchTicket, now time.Time) bool {
+	gap := math.Abs(t.rating - other.rating)
+	return gap <= t.window(now) && gap <= other.window(now)
+}
+
+type matchRequest struct {
+	client *ClientConn
+	mode   string
+}
+
+// Matchmaker runs the hub-level quick-match queue. Clients stay in the lobby
+// while queued; once two compatible tickets exist the hub creates a room and
+// moves both players into it.
+type Matchmaker struct {
+	hub     *Hub
+	enqueue chan matchRequest
+	cancel  chan *ClientConn
+	// remove drops a ticket silently, e.g. when the client left the lobby
+	remove chan *ClientConn
+
+	// Owned by Run — never touched from other goroutines
+	queues  map[string][]*matchTicket
+	tickets map[*ClientConn]*matchTicket
+	avgWait map[string]float64 // smoothed seconds-to-match per mode
+}
+
+func NewMatchmaker(hub *Hub) *Matchmaker {
+	return &Matchmaker{
+		hub:     hub,
+		enqueue: make(chan matchRequest, 128),
+		cancel:  make(chan *ClientConn, 128),
+		remove:  make(chan *ClientConn, 512),
+		queues:  make(map[string][]*matchTicket),
+		tickets: make(map[*ClientConn]*matchTicket),
+		avgWait: make(map[string]float64),
+	}
+}
+
+func (mm *Matchmaker) Run() {
+	statusTicker := time.NewTicker(MatchStatusInterval)
+	defer statusTicker.Stop()
+
+	for {
+		select {
+		case req := <-mm.enqueue:
+			if ticket, ok := mm.tickets[req.client]; ok {
+				if ticket.mode == req.mode {
+					mm.sendStatus(ticket)
+					continue
+				}
+				// Switching modes: drop the old ticket and queue again
+				mm.dropTicket(ticket)
+			}
+			ticket := &matchTicket{
+				client:   req.client,
+				mode:     req.mode,
+				rating:   mm.hub.ratings.Get(req.client.id).Rating,
+				queuedAt: time.Now(),
+			}
+			mm.tickets[req.client] = ticket
+			mm.queues[req.mode] = append(mm.queues[req.mode], ticket)
+			req.client.logger().Info("Queued for a match", "rating", math.Round(ticket.rating), "mode", req.mode, "waiting", len(mm.queues[req.mode]))
+			mm.pair(req.mode)
+			mm.broadcastStatus(req.mode)
+
+		case client := <-mm.cancel:
+			ticket, ok := mm.tickets[client]
+			if !ok {
+				continue
+			}
+			mm.dropTicket(ticket)
+			client.logger().Info("Cancelled matchmaking", "mode", ticket.mode)
+			client.sendMessage("match_status", MatchStatus{
+				Status: MatchStatusCancelled,
+				Mode:   ticket.mode,
+				Waited: time.Since(ticket.queuedAt).Seconds(),
+			})
+			mm.broadcastStatus(ticket.mode)
+
+		case client := <-mm.remove:
+			if ticket, ok := mm.tickets[client]; ok {
+				mm.dropTicket(ticket)
+				client.logger().Info("Left the lobby; removed from the match queue", "mode", ticket.mode)
+				mm.broadcastStatus(ticket.mode)
+			}
+
+		case <-statusTicker.C:
+			for mode := range mm.queues {
+				mm.pair(mode)
+				mm.broadcastStatus(mode)
+			}
+		}
+	}
+}
+
+// findGroup picks a full room's worth of rating-compatible tickets, giving
+// priority to whoever has waited longest. Returns nil if no group fits yet.
+func (mm *Matchmaker) findGroup(mode string, now time.Time) []*matchTicket {
+	queue := mm.queues[mode]
+	for i, anchor := range queue {
+		group := []*matchTicket{anchor}
+		for _, candidate := range queue[i+1:] {
+			fits := true
+			for _, member := range group {
+				if !member.accepts(candidate, now) {
+					fits = false
+					break
+				}
+			}
+			if fits {
+				group = append(group, candidate)
+				if len(group) == mm.hub.config().Game.MaxPlayers {
+					return group
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// pair matches compatible tickets in a mode's queue until none are left.
+func (mm *Matchmaker) pair(mode string) {
+	for {
+		group := mm.findGroup(mode, time.Now())
+		if group == nil {
+			return
+		}
+		clients := make([]*ClientConn, len(group))
+		for i, t := range group {
+			clients[i] = t.client
+		}
+
+		room, gone := mm.hub.createMatchRoom(mode, clients)
+		if room == nil {
+			// Someone left the lobby between queueing and pairing; drop them
+			// and keep everyone else in place.
+			for _, c := range gone {
+				if ticket, ok := mm.tickets[c]; ok {
+					mm.dropTicket(ticket)
+				}
+			}
+			if len(gone) == 0 {
+				return // hub is shutting down
+			}
+			continue
+		}
+
+		now := time.Now()
+		ratings := make([]int, len(group))
+		for i, t := range group {
+			mm.dropTicket(t)
+			mm.recordWait(mode, now.Sub(t.queuedAt).Seconds())
+			ratings[i] = int(math.Round(t.rating))
+		}
+		room.log.Info("Matched players into room", "players", len(group), "mode", mode, "code", room.Code, "ratings", ratings)
+	}
+}
+
+// recordWait folds a completed wait into the per-mode estimate.
+func (mm *Matchmaker) recordWait(mode string, waited float64) {
+	if prev, ok := mm.avgWait[mode]; ok {
+		mm.avgWait[mode] = prev + matchWaitSmoothing*(waited-prev)
+	} else {
+		mm.avgWait[mode] = waited
+	}
+}
+
+func (mm *Matchmaker) dropTicket(ticket *matchTicket) {
+	delete(mm.tickets, ticket.client)
+	queue := mm.queues[ticket.mode]
+	for i, t := range queue {
+		if t == ticket {
+			mm.queues[ticket.mode] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(mm.queues[ticket.mode]) == 0 {
+		delete(mm.queues, ticket.mode)
+	}
+}
+
+func (mm *Matchmaker) broadcastStatus(mode string) {
+	for _, ticket := range mm.queues[mode] {
+		mm.sendStatus(ticket)
+	}
+}
+
+func (mm *Matchmaker) sendStatus(ticket *matchTicket) {
+	queue := mm.queues[ticket.mode]
+	position := 0
+	for i, t := range queue {
+		if t == ticket {
+			position = i + 1
+			break
+		}
+	}
+	now := time.Now()
+	status := MatchStatus{
+		Status:       MatchStatusSearching,
+		Mode:         ticket.mode,
+		Position:     position,
+		QueueSize:    len(queue),
+		Waited:       now.Sub(ticket.queuedAt).Seconds(),
+		Rating:       int(math.Round(ticket.rating)),
+		RatingWindow: int(ticket.window(now)),
+	}
+	if avg, ok := mm.avgWait[ticket.mode]; ok {
+		remaining := avg - status.Waited
+		if remaining < 0 {
+			remaining = 0
+		}
+		status.EstimatedWait = &remaining
+	}
+	ticket.client.sendMessage("match_status", status)
+}
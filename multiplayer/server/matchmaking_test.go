@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFindGroupRatingWindow(t *testing.T) {
+	start := time.Now()
+	for _, tc := range []struct {
+		name    string
+		ratings []float64
+		queued  []time.Duration // how long before start each ticket queued
+		after   time.Duration   // when findGroup runs, relative to start
+		want    string          // indexes of the group, or "" for none
+	}{
+		{"inside the base window", []float64{1500, 1580}, []time.Duration{0, 0}, 0, "0 1"},
+		{"on the edge", []float64{1500, 1600}, []time.Duration{0, 0}, 0, "0 1"},
+		{"too far apart", []float64{1500, 1700}, []time.Duration{0, 0}, 0, ""},
+		{"not yet wide enough", []float64{1500, 1700}, []time.Duration{0, 0}, 3 * time.Second, ""},
+		{"widened by waiting", []float64{1500, 1700}, []time.Duration{0, 0}, 4 * time.Second, "0 1"},
+		{"newcomer's window still narrow", []float64{1500, 1700}, []time.Duration{time.Minute, 0}, 0, ""},
+		{"capped", []float64{1000, 1900}, []time.Duration{0, 0}, time.Hour, ""},
+		{"longest wait goes first", []float64{1500, 2000, 1550, 1960}, []time.Duration{0, 0, 0, 0}, 0, "0 2"},
+		{"anchor with no partner is skipped", []float64{2000, 1500, 1550}, []time.Duration{0, 0, 0}, 0, "1 2"},
+	} {
+		mm := NewMatchmaker(NewHub())
+		index := make(map[*matchTicket]int)
+		for i, rating := range tc.ratings {
+			ticket := &matchTicket{client: &ClientConn{}, mode: ModeClassic, rating: rating, queuedAt: start.Add(-tc.queued[i])}
+			index[ticket] = i
+			mm.queues[ModeClassic] = append(mm.queues[ModeClassic], ticket)
+		}
+		var got []string
+		for _, ticket := range mm.findGroup(ModeClassic, start.Add(tc.after)) {
+			got = append(got, strconv.Itoa(index[ticket]))
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s: group %q, want %q", tc.name, strings.Join(got, " "), tc.want)
+		}
+	}
+}
+
+// readUntil reads messages until one of type kind arrives.
+func readUntil(t *testing.T, ws *websocket.Conn, kind string) json.RawMessage {
+	t.Helper()
+	for {
+		if ev := readEvents(t, ws, 1)[0]; ev.Type == kind {
+			return ev.Payload
+		}
+	}
+}
+
+func TestMatchmakerDropsDisconnectedPlayers(t *testing.T) {
+	h := NewHub()
+	go h.matchmaker.Run()
+	gone, goneWS := lobbyWsClient(t, h)
+	b, bWS := lobbyWsClient(t, h)
+	c, cWS := lobbyWsClient(t, h)
+
+	h.findMatch(gone, ModeClassic)
+	var status MatchStatus
+	json.Unmarshal(readUntil(t, goneWS, "match_status"), &status)
+	if status.Status != MatchStatusSearching || status.QueueSize != 1 {
+		t.Fatalf("first in queue got %+v", status)
+	}
+
+	// The first player disconnects before anyone else arrives; pairing
+	// must not seat them, and the next player waits alone
+	h.mu.Lock()
+	delete(h.clients, gone)
+	h.mu.Unlock()
+	h.findMatch(b, ModeClassic)
+	json.Unmarshal(readUntil(t, bWS, "match_status"), &status)
+	if status.QueueSize != 1 || status.Position != 1 {
+		t.Fatalf("after the disconnect the queue is %+v, want just the new player", status)
+	}
+
+	h.findMatch(c, ModeClassic)
+	var found [2]MatchFound
+	json.Unmarshal(readUntil(t, bWS, "match_found"), &found[0])
+	json.Unmarshal(readUntil(t, cWS, "match_found"), &found[1])
+	if found[0].RoomID == "" || found[0] != found[1] {
+		t.Fatalf("players matched into different rooms: %+v", found)
+	}
+	h.mu.Lock()
+	room := h.rooms[found[0].RoomID]
+	h.mu.Unlock()
+	room.RLock()
+	_, held := room.holds[gone.id]
+	_, seated := room.players[gone.id]
+	room.RUnlock()
+	if held || seated {
+		t.Error("disconnected player has a seat in the match")
+	}
+}
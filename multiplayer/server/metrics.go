@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons a message was dropped instead of delivered
+const (
+	DropSendQueueFull  = "send_queue_full"  // a client's send queue was full
+	DropInputQueueFull = "input_queue_full" // a room's movement input queue was full
+	DropShootQueueFull = "shoot_queue_full" // a room's shoot queue was full
+	DropRoomQueueFull  = "room_queue_full"  // any other room queue: ready, chat, host actions...
+)
+
+// Message types clients may send. Anything else is counted as "unknown" so
+// a misbehaving client cannot add labels without limit.
+var inboundTypes = []string{
+	"input", "shoot", "ready", "restart", "unready", "leave_room", "chat",
+	"kick_player", "lock_room", "change_settings", "transfer_host",
+	"create_room", "join_room", "find_match", "cancel_match", "watch_replay",
+	"replay_control", "stop_replay", "list_rooms", "set_name", "resync",
+}
+
+// Latency buckets in seconds, from well under a tick to several ticks late.
+var latencyBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.016, 0.025, 0.05, 0.1}
+
+// Metrics are the server's counters and histograms, served in Prometheus
+// text format at /metrics. Every method is safe for concurrent use.
+type Metrics struct {
+	tickDuration      *histogram
+	broadcastDuration *histogram
+	dropped           *counterVec
+	received          *counterVec
+	sent              *counterVec
+	bytesSent         atomic.Uint64
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		tickDuration:      newHistogram(latencyBuckets),
+		broadcastDuration: newHistogram(latencyBuckets),
+		dropped:           newCounterVec(),
+		received:          newCounterVec(),
+		sent:              newCounterVec(),
+	}
+	for _, reason := range []string{DropSendQueueFull, DropInputQueueFull, DropShootQueueFull, DropRoomQueueFull} {
+		m.dropped.add(reason, 0)
+	}
+	for _, t := range inboundTypes {
+		m.received.add(t, 0)
+	}
+	return m
+}
+
+var knownInbound = func() map[string]bool {
+	known := make(map[string]bool, len(inboundTypes))
+	for _, t := range inboundTypes {
+		known[t] = true
+	}
+	return known
+}()
+
+// Received counts a message from a client by type.
+func (m *Metrics) Received(msgType string) {
+	if !knownInbound[msgType] {
+		msgType = "unknown"
+	}
+	m.received.add(msgType, 1)
+}
+
+// Sent counts a message queued to a client. Types are chosen by the server.
+func (m *Metrics) Sent(msgType string) {
+	m.sent.add(msgType, 1)
+}
+
+// Dropped counts a message thrown away for one of the Drop reasons.
+func (m *Metrics) Dropped(reason string) {
+	m.dropped.add(reason, 1)
+}
+
+// counterVec is a counter with a single label.
+type counterVec struct {
+	mu     sync.RWMutex
+	values map[string]*atomic.Uint64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: make(map[string]*atomic.Uint64)}
+}
+
+func (v *counterVec) add(label string, n uint64) {
+	v.mu.RLock()
+	c, ok := v.values[label]
+	v.mu.RUnlock()
+	if !ok {
+		v.mu.Lock()
+		if c, ok = v.values[label]; !ok {
+			c = new(atomic.Uint64)
+			v.values[label] = c
+		}
+		v.mu.Unlock()
+	}
+	c.Add(n)
+}
+
+func (v *counterVec) snapshot() map[string]uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	out := make(map[string]uint64, len(v.values))
+	for label, c := range v.values {
+		out[label] = c.Load()
+	}
+	return out
+}
+
+// histogram counts durations into fixed buckets.
+type histogram struct {
+	bounds []float64       // upper bounds in seconds, ascending
+	counts []atomic.Uint64 // per bucket, not cumulative; the last is +Inf
+	sum    atomic.Int64    // nanoseconds
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// countingListener counts the bytes written to every connection it
+// accepts, WebSocket and plain HTTP alike.
+type countingListener struct {
+	net.Listener
+	sent *atomic.Uint64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn, l.sent}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	sent *atomic.Uint64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(uint64(n))
+	return n, err
+}
+
+// registerMetrics adds the /metrics endpoint.
+func registerMetrics(mux *http.ServeMux, h *Hub) {
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(h.metricsText())
+	})
+}
+
+// metricsText renders every metric. Gauges are read from the hub and rooms
+// at scrape time rather than kept up to date on every change.
+func (h *Hub) metricsText() []byte {
+	h.mu.RLock()
+	lobby := len(h.clients)
+	rooms := make([]*GameRoom, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.mu.RUnlock()
+
+	inRoom := 0
+	byState := map[string]uint64{
+		StateWaitingForPlayers: 0,
+		StateCountdown:         0,
+		StateInProgress:        0,
+		StateOvertime:          0,
+		StateGameOver:          0,
+	}
+	for _, room := range rooms {
+		room.RLock()
+		inRoom += len(room.clients)
+		byState[room.State]++
+		room.RUnlock()
+	}
+
+	m := h.metrics
+	var b bytes.Buffer
+	writeMetric(&b, "shooter_clients", "gauge", "Connected clients by where they are.", "where",
+		map[string]uint64{"lobby": uint64(lobby), "room": uint64(inRoom)})
+	writeMetric(&b, "shooter_rooms", "gauge", "Live rooms by state.", "state", byState)
+	writeHistogram(&b, "shooter_tick_duration_seconds", "Time spent on one physics tick.", m.tickDuration)
+	writeHistogram(&b, "shooter_broadcast_duration_seconds", "Time to encode a game state and queue it to every player in the room.", m.broadcastDuration)
+	writeMetric(&b, "shooter_dropped_messages_total", "counter", "Messages dropped instead of delivered, by reason.", "reason", m.dropped.snapshot())
+	writeMetric(&b, "shooter_messages_received_total", "counter", "Messages received from clients, by type.", "type", m.received.snapshot())
+	writeMetric(&b, "shooter_messages_sent_total", "counter", "Messages queued to clients, by type.", "type", m.sent.snapshot())
+	writeMetric(&b, "shooter_bytes_sent_total", "counter", "Bytes written to client connections, including HTTP.", "",
+		map[string]uint64{"": m.bytesSent.Load()})
+	writeMetric(&b, "go_goroutines", "gauge", "Number of goroutines that currently exist.", "",
+		map[string]uint64{"": uint64(runtime.NumGoroutine())})
+	return b.Bytes()
+}
+
+// writeMetric writes a counter or gauge with one label, or none if label is
+// empty, in which case values holds a single entry.
+func writeMetric(b *bytes.Buffer, name, kind, help, label string, values map[string]uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	if label == "" {
+		fmt.Fprintf(b, "%s %d\n", name, values[""])
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s=%s} %d\n", name, label, strconv.Quote(k), values[k])
+	}
+}
+
+func writeHistogram(b *bytes.Buffer, name, help string, h *histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var total uint64
+	for i := range h.counts {
+		total += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(b, "%s_bucket{le=%q} %d\n", name, le, total)
+	}
+	fmt.Fprintf(b, "%s_sum %g\n", name, time.Duration(h.sum.Load()).Seconds())
+	fmt.Fprintf(b, "%s_count %d\n", name, total)
+}
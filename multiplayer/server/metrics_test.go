@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsText(t *testing.T) {
+	h := NewHub()
+	h.metrics.Received("input")
+	h.metrics.Received("no_such_type")
+	h.metrics.Dropped(DropSendQueueFull)
+	h.metrics.tickDuration.observe(200 * time.Microsecond)
+	h.metrics.tickDuration.observe(time.Second)
+
+	text := string(h.metricsText())
+	for _, want := range []string{
+		`shooter_messages_received_total{type="input"} 1`,
+		`shooter_messages_received_total{type="unknown"} 1`,
+		`shooter_dropped_messages_total{reason="send_queue_full"} 1`,
+		`shooter_dropped_messages_total{reason="input_queue_full"} 0`,
+		`shooter_tick_duration_seconds_bucket{le="0.0001"} 0`,
+		`shooter_tick_duration_seconds_bucket{le="0.00025"} 1`,
+		`shooter_tick_duration_seconds_bucket{le="+Inf"} 2`,
+		`shooter_tick_duration_seconds_sum 1.0002`,
+		`shooter_rooms{state="waiting"} 0`,
+	} {
+		if !strings.Contains(text, want+"\n") {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	if strings.Contains(text, "no_such_type") {
+		t.Error("unknown message type used as a label")
+	}
+}
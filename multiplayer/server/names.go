@@ -0,0 +1,89 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinNameLength = 2
+	MaxNameLength = 16
+	guestPrefix   = "Guest-"
+)
+
+// Nickname error codes
+const (
+	ErrCodeNameInvalid = "name_invalid"
+	ErrCodeNameTaken   = "name_taken"
+)
+
+// Letters, digits, and a few separators. Single spaces are allowed between
+// words; leading/trailing whitespace is trimmed before validation.
+var validNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_\-.]+( [\p{L}\p{N}_\-.]+)*$`)
+
+// validateName trims a requested nickname and returns it along with an
+// error code and message if it is not acceptable.
+func validateName(name string) (string, string, string) {
+	name = strings.TrimSpace(name)
+	length := utf8.RuneCountInString(name)
+	if length < MinNameLength || length > MaxNameLength {
+		return name, ErrCodeNameInvalid, "Names must be 2-16 characters long"
+	}
+	if !validNamePattern.MatchString(name) {
+		return name, ErrCodeNameInvalid, "Names may only contain letters, numbers, spaces, '_', '-' and '.'"
+	}
+	return name, "", ""
+}
+
+// nameKey folds case so "Ace" and "ace" count as the same name.
+func nameKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// claimName gives client the requested nickname if it is valid and not used
+// by any other connected player, releasing its previous name. On failure the
+// client keeps its current name and an error code and message are returned.
+func (h *Hub) claimName(client *ClientConn, requested string) (string, string) {
+	name, code, message := validateName(requested)
+	if code != "" {
+		return code, message
+	}
+	if owner, ok := h.accounts.Owner(name); ok && owner != client.id {
+		return ErrCodeNameTaken, "That name belongs to a registered player"
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if owner, taken := h.names[nameKey(name)]; taken && owner != client {
+		return ErrCodeNameTaken, "That name is already in use"
+	}
+	if old := client.Name(); old != "" {
+		delete(h.names, nameKey(old))
+	}
+	h.names[nameKey(name)] = client
+	client.setName(name)
+	return "", ""
+}
+
+// assignGuestName gives a newly connected client a unique default name.
+func (h *Hub) assignGuestName(client *ClientConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name := guestPrefix + client.id[:6]
+	for i := 6; h.names[nameKey(name)] != nil && i < len(client.id); i++ {
+		name = guestPrefix + strings.ReplaceAll(client.id[:i+1], "-", "")
+	}
+	h.names[nameKey(name)] = client
+	client.setName(name)
+}
+
+// releaseName frees a disconnected client's nickname for reuse.
+func (h *Hub) releaseName(client *ClientConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := nameKey(client.Name())
+	if h.names[key] == client {
+		delete(h.names, key)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string // the normalized name, if accepted
+		ok   bool
+	}{
+		{"Ace", "Ace", true},
+		{"  Ace  ", "Ace", true},
+		{"Ace of Spades", "Ace of Spades", true},
+		{"a.b_c-d", "a.b_c-d", true},
+		{"Zoë", "Zoë", true},
+		{"игрок", "игрок", true},
+		{"42", "42", true},
+		{"ab", "ab", true},
+		{strings.Repeat("x", MaxNameLength), strings.Repeat("x", MaxNameLength), true},
+		{"a", "", false},
+		{"   a   ", "", false},
+		{"", "", false},
+		{strings.Repeat("x", MaxNameLength+1), "", false},
+		{"two  spaces", "", false},
+		{"tab\there", "", false},
+		{"<script>", "", false},
+		{"semi;colon", "", false},
+		{"emoji🙂", "", false},
+	} {
+		name, code, message := validateName(tc.in)
+		if tc.ok {
+			if code != "" || name != tc.want {
+				t.Errorf("validateName(%q) = %q, %s %q; want %q accepted", tc.in, name, code, message, tc.want)
+			}
+			continue
+		}
+		if code != ErrCodeNameInvalid || message == "" {
+			t.Errorf("validateName(%q) = %q, %q; want it rejected", tc.in, name, code)
+		}
+	}
+}
+
+func TestClaimName(t *testing.T) {
+	h := NewHub()
+	ann, bob := newLobbyClient(h), newLobbyClient(h)
+	if _, _, msg := h.accounts.Register("Owner", "correct horse"); msg != "" {
+		t.Fatal(msg)
+	}
+
+	for _, tc := range []struct {
+		client *ClientConn
+		name   string
+		code   string
+	}{
+		{ann, "Ace", ""},
+		{bob, "ace", ErrCodeNameTaken},   // names are compared without case
+		{bob, " ACE ", ErrCodeNameTaken}, // or surrounding spaces
+		{ann, "ACE", ""},                 // changing your own name's case is fine
+		{bob, "owner", ErrCodeNameTaken}, // registered to an account
+		{bob, "x", ErrCodeNameInvalid},
+		{ann, "Deuce", ""}, // frees Ace
+		{bob, "Ace", ""},
+	} {
+		before := tc.client.Name()
+		code, _ := h.claimName(tc.client, tc.name)
+		if code != tc.code {
+			t.Errorf("claiming %q: code %q, want %q", tc.name, code, tc.code)
+		}
+		if code != "" && tc.client.Name() != before {
+			t.Errorf("failed claim of %q renamed %s to %s", tc.name, before, tc.client.Name())
+		}
+	}
+	if ann.Name() != "Deuce" || bob.Name() != "Ace" {
+		t.Errorf("names are %s and %s, want Deuce and Ace", ann.Name(), bob.Name())
+	}
+
+	h.releaseName(bob)
+	if code, _ := h.claimName(ann, "ace"); code != "" {
+		t.Errorf("name of a disconnected player still taken: %s", code)
+	}
+}
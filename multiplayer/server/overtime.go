@@ -0,0 +1,118 @@
+package main
+
+// Overtime policies decide what happens when the round clock runs out with
+// the players level on HP.
+const (
+	OvertimeNone        = "none"            // the round is a draw
+	OvertimeSuddenDeath = "sudden_death"    // the next hit wins
+	OvertimeShrink      = "shrinking_arena" // the walls close in
+	OvertimeExtraTime   = "extra_time"      // more time with faster cooldowns
+)
+
+var validOvertime = map[string]bool{
+	OvertimeNone: true, OvertimeSuddenDeath: true, OvertimeShrink: true, OvertimeExtraTime: true,
+}
+
+const (
+	OvertimeDuration      = 30.0 // seconds; the default for GameConfig.OvertimeDuration
+	OvertimeCooldownScale = 0.5  // extra time shoot cooldown, relative to normal
+	MinArenaScale         = 0.3  // shrinking arena's final size, relative to the canvas
+)
+
+// Rect is the playable area. It is the whole canvas except while a
+// shrinking-arena overtime is running.
+type Rect struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+// inRound reports whether players are fighting, in regular time or overtime.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) inRound() bool {
+	return gr.State == StateInProgress || gr.State == StateOvertime
+}
+
+// timeUp ends the round when the clock reaches zero, or starts overtime if
+// the players are level and the room's policy allows it.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) timeUp() {
+	gr.timeRemaining = 0
+	// Determine winner by HP
+	var highestHP int = -1
+	var winnerId string
+	var tie bool
+	for _, p := range gr.players {
+		if p.CurrentHP > highestHP {
+			highestHP = p.CurrentHP
+			winnerId = p.ID
+			tie = false
+		} else if p.CurrentHP == highestHP {
+			tie = true
+		}
+	}
+
+	if tie && gr.State == StateInProgress && gr.overtime != OvertimeNone {
+		gr.State = StateOvertime
+		gr.hadOvertime = true
+		gr.timeRemaining = gr.cfg.OvertimeDuration.Seconds()
+		gr.log.Info("Round time expired level; overtime", "overtime", gr.overtime, "state", gr.State)
+		gr.hub.roomChanged(gr)
+		return
+	}
+
+	if !tie {
+		gr.WinnerID = winnerId
+	} else {
+		gr.WinnerID = "" // draw
+	}
+	gr.State = StateGameOver
+	gr.log.Info("Round time expired", "winner", gr.WinnerID, "tie", tie, "state", gr.State)
+	gr.finishRound()
+}
+
+// overtimeRule reports whether policy is the overtime currently being played.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) overtimeRule(policy string) bool {
+	return gr.State == StateOvertime && gr.overtime == policy
+}
+
+// hitDamage is how much HP a bullet hit takes.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) hitDamage(p *Player) int {
+	if gr.overtimeRule(OvertimeSuddenDeath) {
+		return p.CurrentHP
+	}
+	return gr.cfg.BulletDamage
+}
+
+// shootCooldown is the delay between a player's shots.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) shootCooldown() float64 {
+	cooldown := gr.cfg.ShootCooldown.Seconds()
+	if gr.overtimeRule(OvertimeExtraTime) {
+		return cooldown * OvertimeCooldownScale
+	}
+	return cooldown
+}
+
+// shrinkArena narrows the playable area as shrinking-arena overtime runs down.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) shrinkArena() {
+	if !gr.overtimeRule(OvertimeShrink) {
+		return
+	}
+	cfg := &gr.cfg
+	progress := 1 - gr.timeRemaining/cfg.OvertimeDuration.Seconds()
+	scale := 1 - progress*(1-MinArenaScale)
+	w, h := cfg.CanvasWidth*scale, cfg.CanvasHeight*scale
+	// Never smaller than a tank, so nobody gets squeezed out of the world
+	if w < cfg.PlayerWidth {
+		w = cfg.PlayerWidth
+	}
+	if h < cfg.PlayerHeight {
+		h = cfg.PlayerHeight
+	}
+	gr.arena = Rect{X: (cfg.CanvasWidth - w) / 2, Y: (cfg.CanvasHeight - h) / 2, W: w, H: h}
+}
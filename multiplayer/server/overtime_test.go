@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// levelRoom returns a room mid-round under policy with two players on hp each.
+func levelRoom(policy string, hp int) *GameRoom {
+	room := NewGameRoom("r1", "ABCDEF", NewHub(), RoomOptions{Overtime: policy})
+	for _, id := range []string{"a", "b"} {
+		room.players[id] = &Player{ID: id, CurrentHP: hp, MaxHP: room.cfg.PlayerMaxHP}
+		room.addToJoinOrder(id)
+	}
+	room.State = StateInProgress
+	return room
+}
+
+func TestOvertimePolicies(t *testing.T) {
+	for _, policy := range []string{OvertimeNone, OvertimeSuddenDeath, OvertimeShrink, OvertimeExtraTime} {
+		room := levelRoom(policy, 6)
+		room.timeUp()
+
+		if policy == OvertimeNone {
+			if room.State != StateGameOver || room.WinnerID != "" || room.hadOvertime {
+				t.Errorf("none: state %s, winner %q, overtime %v; want a draw", room.State, room.WinnerID, room.hadOvertime)
+			}
+			continue
+		}
+		if room.State != StateOvertime || !room.hadOvertime || room.timeRemaining != room.cfg.OvertimeDuration.Seconds() {
+			t.Fatalf("%s: state %s with %.1fs left, want overtime with %v", policy, room.State, room.timeRemaining, room.cfg.OvertimeDuration)
+		}
+
+		// Each policy changes only its own rule
+		p := room.players["a"]
+		if got, want := room.hitDamage(p) == p.CurrentHP, policy == OvertimeSuddenDeath; got != want {
+			t.Errorf("%s: hit damage %d with %d HP left", policy, room.hitDamage(p), p.CurrentHP)
+		}
+		cooldown := room.cfg.ShootCooldown.Seconds()
+		if policy == OvertimeExtraTime {
+			cooldown *= OvertimeCooldownScale
+		}
+		if got := room.shootCooldown(); got != cooldown {
+			t.Errorf("%s: shoot cooldown %v, want %v", policy, got, cooldown)
+		}
+		room.timeRemaining = room.cfg.OvertimeDuration.Seconds() / 2
+		room.shrinkArena()
+		scale := 1.0
+		if policy == OvertimeShrink {
+			scale = 1 - 0.5*(1-MinArenaScale)
+		}
+		if want := room.cfg.CanvasWidth * scale; math.Abs(room.arena.W-want) > 1e-9 {
+			t.Errorf("%s: arena %.1f wide halfway through, want %.1f", policy, room.arena.W, want)
+		}
+		if want := (room.cfg.CanvasWidth - room.arena.W) / 2; math.Abs(room.arena.X-want) > 1e-9 {
+			t.Errorf("%s: arena at x %.1f, want it centred at %.1f", policy, room.arena.X, want)
+		}
+
+		// Still level when overtime runs out is a draw, not more overtime
+		room.timeUp()
+		if room.State != StateGameOver || room.WinnerID != "" {
+			t.Errorf("%s: level after overtime gives state %s, winner %q; want a draw", policy, room.State, room.WinnerID)
+		}
+	}
+}
+
+func TestOvertimeEnds(t *testing.T) {
+	// Ahead when regular time ends: no overtime
+	room := levelRoom(OvertimeSuddenDeath, 6)
+	room.players["b"].CurrentHP = 4
+	room.timeUp()
+	if room.State != StateGameOver || room.WinnerID != "a" || room.hadOvertime {
+		t.Errorf("leader at full time: state %s, winner %q, overtime %v", room.State, room.WinnerID, room.hadOvertime)
+	}
+
+	// Ahead when overtime ends: the leader wins
+	room = levelRoom(OvertimeExtraTime, 6)
+	room.timeUp()
+	room.players["a"].CurrentHP = 2
+	room.timeUp()
+	if room.State != StateGameOver || room.WinnerID != "b" || !room.hadOvertime {
+		t.Errorf("leader after overtime: state %s, winner %q, overtime %v", room.State, room.WinnerID, room.hadOvertime)
+	}
+
+	// The shrinking arena never gets smaller than a tank
+	room = levelRoom(OvertimeShrink, 6)
+	room.timeUp()
+	room.timeRemaining = 0
+	room.shrinkArena()
+	if room.arena.W < room.cfg.PlayerWidth || room.arena.H < room.cfg.PlayerHeight {
+		t.Errorf("final arena %+v is smaller than a tank", room.arena)
+	}
+	if want := room.cfg.CanvasWidth * MinArenaScale; math.Abs(room.arena.W-max(want, room.cfg.PlayerWidth)) > 1e-9 {
+		t.Errorf("final arena %.1f wide, want %.1f", room.arena.W, want)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// ReplayMemoryBytes caps how much of recent rounds' snapshot logs is kept in
+// memory for playback. Older rounds can still be played from replay files.
+const ReplayMemoryBytes = 64 << 20
+
+// A round's log is capped while it records: past ReplayRoundBytes, frames
+// older than the last ReplayTailFrames are thinned out to one per keyframe
+// interval, then sparser still if that is not enough.
+const (
+	ReplayRoundBytes = 8 << 20
+	ReplayTailFrames = 300
+)
+
+// ReplayStatusInterval is how often a playing replay reports its position,
+// so the viewer's progress bar and seeking stay current.
+const ReplayStatusInterval = 250 * time.Millisecond
+
+const ErrCodeReplayNotFound = "replay_not_found"
+
+// Playback speeds a viewer may pick
+var replaySpeeds = map[float64]bool{0.5: true, 1: true, 2: true, 4: true}
+
+// replayFrame is one gameState message as it was sent to players.
+type replayFrame struct {
+	tick int64
+	data []byte
+}
+
+// ReplayLog holds the gameState messages of one round in tick order. The
+// room appends to it while the round runs; once sealed it never changes and
+// any number of viewers may read it.
+type ReplayLog struct {
+	MatchID  string
+	TickRate float64
+
+	mu     sync.Mutex
+	frames []replayFrame
+	size   int
+	sealed bool
+	limit  int   // bytes before thinning; 0 keeps every frame
+	stride int64 // ticks between frames kept by the last thinning
+}
+
+func newReplayLog(matchID string, tickRate int) *ReplayLog {
+	return &ReplayLog{MatchID: matchID, TickRate: float64(tickRate)}
+}
+
+// add appends a frame. A frame for the same tick as the last one replaces it,
+// so the log always shows where the tick ended up.
+func (l *ReplayLog) add(tick int64, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sealed {
+		return
+	}
+	if n := len(l.frames); n > 0 && l.frames[n-1].tick >= tick {
+		l.size -= len(l.frames[n-1].data)
+		l.frames = l.frames[:n-1]
+	}
+	l.frames = append(l.frames, replayFrame{tick, data})
+	l.size += len(data)
+	if l.limit > 0 && l.size > l.limit {
+		l.thin()
+	}
+}
+
+// thin drops older frames until the log is well inside its limit, keeping
+// the first frame, one frame every stride ticks and the last
+// ReplayTailFrames. Playback steps between the frames that are left, as it
+// does between keyframes. Caller must hold l.mu.
+func (l *ReplayLog) thin() {
+	if l.stride == 0 {
+		l.stride = ReplayKeyframeTicks
+	}
+	target := l.limit * 3 / 4 // room to grow before thinning again
+	for l.size > target {
+		tail := len(l.frames) - ReplayTailFrames
+		kept, size := l.frames[:0], 0
+		var last int64
+		for i, f := range l.frames {
+			if i == 0 || i >= tail || f.tick-last >= l.stride {
+				kept = append(kept, f)
+				size += len(f.data)
+				if i < tail {
+					last = f.tick
+				}
+			}
+		}
+		dropped := len(l.frames) - len(kept)
+		clear(l.frames[len(kept):]) // let the dropped frames' data go
+		l.frames, l.size = kept, size
+		if l.size > target {
+			l.stride *= 2
+		}
+		if dropped == 0 && l.stride > l.frames[len(l.frames)-1].tick {
+			return // only the tail is left to drop, and that stays
+		}
+	}
+}
+
+func (l *ReplayLog) seal() {
+	l.mu.Lock()
+	l.sealed = true
+	l.mu.Unlock()
+}
+
+// frameAt returns the index of the last frame at or before tick. Only call
+// it on a sealed log.
+func (l *ReplayLog) frameAt(tick int64) int {
+	i := sort.Search(len(l.frames), func(i int) bool { return l.frames[i].tick > tick })
+	return max(i-1, 0)
+}
+
+func (l *ReplayLog) lastTick() int64 {
+	return l.frames[len(l.frames)-1].tick
+}
+
+// ReplayLibrary keeps the most recent rounds' logs in memory.
+type ReplayLibrary struct {
+	mu     sync.Mutex
+	logs   map[string]*ReplayLog
+	order  []string // match IDs, oldest first
+	size   int
+	budget int
+}
+
+func NewReplayLibrary(budget int) *ReplayLibrary {
+	return &ReplayLibrary{logs: make(map[string]*ReplayLog), budget: budget}
+}
+
+// add stores a sealed log, evicting the oldest ones to stay within budget.
+func (lib *ReplayLibrary) add(l *ReplayLog) {
+	if len(l.frames) == 0 {
+		return
+	}
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	lib.logs[l.MatchID] = l
+	lib.order = append(lib.order, l.MatchID)
+	lib.size += l.size
+	for lib.size > lib.budget && len(lib.order) > 1 {
+		oldest := lib.logs[lib.order[0]]
+		delete(lib.logs, lib.order[0])
+		lib.order = lib.order[1:]
+		lib.size -= oldest.size
+	}
+}
+
+// get finds a round's log in memory, or loads it from its replay file.
+func (lib *ReplayLibrary) get(matchID string, disk *ReplayStore) (*ReplayLog, error) {
+	lib.mu.Lock()
+	l, ok := lib.logs[matchID]
+	lib.mu.Unlock()
+	if ok {
+		return l, nil
+	}
+	if disk == nil {
+		return nil, os.ErrNotExist
+	}
+	return disk.load(matchID)
+}
+
+// load reads a replay file back into a log. Files recorded with frames play
+// as smoothly as the live round; older ones step from keyframe to keyframe.
+func (s *ReplayStore) load(matchID string) (*ReplayLog, error) {
+	// Match IDs are UUIDs; anything else could walk out of the directory
+	if _, err := uuid.Parse(matchID); err != nil {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(s.Path(matchID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(gz)
+
+	var header ReplayHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Format != ReplayFormat || header.Version > ReplayVersion {
+		return nil, fmt.Errorf("replay %s has unsupported format %q version %d", matchID, header.Format, header.Version)
+	}
+	frames := newReplayLog(matchID, 0)
+	keyframes := newReplayLog(matchID, 0)
+	frames.TickRate, keyframes.TickRate = header.TickRate, header.TickRate
+	if data, err := json.Marshal(Message{Type: "gameState", Payload: header.Initial}); err == nil {
+		keyframes.add(0, data)
+	}
+	for {
+		var e ReplayEvent
+		if err := dec.Decode(&e); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case e.Kind == ReplayFrame:
+			frames.add(e.Tick, e.Frame)
+		case e.Kind == ReplayKeyframe && e.State != nil:
+			if data, err := json.Marshal(Message{Type: "gameState", Payload: e.State}); err == nil {
+				keyframes.add(e.Tick, data)
+			}
+		}
+	}
+	l := frames
+	if len(frames.frames) == 0 {
+		l = keyframes
+	}
+	l.seal()
+	return l, nil
+}
+
+type replayControl struct {
+	action string // "pause", "play", "seek" or "speed"
+	tick   int64
+	speed  float64
+}
+
+// replayViewer streams one log to one client on its own goroutine.
+type replayViewer struct {
+	log     *ReplayLog
+	control chan replayControl
+	stop    chan struct{}
+}
+
+// watchReplay starts streaming a match to a lobby client, replacing any
+// replay it was already watching. Only called from readPump.
+func (c *ClientConn) watchReplay(payload map[string]interface{}) {
+	matchID, _ := payload["matchId"].(string)
+	speed := 1.0
+	if s, ok := payload["speed"].(float64); ok {
+		speed = s
+	}
+	if !replaySpeeds[speed] {
+		c.sendError(ErrCodeInvalidRequest, "Speed must be 0.5, 1, 2 or 4")
+		return
+	}
+	startTick, _ := payload["tick"].(float64)
+
+	l, err := c.hub.replayLogs.get(matchID, c.hub.replays)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.logger().Error("Loading replay", "match", matchID, "err", err)
+		}
+		c.sendError(ErrCodeReplayNotFound, "That replay is not available")
+		return
+	}
+	c.stopReplay()
+	v := &replayViewer{log: l, control: make(chan replayControl, 8), stop: make(chan struct{})}
+	c.viewer = v
+	c.logger().Info("Watching replay", "match", matchID)
+	go v.run(c, int64(startTick), speed)
+}
+
+// controlReplay forwards a pause, play, seek or speed request to the viewer.
+// Only called from readPump.
+func (c *ClientConn) controlReplay(payload map[string]interface{}) {
+	if c.viewer == nil {
+		c.sendError(ErrCodeWrongState, "You are not watching a replay")
+		return
+	}
+	ctl := replayControl{}
+	ctl.action, _ = payload["action"].(string)
+	tick, _ := payload["tick"].(float64)
+	ctl.tick = int64(tick)
+	ctl.speed, _ = payload["speed"].(float64)
+	switch ctl.action {
+	case "pause", "play", "seek":
+	case "speed":
+		if !replaySpeeds[ctl.speed] {
+			c.sendError(ErrCodeInvalidRequest, "Speed must be 0.5, 1, 2 or 4")
+			return
+		}
+	default:
+		c.sendError(ErrCodeInvalidRequest, "Unknown replay action")
+		return
+	}
+	select {
+	case c.viewer.control <- ctl:
+	default:
+	}
+}
+
+// stopReplay ends the replay the client is watching, if any. Only called
+// from readPump.
+func (c *ClientConn) stopReplay() bool {
+	if c.viewer == nil {
+		return false
+	}
+	close(c.viewer.stop)
+	c.viewer = nil
+	return true
+}
+
+func (v *replayViewer) run(c *ClientConn, startTick int64, speed float64) {
+	frames := v.log.frames
+	pos := v.log.frameAt(startTick)
+	paused := false
+
+	send := func() {
+		frame, err := websocket.NewPreparedMessage(websocket.TextMessage, frames[pos].data)
+		if err != nil {
+			return
+		}
+		select {
+		case c.send <- frame:
+			c.hub.metrics.Sent("gameState")
+		default:
+			// Same as a live round: a full buffer drops the frame
+			c.hub.metrics.Dropped(DropSendQueueFull)
+		}
+	}
+	status := func() {
+		c.sendMessage("replay_status", map[string]interface{}{
+			"matchId":  v.log.MatchID,
+			"tick":     frames[pos].tick,
+			"lastTick": v.log.lastTick(),
+			"tickRate": v.log.TickRate,
+			"paused":   paused,
+			"speed":    speed,
+			"ended":    pos == len(frames)-1,
+		})
+	}
+	status()
+	send()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	statusTicker := time.NewTicker(ReplayStatusInterval)
+	defer statusTicker.Stop()
+	for {
+		// A quick match can seat the viewer from outside readPump, and the
+		// room's state takes over from the replay. Status ticks wake even a
+		// paused viewer to check.
+		c.roomMu.Lock()
+		seated := c.room != nil
+		c.roomMu.Unlock()
+		if seated {
+			return
+		}
+		timer.Stop()
+		var next <-chan time.Time
+		if !paused && pos+1 < len(frames) {
+			ticks := float64(frames[pos+1].tick - frames[pos].tick)
+			timer.Reset(time.Duration(ticks / v.log.TickRate / speed * float64(time.Second)))
+			next = timer.C
+		}
+		select {
+		case <-next:
+			pos++
+			send()
+			if pos == len(frames)-1 {
+				paused = true
+				status()
+			}
+		case ctl := <-v.control:
+			switch ctl.action {
+			case "pause":
+				paused = true
+			case "play":
+				if pos == len(frames)-1 {
+					pos = 0 // play again from the start
+					send()
+				}
+				paused = false
+			case "seek":
+				pos = v.log.frameAt(ctl.tick)
+				send()
+			case "speed":
+				speed = ctl.speed
+			}
+			status()
+		case <-statusTicker.C:
+			if !paused {
+				status()
+			}
+		case <-v.stop:
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
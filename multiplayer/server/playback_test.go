@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReplayLogThinning(t *testing.T) {
+	frame := bytes.Repeat([]byte("x"), 100)
+	l := newReplayLog(uuid.NewString(), 60)
+	const slack = ReplayTailFrames // frames beyond the tail that fit in the limit
+	l.limit = len(frame) * (ReplayTailFrames + slack)
+	const frames = 5000
+	for i := 1; i <= frames; i++ {
+		l.add(int64(2*i), frame)
+	}
+
+	if l.size > l.limit || l.size != len(l.frames)*len(frame) {
+		t.Fatalf("log holds %d bytes in %d frames, limit %d", l.size, len(l.frames), l.limit)
+	}
+	if l.frames[0].tick != 2 {
+		t.Errorf("first frame is tick %d, want the round's start kept", l.frames[0].tick)
+	}
+	tail := l.frames[len(l.frames)-ReplayTailFrames:]
+	for i, f := range tail {
+		if want := int64(2 * (frames - ReplayTailFrames + 1 + i)); f.tick != want {
+			t.Fatalf("tail frame %d is tick %d, want %d: the tail must stay whole", i, f.tick, want)
+		}
+	}
+	// Frames that left the tail since the last thinning are not thinned yet
+	for i := 1; i < len(l.frames)-ReplayTailFrames-slack; i++ {
+		if gap := l.frames[i].tick - l.frames[i-1].tick; gap < ReplayKeyframeTicks {
+			t.Fatalf("thinned frames %d and %d are %d ticks apart", i-1, i, gap)
+		}
+	}
+
+	// A log with no limit keeps everything
+	all := newReplayLog(uuid.NewString(), 60)
+	for i := 1; i <= frames; i++ {
+		all.add(int64(i), frame)
+	}
+	if len(all.frames) != frames {
+		t.Errorf("unlimited log kept %d of %d frames", len(all.frames), frames)
+	}
+}
+
+func TestRoundsKeepALogWithoutReplayFiles(t *testing.T) {
+	for _, opts := range []RoomOptions{{}, {NoReplay: true}} {
+		h := NewHub()
+		room := NewGameRoom("r1", "ABCDEF", h, opts)
+		room.startGame()
+		if room.recording() {
+			t.Fatal("room records replay files with no replay store")
+		}
+		room.broadcastGameState()
+		room.tick = 1
+		room.broadcastGameState()
+		room.stopRecording(&ReplayEvent{})
+
+		l, err := h.replayLogs.get(room.matchID, nil)
+		if err != nil {
+			t.Fatalf("noReplay %v: round's log not kept in memory: %v", opts.NoReplay, err)
+		}
+		if len(l.frames) != 2 || !l.sealed {
+			t.Errorf("noReplay %v: log has %d frames, sealed %v; want 2 and sealed", opts.NoReplay, len(l.frames), l.sealed)
+		}
+	}
+}
+
+func TestReplayViewerStopsWhenSeated(t *testing.T) {
+	h := NewHub()
+	c, ws := lobbyWsClient(t, h)
+	l := newReplayLog(uuid.NewString(), 30)
+	for tick := int64(0); tick < 3000; tick++ {
+		l.add(tick, []byte(`{"type":"gameState","payload":{}}`))
+	}
+	l.seal()
+	h.replayLogs.add(l)
+
+	c.watchReplay(map[string]interface{}{"matchId": l.MatchID})
+	readUntil(t, ws, "replay_status")
+	readUntil(t, ws, "gameState")
+
+	// Seated by a quick match, which does not go through readPump
+	room := NewGameRoom("r1", "ABCDEF", h, RoomOptions{})
+	c.roomMu.Lock()
+	c.room = room
+	c.roomMu.Unlock()
+
+	// Frames already on their way may still arrive before the first
+	// marker; between the two markers there must be nothing
+	time.Sleep(2 * ReplayStatusInterval)
+	c.sendMessage("marker", nil)
+	readUntil(t, ws, "marker")
+	time.Sleep(2 * ReplayStatusInterval)
+	c.sendMessage("marker", nil)
+	if ev := readEvents(t, ws, 1)[0]; ev.Type != "marker" {
+		t.Errorf("replay still streaming after the viewer was seated: got %s", ev.Type)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// Elo parameters. New players move faster until their rating settles.
+const (
+	InitialRating      = 1500.0
+	RatingKProvisional = 40.0
+	RatingKEstablished = 20.0
+	ProvisionalGames   = 10
+)
+
+// PlayerRating is the skill record kept per player identity.
+type PlayerRating struct {
+	Rating float64 `json:"rating"`
+	Games  int     `json:"games"`
+	Wins   int     `json:"wins"`
+	Losses int     `json:"losses"`
+	Draws  int     `json:"draws"`
+}
+
+// RatingStore holds ratings for every player seen since the server started.
+// It is shared by all rooms and the matchmaker.
+type RatingStore struct {
+	mu      sync.Mutex
+	ratings map[string]*PlayerRating
+}
+
+func NewRatingStore() *RatingStore {
+	return &RatingStore{ratings: make(map[string]*PlayerRating)}
+}
+
+// Get returns a copy of a player's rating, or the initial rating if the
+// player has never finished a round.
+func (rs *RatingStore) Get(playerID string) PlayerRating {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if r, ok := rs.ratings[playerID]; ok {
+		return *r
+	}
+	return PlayerRating{Rating: InitialRating}
+}
+
+// RecordResult updates ratings after a completed round. An empty winnerID is
+// a draw. Every participant is scored pairwise against every other one and
+// the per-player change is returned, rounded to whole points.
+func (rs *RatingStore) RecordResult(playerIDs []string, winnerID string) map[string]int {
+	if len(playerIDs) < 2 {
+		return nil
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	current := make([]*PlayerRating, len(playerIDs))
+	for i, id := range playerIDs {
+		r, ok := rs.ratings[id]
+		if !ok {
+			r = &PlayerRating{Rating: InitialRating}
+			rs.ratings[id] = r
+		}
+		current[i] = r
+	}
+
+	// Compute every delta from pre-round ratings before applying any of them
+	deltas := make([]float64, len(playerIDs))
+	for i := range playerIDs {
+		k := RatingKEstablished
+		if current[i].Games < ProvisionalGames {
+			k = RatingKProvisional
+		}
+		k /= float64(len(playerIDs) - 1)
+		for j := range playerIDs {
+			if i == j {
+				continue
+			}
+			score := 0.5
+			if playerIDs[i] == winnerID {
+				score = 1
+			} else if playerIDs[j] == winnerID {
+				score = 0
+			}
+			deltas[i] += k * (score - expectedScore(current[i].Rating, current[j].Rating))
+		}
+	}
+
+	changes := make(map[string]int, len(playerIDs))
+	for i, id := range playerIDs {
+		r := current[i]
+		r.Rating += deltas[i]
+		r.Games++
+		switch {
+		case winnerID == "":
+			r.Draws++
+		case id == winnerID:
+			r.Wins++
+		default:
+			r.Losses++
+		}
+		changes[id] = int(math.Round(deltas[i]))
+	}
+	return changes
+}
+
+// expectedScore is the Elo probability that a player rated ra beats rb.
+func expectedScore(ra, rb float64) float64 {
+	return 1 / (1 + math.Pow(10, (rb-ra)/400))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRecordResult(t *testing.T) {
+	established := func(rating float64) *PlayerRating {
+		return &PlayerRating{Rating: rating, Games: ProvisionalGames}
+	}
+	for _, tc := range []struct {
+		name    string
+		before  map[string]*PlayerRating // missing players are new
+		players []string
+		winner  string
+		changes map[string]int
+		after   map[string]float64
+	}{
+		{
+			name:    "new players, even match",
+			players: []string{"a", "b"},
+			winner:  "a",
+			changes: map[string]int{"a": 20, "b": -20}, // K 40, expected 0.5
+			after:   map[string]float64{"a": 1520, "b": 1480},
+		},
+		{
+			name:    "new players, draw",
+			players: []string{"a", "b"},
+			changes: map[string]int{"a": 0, "b": 0},
+			after:   map[string]float64{"a": 1500, "b": 1500},
+		},
+		{
+			name:    "upset between established players",
+			before:  map[string]*PlayerRating{"a": established(1600), "b": established(1400)},
+			players: []string{"a", "b"},
+			winner:  "b",
+			changes: map[string]int{"a": -15, "b": 15}, // K 20, b expected 0.240
+			after:   map[string]float64{"a": 1584.805, "b": 1415.195},
+		},
+		{
+			name:    "favourite held to a draw",
+			before:  map[string]*PlayerRating{"a": established(1600), "b": established(1400)},
+			players: []string{"a", "b"},
+			changes: map[string]int{"a": -5, "b": 5},
+			after:   map[string]float64{"a": 1594.805, "b": 1405.195},
+		},
+		{
+			name:    "favourite forfeits",
+			before:  map[string]*PlayerRating{"a": established(1600), "b": established(1400)},
+			players: []string{"a", "b"},
+			winner:  "b", // recordForfeit scores the leaver as the loser
+			changes: map[string]int{"a": -15, "b": 15},
+			after:   map[string]float64{"a": 1584.805, "b": 1415.195},
+		},
+		{
+			name:    "provisional player moves faster",
+			before:  map[string]*PlayerRating{"b": established(1500)},
+			players: []string{"a", "b"},
+			winner:  "a",
+			changes: map[string]int{"a": 20, "b": -10},
+			after:   map[string]float64{"a": 1520, "b": 1490},
+		},
+		{
+			name:    "three players share K",
+			players: []string{"a", "b", "c"},
+			winner:  "a",
+			changes: map[string]int{"a": 20, "b": -10, "c": -10}, // K 20 per opponent
+			after:   map[string]float64{"a": 1520, "b": 1490, "c": 1490},
+		},
+	} {
+		rs := NewRatingStore()
+		for id, r := range tc.before {
+			rs.ratings[id] = r
+		}
+		changes := rs.RecordResult(tc.players, tc.winner)
+		for _, id := range tc.players {
+			if changes[id] != tc.changes[id] {
+				t.Errorf("%s: %s changed by %d, want %d", tc.name, id, changes[id], tc.changes[id])
+			}
+			r := rs.Get(id)
+			if math.Abs(r.Rating-tc.after[id]) > 0.001 {
+				t.Errorf("%s: %s rated %.3f, want %.3f", tc.name, id, r.Rating, tc.after[id])
+			}
+			wantWins, wantLosses, wantDraws := 0, 0, 0
+			switch {
+			case tc.winner == "":
+				wantDraws = 1
+			case id == tc.winner:
+				wantWins = 1
+			default:
+				wantLosses = 1
+			}
+			if r.Wins != wantWins || r.Losses != wantLosses || r.Draws != wantDraws {
+				t.Errorf("%s: %s record %+v, want %d-%d-%d", tc.name, id, r, wantWins, wantLosses, wantDraws)
+			}
+		}
+	}
+
+	if changes := NewRatingStore().RecordResult([]string{"a"}, "a"); changes != nil {
+		t.Errorf("a round with one player changed ratings: %v", changes)
+	}
+}
+
+func TestExpectedScore(t *testing.T) {
+	for _, tc := range []struct{ ra, rb, want float64 }{
+		{1500, 1500, 0.5},
+		{1600, 1400, 0.760},
+		{1400, 1600, 0.240},
+		{2000, 1600, 0.909},
+	} {
+		if got := expectedScore(tc.ra, tc.rb); math.Abs(got-tc.want) > 0.001 {
+			t.Errorf("expectedScore(%v, %v) = %.3f, want %.3f", tc.ra, tc.rb, got, tc.want)
+		}
+	}
+}
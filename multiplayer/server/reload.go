@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// restartOnly lists settings a running server cannot pick up: they are read
+// once at startup. A reload that changes them keeps the running value.
+var restartOnly = []string{
+	"addr",
+	"authSecret",
+	"network.readBufferSize",
+	"network.writeBufferSize",
+	"network.handshakeTimeout",
+	"storage.",
+	"log.format",
+	"log.sampleBurst",
+	"log.sampleInterval",
+}
+
+func isRestartOnly(path string) bool {
+	for _, p := range restartOnly {
+		if path == p || strings.HasSuffix(p, ".") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchReload reloads the config file on SIGHUP. The file, environment and
+// command line are applied again in the usual order, so a flag still wins
+// over the file. Maps are built in and are not reloaded.
+func watchReload(h *Hub, path string, fs *flag.FlagSet) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if path == "" {
+			slog.Warn("Config reload: no config file to reload; start with -config to use SIGHUP")
+			continue
+		}
+		cfg, err := LoadConfig(path, os.LookupEnv, fs)
+		if err != nil {
+			slog.Error("Config reload failed; keeping the running config", "err", err)
+			continue
+		}
+		h.reload(cfg)
+	}
+}
+
+// reload swaps in a validated config and logs what changed. Rooms that
+// already exist keep the settings they were created with; new rooms,
+// connections and lobby checks see the new ones.
+func (h *Hub) reload(cfg Config) {
+	old := h.config()
+	changed := 0
+	walkConfigPair(reflect.ValueOf(old).Elem(), reflect.ValueOf(&cfg).Elem(), "", func(path string, was, now reflect.Value) {
+		if reflect.DeepEqual(was.Interface(), now.Interface()) {
+			return
+		}
+		if isRestartOnly(path) {
+			slog.Warn("Config reload: setting only changes on restart; keeping it", "setting", path, "value", configValueString(path, was))
+			now.Set(was)
+			return
+		}
+		slog.Info("Config reload: setting changed", "setting", path, "old", configValueString(path, was), "new", configValueString(path, now))
+		changed++
+	})
+	h.cfg.Store(&cfg)
+	if level, err := parseLogLevel(cfg.Log.Level); err == nil {
+		logLevel.Set(level)
+	}
+	slog.Info("Config reloaded; changes apply to rooms created from now on", "changed", changed)
+}
+
+// walkConfigPair is walkConfig over two configs at once.
+func walkConfigPair(a, b reflect.Value, prefix string, fn func(path string, a, b reflect.Value)) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		path := prefix + t.Field(i).Tag.Get("json")
+		if f := a.Field(i); f.Kind() == reflect.Struct {
+			walkConfigPair(f, b.Field(i), path+".", fn)
+		} else {
+			fn(path, f, b.Field(i))
+		}
+	}
+}
+
+func configValueString(path string, v reflect.Value) string {
+	if path == "authSecret" {
+		return "(redacted)"
+	}
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replay files are gzipped JSON lines: a ReplayHeader, then one ReplayEvent
+// per line in tick order, then optionally every gameState frame the players
+// were sent, ending with an "end" event. A file without its end event was
+// cut short and is never left under its final name.
+const (
+	ReplayFormat  = "shooter-replay"
+	ReplayVersion = 1
+	replayExt     = ".replay"
+	partialExt    = ".part" // added to replayExt while a file is being written
+)
+
+const (
+	// ReplayKeyframeTicks is how often a full snapshot is written, so playback
+	// can seek without simulating from the start.
+	ReplayKeyframeTicks = 30
+	// ReplayQueueSize bounds the events waiting for the writer. A round that
+	// overflows it is dropped rather than ever stalling the tick loop.
+	ReplayQueueSize = 4096
+	// ReplayReadyLogSize bounds the ready actions kept between rounds.
+	ReplayReadyLogSize = 64
+)
+
+// Replay event kinds
+const (
+	ReplayInput    = "input"
+	ReplayShoot    = "shoot"
+	ReplayReady    = "ready"
+	ReplayUnready  = "unready"
+	ReplayKeyframe = "key"
+	ReplayFrame    = "frame"
+	ReplayEnd      = "end"
+)
+
+type ReplayHeader struct {
+	Format    string     `json:"format"`
+	Version   int        `json:"version"`
+	MatchID   string     `json:"matchId"`
+	RoomID    string     `json:"roomId"`
+	Seed      int64      `json:"seed"`
+	TickRate  float64    `json:"tickRate"` // physics ticks per second
+	StartedAt time.Time  `json:"startedAt"`
+	Initial   *GameState `json:"initial"` // tick 0, including who readied up
+}
+
+// ReplayEvent is one line after the header. Which fields are set depends on
+// Kind: input has X/Y as the movement vector, shoot has X/Y as the target,
+// ready and unready have only PlayerID, key has State, frame has Frame, and
+// end has WinnerID and Reason.
+type ReplayEvent struct {
+	Tick     int64           `json:"t"`
+	Kind     string          `json:"k"`
+	PlayerID string          `json:"p,omitempty"`
+	X        float64         `json:"x,omitempty"`
+	Y        float64         `json:"y,omitempty"`
+	State    *GameState      `json:"s,omitempty"`
+	Frame    json.RawMessage `json:"f,omitempty"` // a whole gameState message
+	WinnerID string          `json:"w,omitempty"`
+	Reason   string          `json:"r,omitempty"`
+}
+
+// ReplayStore is the directory replay files are written to, with a
+// retention policy applied after every write.
+type ReplayStore struct {
+	dir      string
+	maxAge   time.Duration // 0 keeps files regardless of age
+	maxFiles int           // 0 keeps any number of files
+	frames   bool          // also save every frame, for smooth playback from disk
+
+	mu      sync.Mutex
+	writing map[string]bool // partial files being written now
+}
+
+func OpenReplayStore(dir string, maxAge time.Duration, maxFiles int, frames bool) (*ReplayStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &ReplayStore{dir: dir, maxAge: maxAge, maxFiles: maxFiles, frames: frames, writing: make(map[string]bool)}
+	s.prune()
+	return s, nil
+}
+
+// Path is where the replay for a match is kept.
+func (s *ReplayStore) Path(matchID string) string {
+	return filepath.Join(s.dir, matchID+replayExt)
+}
+
+// prune deletes replays past the retention limits, oldest first, and
+// partial files left behind by a crash.
+func (s *ReplayStore) prune() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		slog.Error("Listing replays", "dir", s.dir, "err", err)
+		return
+	}
+	type replayFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []replayFile
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), replayExt+partialExt) {
+			s.removeOrphan(filepath.Join(s.dir, e.Name()))
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(e.Name(), replayExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, replayFile{filepath.Join(s.dir, e.Name()), info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	cutoff := time.Now().Add(-s.maxAge)
+	for i, f := range files {
+		tooMany := s.maxFiles > 0 && i >= s.maxFiles
+		tooOld := s.maxAge > 0 && f.modTime.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			slog.Warn("Removing old replay", "path", f.path, "err", err)
+		}
+	}
+}
+
+// removeOrphan deletes a partial file unless a writer still has it open.
+func (s *ReplayStore) removeOrphan(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writing[path] {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		slog.Warn("Removing partial replay", "path", path, "err", err)
+	}
+}
+
+// replayRecorder writes one round's replay on its own goroutine. Its methods
+// are called from the room with the room's write lock held.
+type replayRecorder struct {
+	matchID    string
+	events     chan ReplayEvent
+	frames     *ReplayLog // set by finish, read by the writer after events closes
+	overflowed bool
+
+	// The round's match, saved by the writer once it knows whether the
+	// replay was kept. Set by saveMatch before finish.
+	match   *MatchRecord
+	history *MatchRecorder
+}
+
+// startReplay begins recording a round.
+func (s *ReplayStore) startReplay(header ReplayHeader) *replayRecorder {
+	r := &replayRecorder{matchID: header.MatchID, events: make(chan ReplayEvent, ReplayQueueSize)}
+	go s.write(header, r)
+	return r
+}
+
+// add queues an event without ever blocking.
+func (r *replayRecorder) add(e ReplayEvent) {
+	if r.overflowed {
+		return
+	}
+	select {
+	case r.events <- e:
+	default:
+		r.overflowed = true
+		slog.Warn("Replay fell behind; dropping it", "match", r.matchID)
+	}
+}
+
+// finish ends the recording with an end event and the round's frames, or
+// with a nil end throws it away.
+func (r *replayRecorder) finish(end *ReplayEvent, frames *ReplayLog) {
+	if end != nil {
+		r.frames = frames
+		r.add(*end)
+	}
+	close(r.events)
+}
+
+func (s *ReplayStore) write(header ReplayHeader, r *replayRecorder) {
+	events := r.events
+	final := s.Path(header.MatchID)
+	partial := final + partialExt
+	complete := false
+	s.mu.Lock()
+	s.writing[partial] = true
+	s.mu.Unlock()
+	defer func() {
+		// Drain so a failed write never leaves the room's sends hanging
+		for range events {
+		}
+		if !complete {
+			os.Remove(partial)
+		}
+		s.mu.Lock()
+		delete(s.writing, partial)
+		s.mu.Unlock()
+		if r.match != nil {
+			r.match.Replay = complete
+			r.history.Record(*r.match)
+		}
+	}()
+
+	f, err := os.Create(partial)
+	if err != nil {
+		slog.Error("Creating replay", "path", partial, "err", err)
+		return
+	}
+	defer f.Close()
+	buf := bufio.NewWriter(f)
+	gz := gzip.NewWriter(buf)
+	enc := json.NewEncoder(gz)
+
+	if err := enc.Encode(header); err != nil {
+		slog.Error("Writing replay", "path", partial, "err", err)
+		return
+	}
+	var end *ReplayEvent
+	for e := range events {
+		if e.Kind == ReplayEnd {
+			end = &e
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			slog.Error("Writing replay", "path", partial, "err", err)
+			return
+		}
+	}
+	if end == nil {
+		// Aborted or overflowed
+		return
+	}
+	if s.frames && r.frames != nil {
+		for _, f := range r.frames.frames {
+			if err := enc.Encode(ReplayEvent{Tick: f.tick, Kind: ReplayFrame, Frame: f.data}); err != nil {
+				slog.Error("Writing replay", "path", partial, "err", err)
+				return
+			}
+		}
+	}
+	if err := enc.Encode(end); err != nil {
+		slog.Error("Writing replay", "path", partial, "err", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		slog.Error("Writing replay", "path", partial, "err", err)
+		return
+	}
+	if err := buf.Flush(); err != nil {
+		slog.Error("Writing replay", "path", partial, "err", err)
+		return
+	}
+	if err := f.Close(); err != nil {
+		slog.Error("Writing replay", "path", partial, "err", err)
+		return
+	}
+	if err := os.Rename(partial, final); err != nil {
+		slog.Error("Saving replay", "path", final, "err", err)
+		return
+	}
+	complete = true
+	slog.Info("Saved replay", "path", final, "ticks", end.Tick)
+	s.prune()
+}
+
+// recording reports whether both the server and the room have replay files
+// switched on. Every round keeps its snapshot log in memory either way.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) recording() bool {
+	return gr.hub.replays != nil && gr.record
+}
+
+// startRecording opens a replay for the round that is starting, if
+// recording is on. Caller must hold gr's write lock.
+func (gr *GameRoom) startRecording() {
+	gr.stopRecording(nil)
+	readyLog := gr.readyLog
+	gr.readyLog = nil
+	if !gr.recording() {
+		return
+	}
+	initial := gr.snapshot(time.Now())
+	gr.replay = gr.hub.replays.startReplay(ReplayHeader{
+		Format:    ReplayFormat,
+		Version:   ReplayVersion,
+		MatchID:   gr.matchID,
+		RoomID:    gr.ID,
+		Seed:      gr.seed,
+		TickRate:  float64(gr.cfg.TickRate),
+		StartedAt: gr.roundStart,
+		Initial:   &initial,
+	})
+	for _, e := range readyLog {
+		gr.replay.add(e)
+	}
+}
+
+// recordEvent adds an accepted action to the replay, if one is running.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) recordEvent(kind, playerID string, x, y float64) {
+	if gr.replay != nil {
+		gr.replay.add(ReplayEvent{Tick: gr.tick, Kind: kind, PlayerID: playerID, X: x, Y: y})
+	}
+}
+
+// recordReady adds a ready or unready action to the replay. These mostly
+// happen between rounds, when nothing is recording, so they are kept and
+// written at tick 0 of the next round's replay instead.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) recordReady(kind, playerID string) {
+	if gr.replay != nil {
+		gr.recordEvent(kind, playerID, 0, 0)
+		return
+	}
+	if gr.recording() && len(gr.readyLog) < ReplayReadyLogSize {
+		gr.readyLog = append(gr.readyLog, ReplayEvent{Kind: kind, PlayerID: playerID})
+	}
+}
+
+// recordKeyframe writes a full snapshot every ReplayKeyframeTicks.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) recordKeyframe() {
+	if gr.replay == nil || gr.tick%ReplayKeyframeTicks != 0 {
+		return
+	}
+	state := gr.snapshot(time.Now())
+	gr.replay.add(ReplayEvent{Tick: gr.tick, Kind: ReplayKeyframe, State: &state})
+}
+
+// stopRecording closes the round's replay and snapshot log, keeping them if
+// end is set and discarding them otherwise. Caller must hold gr's write lock.
+func (gr *GameRoom) stopRecording(end *ReplayEvent) {
+	frames := gr.frames
+	gr.frames = nil
+	if frames != nil && end != nil {
+		// The final frame shows the result
+		state := gr.snapshot(time.Now())
+		if data, err := json.Marshal(Message{Type: "gameState", Payload: state}); err == nil {
+			frames.add(gr.tick, data)
+		}
+		frames.seal()
+		gr.hub.replayLogs.add(frames)
+	}
+
+	if gr.replay == nil {
+		return
+	}
+	if end != nil {
+		end.Tick = gr.tick
+		end.Kind = ReplayEnd
+	}
+	gr.replay.finish(end, frames)
+	gr.replay = nil
+}
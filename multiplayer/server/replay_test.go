@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// writeReplay records one round through the store's writer and returns the
+// match it saves once the file has been kept or dropped.
+func writeReplay(t *testing.T, s *ReplayStore, events []ReplayEvent, frames *ReplayLog, overflow bool) MatchRecord {
+	t.Helper()
+	id := uuid.NewString()
+	r := s.startReplay(ReplayHeader{
+		Format:   ReplayFormat,
+		Version:  ReplayVersion,
+		MatchID:  id,
+		TickRate: 30,
+		Initial:  &GameState{State: StateInProgress},
+	})
+	history := NewMatchRecorder(NewMemoryMatchStore())
+	r.match = &MatchRecord{ID: id}
+	r.history = history
+	for _, e := range events {
+		r.add(e)
+	}
+	r.overflowed = overflow
+	r.finish(&ReplayEvent{Tick: 3, Kind: ReplayEnd, WinnerID: "a"}, frames)
+	select {
+	case m := <-history.queue:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay writer never saved the match")
+		return MatchRecord{}
+	}
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	key := GameState{State: StateInProgress, Seed: 7}
+	events := []ReplayEvent{
+		{Tick: 0, Kind: ReplayReady, PlayerID: "a"},
+		{Tick: 1, Kind: ReplayInput, PlayerID: "a", X: 1},
+		{Tick: 2, Kind: ReplayKeyframe, State: &key},
+	}
+	for _, tc := range []struct {
+		frames    bool
+		wantTicks []int64
+	}{
+		{frames: true, wantTicks: []int64{1, 2, 3}},
+		{frames: false, wantTicks: []int64{0, 2}}, // initial state and keyframe
+	} {
+		s, err := OpenReplayStore(t.TempDir(), 0, 0, tc.frames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := newReplayLog("", 30)
+		for tick := int64(1); tick <= 3; tick++ {
+			frames.add(tick, []byte(fmt.Sprintf(`{"type":"gameState","payload":{"tick":%d}}`, tick)))
+		}
+		frames.seal()
+
+		m := writeReplay(t, s, events, frames, false)
+		if !m.Replay {
+			t.Fatalf("frames=%v: match not flagged as having a replay", tc.frames)
+		}
+		l, err := s.load(m.ID)
+		if err != nil {
+			t.Fatalf("frames=%v: load: %v", tc.frames, err)
+		}
+		if l.TickRate != 30 {
+			t.Errorf("frames=%v: tickRate = %v, want 30", tc.frames, l.TickRate)
+		}
+		var ticks []int64
+		for _, f := range l.frames {
+			ticks = append(ticks, f.tick)
+		}
+		if fmt.Sprint(ticks) != fmt.Sprint(tc.wantTicks) {
+			t.Errorf("frames=%v: loaded ticks %v, want %v", tc.frames, ticks, tc.wantTicks)
+		}
+		if tc.frames {
+			for i, f := range l.frames {
+				if string(f.data) != string(frames.frames[i].data) {
+					t.Errorf("frame %d = %s, want %s", i, f.data, frames.frames[i].data)
+				}
+			}
+		}
+	}
+}
+
+func TestReplayDroppedIsNotFlagged(t *testing.T) {
+	s, err := OpenReplayStore(t.TempDir(), 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := writeReplay(t, s, []ReplayEvent{{Tick: 1, Kind: ReplayInput, PlayerID: "a"}}, nil, true)
+	if m.Replay {
+		t.Error("overflowed replay still flagged on its match")
+	}
+	if _, err := os.Stat(s.Path(m.ID)); !os.IsNotExist(err) {
+		t.Errorf("overflowed replay left a file behind: %v", err)
+	}
+	if _, err := os.Stat(s.Path(m.ID) + partialExt); !os.IsNotExist(err) {
+		t.Errorf("overflowed replay left a partial file behind: %v", err)
+	}
+}
+
+func TestReplayPruneOrphans(t *testing.T) {
+	dir := t.TempDir()
+	orphan := filepath.Join(dir, uuid.NewString()+replayExt+partialExt)
+	if err := os.WriteFile(orphan, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenReplayStore(dir, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphaned partial file survived opening the store: %v", err)
+	}
+
+	active := filepath.Join(dir, uuid.NewString()+replayExt+partialExt)
+	if err := os.WriteFile(active, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.writing[active] = true
+	s.prune()
+	if _, err := os.Stat(active); err != nil {
+		t.Errorf("partial file still being written was pruned: %v", err)
+	}
+}
+
+func TestReplayRetention(t *testing.T) {
+	for _, tc := range []struct {
+		maxAge   time.Duration
+		maxFiles int
+		kept     int
+	}{
+		{0, 0, 3},
+		{0, 2, 2},
+		{30 * time.Minute, 0, 1},
+	} {
+		dir := t.TempDir()
+		now := time.Now()
+		paths := make([]string, 3) // newest first, an hour apart
+		for i := range paths {
+			paths[i] = filepath.Join(dir, uuid.NewString()+replayExt)
+			if err := os.WriteFile(paths[i], nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			at := now.Add(-time.Duration(i) * time.Hour)
+			if err := os.Chtimes(paths[i], at, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := OpenReplayStore(dir, tc.maxAge, tc.maxFiles, false); err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range paths {
+			_, err := os.Stat(p)
+			if kept := err == nil; kept != (i < tc.kept) {
+				t.Errorf("maxAge %v, maxFiles %d: replay %d kept = %v", tc.maxAge, tc.maxFiles, i, kept)
+			}
+		}
+	}
+}
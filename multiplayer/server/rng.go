@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+
+	"github.com/google/uuid"
+)
+
+// MaxSeed keeps seeds within the integers a JSON number holds exactly, so a
+// seed read from a game state or match record can be passed back as is.
+const MaxSeed = 1<<53 - 1
+
+// validSeed reports whether seed can be used as a fixed seed. Zero means
+// no fixed seed.
+func validSeed(seed int64) bool {
+	return seed >= 0 && seed <= MaxSeed
+}
+
+// randomSeed picks a seed for a round that has no fixed one.
+func randomSeed() int64 {
+	return rand.Int64N(MaxSeed) + 1
+}
+
+// newRoundRNG returns the generator behind everything random in a round.
+// The same seed always gives the same sequence.
+func newRoundRNG(seed int64) *rand.ChaCha8 {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], uint64(seed))
+	return rand.NewChaCha8(key)
+}
+
+// seedRound picks the seed for the round that is starting and resets the
+// room's generator. Rooms with a fixed seed repeat the same sequence every
+// round. Caller must hold gr's write lock.
+func (gr *GameRoom) seedRound() {
+	if !gr.seedFixed {
+		gr.seed = randomSeed()
+	}
+	gr.rng = newRoundRNG(gr.seed)
+	gr.log.Info("Seeded round", "match", gr.matchID, "seed", gr.seed)
+}
+
+// newID returns a UUID drawn from the round's generator, so IDs come out
+// the same when a round is replayed with its seed.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) newID() string {
+	return uuid.Must(uuid.NewRandomFromReader(gr.rng)).String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// roundIDs starts a round in room and returns the first IDs it hands out.
+func roundIDs(room *GameRoom) string {
+	room.startGame()
+	ids := make([]string, 5)
+	for i := range ids {
+		ids[i] = room.newID()
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestRoundRNGIsDeterministic(t *testing.T) {
+	h := NewHub()
+	a := NewGameRoom("a", "ABCDEF", h, RoomOptions{Seed: 42})
+	b := NewGameRoom("b", "GHJKLM", h, RoomOptions{Seed: 42})
+	first := roundIDs(a)
+	if got := roundIDs(b); got != first {
+		t.Errorf("rooms with the same seed drew different IDs:\n%s\n%s", first, got)
+	}
+	if got := roundIDs(a); got != first || a.seed != 42 {
+		t.Errorf("fixed-seed room's next round drew %s with seed %d, want a repeat of the first", got, a.seed)
+	}
+	other := NewGameRoom("c", "NPQRST", h, RoomOptions{Seed: 43})
+	if roundIDs(other) == first {
+		t.Error("different seeds drew the same IDs")
+	}
+
+	// Without a fixed seed every round picks a new, valid one
+	free := NewGameRoom("d", "UVWXYZ", h, RoomOptions{})
+	ids := roundIDs(free)
+	seed := free.seed
+	roundIDs(free)
+	if free.seed == seed || seed == 0 || !validSeed(seed) || !validSeed(free.seed) {
+		t.Errorf("unseeded rounds got seeds %d and %d", seed, free.seed)
+	}
+
+	// A room given the seed a round recorded draws that round's IDs again
+	replay := NewGameRoom("e", "ABCDEF", h, RoomOptions{Seed: seed})
+	if got := roundIDs(replay); got != ids {
+		t.Errorf("replaying seed %d drew %s, want %s", seed, got, ids)
+	}
+}
+
+func TestValidSeed(t *testing.T) {
+	for _, tc := range []struct {
+		seed int64
+		ok   bool
+	}{
+		{0, true}, // no fixed seed
+		{1, true},
+		{MaxSeed, true},
+		{MaxSeed + 1, false},
+		{-1, false},
+	} {
+		if got := validSeed(tc.seed); got != tc.ok {
+			t.Errorf("validSeed(%d) = %v, want %v", tc.seed, got, tc.ok)
+		}
+	}
+}
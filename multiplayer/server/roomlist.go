@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultRoomPageSize = 20
+	MaxRoomPageSize     = 100
+)
+
+// Room list sort orders
+const (
+	SortNewest  = "newest"
+	SortOldest  = "oldest"
+	SortPlayers = "players" // fullest first
+	SortRating  = "rating"  // highest average rating first
+	SortName    = "name"
+)
+
+var validSorts = map[string]bool{
+	SortNewest: true, SortOldest: true, SortPlayers: true, SortRating: true, SortName: true,
+}
+
+var errBadCursor = errors.New("invalid cursor")
+
+// RoomQuery is a lobby client's view of the room list, set with list_rooms.
+type RoomQuery struct {
+	JoinableOnly bool
+	Mode         string
+	Map          string
+	HasPassword  *bool // nil means either
+	Sort         string
+	Text         string
+	Cursor       string
+	Limit        int
+}
+
+// RoomPage is the answer to a RoomQuery.
+type RoomPage struct {
+	Rooms      []RoomInfo `json:"rooms"`
+	Total      int        `json:"total"` // rooms matching the filters across all pages
+	Cursor     string     `json:"cursor,omitempty"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// roomCursor marks the last room of the previous page. It carries every
+// field any sort order looks at, so a page stays stable while rooms come and
+// go around it.
+type roomCursor struct {
+	Sort        string `json:"s"`
+	ID          string `json:"i"`
+	Name        string `json:"n,omitempty"`
+	PlayerCount int    `json:"p,omitempty"`
+	Rating      int    `json:"r,omitempty"`
+	CreatedAt   int64  `json:"c,omitempty"`
+}
+
+// lobbyView remembers what a list_rooms client is looking at, so room list
+// changes are only pushed when something inside its page changes.
+type lobbyView struct {
+	query    RoomQuery
+	lastSent []byte // encoded rooms of the last page pushed
+}
+
+// refresh computes the page for the current room list and reports whether it
+// differs from what the client already has.
+func (v *lobbyView) refresh(all []RoomInfo) (RoomPage, bool) {
+	page, err := v.query.apply(all)
+	if err != nil {
+		return page, false
+	}
+	key, _ := json.Marshal(struct {
+		Rooms   []RoomInfo
+		HasMore bool
+	}{page.Rooms, page.NextCursor != ""})
+	if bytes.Equal(key, v.lastSent) {
+		return page, false
+	}
+	v.lastSent = key
+	return page, true
+}
+
+// parseRoomQuery reads a list_rooms payload. All fields are optional.
+func parseRoomQuery(payloadMap map[string]interface{}) (RoomQuery, string, string) {
+	q := RoomQuery{Sort: SortNewest, Limit: DefaultRoomPageSize}
+	if payloadMap == nil {
+		return q, "", ""
+	}
+	q.JoinableOnly, _ = payloadMap["joinable"].(bool)
+	q.Mode, _ = payloadMap["mode"].(string)
+	q.Map, _ = payloadMap["map"].(string)
+	if hasPassword, ok := payloadMap["hasPassword"].(bool); ok {
+		q.HasPassword = &hasPassword
+	}
+	if s, ok := payloadMap["sort"].(string); ok && s != "" {
+		if !validSorts[s] {
+			return q, ErrCodeInvalidRequest, "Unknown sort order"
+		}
+		q.Sort = s
+	}
+	if text, ok := payloadMap["query"].(string); ok {
+		q.Text = strings.ToLower(strings.TrimSpace(text))
+	}
+	q.Cursor, _ = payloadMap["cursor"].(string)
+	if limit, ok := payloadMap["limit"].(float64); ok && limit > 0 {
+		q.Limit = int(limit)
+		if q.Limit > MaxRoomPageSize {
+			q.Limit = MaxRoomPageSize
+		}
+	}
+	return q, "", ""
+}
+
+// joinable reports whether a lobby client could join this room right now.
+func (ri RoomInfo) joinable() bool {
+	return ri.PlayerCount+ri.Reserved < ri.MaxPlayers && !ri.Locked
+}
+
+func (q RoomQuery) matches(ri RoomInfo) bool {
+	if q.JoinableOnly && !ri.joinable() {
+		return false
+	}
+	if q.Mode != "" && ri.Mode != q.Mode {
+		return false
+	}
+	if q.Map != "" && ri.Map != q.Map {
+		return false
+	}
+	if q.HasPassword != nil && ri.HasPassword != *q.HasPassword {
+		return false
+	}
+	if q.Text != "" &&
+		!strings.Contains(strings.ToLower(ri.Name), q.Text) &&
+		!strings.Contains(strings.ToLower(ri.Code), q.Text) &&
+		!strings.HasPrefix(ri.ID, q.Text) {
+		return false
+	}
+	return true
+}
+
+// roomLess orders rooms for a sort; ties always fall back to ID so the
+// order is total and cursors never skip or repeat a room.
+func roomLess(sortBy string, a, b roomCursor) bool {
+	switch sortBy {
+	case SortOldest:
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+	case SortPlayers:
+		if a.PlayerCount != b.PlayerCount {
+			return a.PlayerCount > b.PlayerCount
+		}
+	case SortRating:
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+	case SortName:
+		an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name)
+		if an != bn {
+			return an < bn
+		}
+	default: // SortNewest
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt > b.CreatedAt
+		}
+	}
+	return a.ID < b.ID
+}
+
+func cursorFor(sortBy string, ri RoomInfo) roomCursor {
+	return roomCursor{
+		Sort:        sortBy,
+		ID:          ri.ID,
+		Name:        ri.Name,
+		PlayerCount: ri.PlayerCount,
+		Rating:      ri.Rating,
+		CreatedAt:   ri.CreatedAt,
+	}
+}
+
+func encodeCursor(c roomCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (roomCursor, error) {
+	var c roomCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errBadCursor
+	}
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == "" {
+		return c, errBadCursor
+	}
+	return c, nil
+}
+
+// apply filters, sorts and pages the full room list.
+func (q RoomQuery) apply(all []RoomInfo) (RoomPage, error) {
+	var after *roomCursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort {
+			return RoomPage{}, errBadCursor
+		}
+		after = &c
+	}
+
+	matched := make([]RoomInfo, 0, len(all))
+	for _, ri := range all {
+		if q.matches(ri) {
+			matched = append(matched, ri)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return roomLess(q.Sort, cursorFor(q.Sort, matched[i]), cursorFor(q.Sort, matched[j]))
+	})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return roomLess(q.Sort, *after, cursorFor(q.Sort, matched[i]))
+		})
+	}
+	end := start + q.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	page := RoomPage{
+		Rooms:  matched[start:end],
+		Total:  len(matched),
+		Cursor: q.Cursor,
+	}
+	if end < len(matched) {
+		page.NextCursor = encodeCursor(cursorFor(q.Sort, matched[end-1]))
+	}
+	return page, nil
+}
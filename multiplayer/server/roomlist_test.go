@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// lobbyWsClient puts a client in h's lobby whose messages can be read back
+// from the returned connection, the way a browser would see them.
+func lobbyWsClient(t *testing.T, h *Hub) (*ClientConn, *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(srv.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	c := &ClientConn{
+		id:   uuid.NewString(),
+		hub:  h,
+		conn: <-accepted,
+		send: make(chan *websocket.PreparedMessage, 64),
+		done: make(chan struct{}),
+	}
+	c.identity = c.id
+	h.assignGuestName(c)
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+	go c.writePump()
+	t.Cleanup(func() { close(c.done) })
+	return c, ws
+}
+
+type listEvent struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Seq     uint64          `json:"seq"`
+}
+
+// readEvents reads n messages, failing if they do not arrive promptly.
+func readEvents(t *testing.T, ws *websocket.Conn, n int) []listEvent {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	events := make([]listEvent, n)
+	for i := range events {
+		if err := ws.ReadJSON(&events[i]); err != nil {
+			t.Fatalf("reading event %d of %d: %v", i+1, n, err)
+		}
+	}
+	return events
+}
+
+func TestRoomListEventsAreGapFree(t *testing.T) {
+	h := NewHub()
+	c, ws := lobbyWsClient(t, h)
+
+	h.mu.Lock()
+	first := h.addRoom(RoomOptions{})
+	second := h.addRoom(RoomOptions{})
+	hidden := h.addRoom(RoomOptions{Private: true})
+	h.mu.Unlock()
+	for _, room := range []*GameRoom{first, second, hidden} {
+		h.roomChanged(room)
+	}
+	h.publishRoomChanges()
+
+	first.Lock()
+	first.State = StateInProgress
+	first.Unlock()
+	h.roomChanged(first)
+	h.roomChanged(second) // unchanged, so no event
+	h.publishRoomChanges()
+
+	h.mu.Lock()
+	delete(h.rooms, second.ID)
+	delete(h.roomCodes, second.Code)
+	h.mu.Unlock()
+	h.roomChanged(second)
+	h.publishRoomChanges()
+
+	// Apply the events the way a client does, checking each seq follows on
+	rooms := make(map[string]RoomInfo)
+	var kinds []string
+	var last uint64
+	for _, ev := range readEvents(t, ws, 4) {
+		if ev.Seq != last+1 {
+			t.Fatalf("%s has seq %d after %d", ev.Type, ev.Seq, last)
+		}
+		last = ev.Seq
+		kinds = append(kinds, ev.Type)
+		var info RoomInfo
+		if err := json.Unmarshal(ev.Payload, &info); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type == "room_removed" {
+			delete(rooms, info.ID)
+		} else {
+			rooms[info.ID] = info
+		}
+	}
+	if got := strings.Join(kinds, " "); got != "room_added room_added room_updated room_removed" {
+		t.Errorf("events = %s", got)
+	}
+	if _, ok := rooms[hidden.ID]; ok {
+		t.Error("private room was published")
+	}
+	if rooms[first.ID].State != StateInProgress || len(rooms) != 1 {
+		t.Errorf("client's list after the events = %+v, want just the first room, in progress", rooms)
+	}
+
+	// A resync snapshot carries the last seq and matches the applied events
+	h.sendRoomList(c)
+	snap := readEvents(t, ws, 1)[0]
+	if snap.Type != "room_list" || snap.Seq != last {
+		t.Fatalf("resync sent %s with seq %d, want room_list with seq %d", snap.Type, snap.Seq, last)
+	}
+	var list []RoomInfo
+	if err := json.Unmarshal(snap.Payload, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(rooms) || list[0] != rooms[list[0].ID] {
+		t.Errorf("snapshot %+v differs from the list built from events %+v", list, rooms)
+	}
+
+	// The next change carries on from the snapshot's seq
+	h.mu.Lock()
+	third := h.addRoom(RoomOptions{})
+	h.mu.Unlock()
+	h.roomChanged(third)
+	h.publishRoomChanges()
+	if ev := readEvents(t, ws, 1)[0]; ev.Type != "room_added" || ev.Seq != last+1 {
+		t.Errorf("after resync got %s with seq %d, want room_added with seq %d", ev.Type, ev.Seq, last+1)
+	}
+}
+
+// queryRooms is the list RoomQuery tests run against. e5 ties b2 on age and
+// a1 on rating, and d4's name differs from a1's only in case, so every sort
+// needs its ID tie-break.
+var queryRooms = []RoomInfo{
+	{ID: "a1", Code: "ALPHA1", Name: "Room by Ann", PlayerCount: 1, MaxPlayers: 2, Mode: ModeClassic, Map: MapArena, Rating: 1500, CreatedAt: 100},
+	{ID: "b2", Code: "BRAVO2", Name: "Room by bob", PlayerCount: 2, MaxPlayers: 2, Mode: ModeClassic, Map: MapArena, HasPassword: true, Rating: 1700, CreatedAt: 300},
+	{ID: "c3", Code: "CHARL3", Name: "Room by Cat", MaxPlayers: 2, Reserved: 1, Locked: true, Mode: ModeClassic, Map: "ruins", Rating: 1400, CreatedAt: 150},
+	{ID: "d4", Code: "DELTA4", Name: "room by ann", PlayerCount: 1, MaxPlayers: 2, Reserved: 1, Mode: "duel", Map: MapArena, Rating: 1600, CreatedAt: 200},
+	{ID: "e5", Code: "ECHO55", Name: "Room by Eve", MaxPlayers: 2, Mode: ModeClassic, Map: MapArena, Rating: 1500, CreatedAt: 300},
+}
+
+func pageIDs(p RoomPage) string {
+	var out []string
+	for _, ri := range p.Rooms {
+		out = append(out, ri.ID)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestRoomQueryFilters(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		name string
+		q    RoomQuery
+		want string
+	}{
+		{"none", RoomQuery{}, "b2 e5 d4 c3 a1"},
+		{"joinable", RoomQuery{JoinableOnly: true}, "e5 a1"}, // b2 full, c3 locked, d4 full with its hold
+		{"mode", RoomQuery{Mode: "duel"}, "d4"},
+		{"map", RoomQuery{Map: "ruins"}, "c3"},
+		{"password", RoomQuery{HasPassword: &yes}, "b2"},
+		{"no password", RoomQuery{HasPassword: &no}, "e5 d4 c3 a1"},
+		{"name", RoomQuery{Text: "ann"}, "d4 a1"},
+		{"code", RoomQuery{Text: "bravo"}, "b2"},
+		{"id prefix", RoomQuery{Text: "e5"}, "e5"},
+		{"no match", RoomQuery{Text: "zed"}, ""},
+		{"combined", RoomQuery{JoinableOnly: true, HasPassword: &no, Mode: ModeClassic, Text: "room"}, "e5 a1"},
+	} {
+		tc.q.Sort, tc.q.Limit = SortNewest, MaxRoomPageSize
+		page, err := tc.q.apply(queryRooms)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := pageIDs(page); got != tc.want || page.Total != len(page.Rooms) {
+			t.Errorf("%s: rooms %q (total %d), want %q", tc.name, got, page.Total, tc.want)
+		}
+	}
+}
+
+func TestRoomQuerySorts(t *testing.T) {
+	for _, tc := range []struct {
+		sort string
+		want string
+	}{
+		{SortNewest, "b2 e5 d4 c3 a1"},
+		{SortOldest, "a1 c3 d4 b2 e5"},
+		{SortPlayers, "b2 a1 d4 c3 e5"},
+		{SortRating, "b2 d4 a1 e5 c3"},
+		{SortName, "a1 d4 b2 c3 e5"},
+	} {
+		page, err := RoomQuery{Sort: tc.sort, Limit: MaxRoomPageSize}.apply(queryRooms)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.sort, err)
+		}
+		if got := pageIDs(page); got != tc.want {
+			t.Errorf("sort %s: %q, want %q", tc.sort, got, tc.want)
+		}
+
+		// Walking the pages two at a time gives the same order
+		var walked []string
+		q := RoomQuery{Sort: tc.sort, Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(queryRooms) {
+				t.Fatalf("sort %s: cursor never ran out", tc.sort)
+			}
+			page, err := q.apply(queryRooms)
+			if err != nil {
+				t.Fatalf("sort %s, cursor %q: %v", tc.sort, q.Cursor, err)
+			}
+			if page.Total != len(queryRooms) || page.Cursor != q.Cursor {
+				t.Errorf("sort %s: page has total %d, cursor %q", tc.sort, page.Total, page.Cursor)
+			}
+			walked = append(walked, pageIDs(page))
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		if got := strings.Join(walked, " "); got != tc.want {
+			t.Errorf("sort %s across pages: %q, want %q", tc.sort, got, tc.want)
+		}
+	}
+}
+
+func TestRoomQueryCursor(t *testing.T) {
+	q := RoomQuery{Sort: SortNewest, Limit: 2}
+	first, _ := q.apply(queryRooms)
+	if pageIDs(first) != "b2 e5" || first.NextCursor == "" {
+		t.Fatalf("first page = %q, next %q", pageIDs(first), first.NextCursor)
+	}
+
+	// The room the cursor points at closes and a newer one opens; the next
+	// page still starts right after where the first one ended
+	changed := []RoomInfo{{ID: "f6", Name: "Room by Fay", MaxPlayers: 2, CreatedAt: 400}}
+	for _, ri := range queryRooms {
+		if ri.ID != "e5" {
+			changed = append(changed, ri)
+		}
+	}
+	q.Cursor = first.NextCursor
+	second, err := q.apply(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pageIDs(second); got != "d4 c3" {
+		t.Errorf("page after the cursor = %q, want d4 c3", got)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		cursor string
+	}{
+		{"other sort", encodeCursor(roomCursor{Sort: SortOldest, ID: "a1"})},
+		{"no room", encodeCursor(roomCursor{Sort: SortNewest})},
+		{"not base64", "!!!"},
+		{"not json", "bm90IGpzb24"},
+	} {
+		if _, err := (RoomQuery{Sort: SortNewest, Limit: 2, Cursor: tc.cursor}).apply(queryRooms); err != errBadCursor {
+			t.Errorf("%s cursor: err = %v, want errBadCursor", tc.name, err)
+		}
+	}
+}
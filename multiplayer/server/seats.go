@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+)
+
+// SeatHoldTimeout is how long an accepted joiner has to take their seat
+// before it is offered to someone else.
+const SeatHoldTimeout = 10 * time.Second
+
+// joinRequest asks a room's goroutine for a seat. The room answers on result
+// exactly once.
+type joinRequest struct {
+	client   *ClientConn
+	password string
+	result   chan joinResult
+}
+
+// joinResult is a room's answer to a joinRequest. An empty code means a
+// seat is now held for the client and it may register.
+type joinResult struct {
+	code    string
+	message string
+}
+
+// holdSeat reserves a seat for a player until they register or the hold
+// expires. Caller must hold gr's write lock, or own gr before Run starts.
+func (gr *GameRoom) holdSeat(playerID string) {
+	gr.holds[playerID] = time.Now().Add(SeatHoldTimeout)
+}
+
+// seatsTaken counts seated players and outstanding holds.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) seatsTaken() int {
+	return len(gr.players) + len(gr.holds)
+}
+
+// isEmpty reports whether nobody is in the room or on their way in.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) isEmpty() bool {
+	return len(gr.clients) == 0 && len(gr.holds) == 0
+}
+
+// removeClient takes client out of the room, resetting a round it leaves
+// with too few players. It reports whether client was in the room and
+// whether the room is now empty. Only the room goroutine calls it.
+func (gr *GameRoom) removeClient(client *ClientConn) (removed, empty bool) {
+	gr.Lock()
+	defer gr.Unlock()
+	if _, ok := gr.clients[client]; !ok {
+		return false, false
+	}
+
+	wasInProgress := gr.inRound() || gr.State == StateGameOver
+
+	delete(gr.clients, client)
+	if client.player != nil && gr.players[client.player.ID] == client.player {
+		gr.log.Info("Player removed from room", "client", client, "color", client.player.Color, "state", gr.State)
+		gr.dropPlayer(client.player.ID)
+	}
+
+	client.roomMu.Lock()
+	client.room = nil
+	client.player = nil
+	client.roomMu.Unlock()
+
+	if wasInProgress && len(gr.players) < 2 {
+		gr.log.Info("Player left mid-game; resetting room to waiting", "state", gr.State)
+		gr.resetGame()
+	}
+	return true, gr.isEmpty()
+}
+
+// admit accepts or rejects a join request. Only the room goroutine calls it,
+// so the capacity check and the hold happen as one step.
+func (gr *GameRoom) admit(req joinRequest) joinResult {
+	gr.Lock()
+	defer gr.Unlock()
+
+	id := req.client.id
+	switch {
+	case gr.kicked[req.client.identity]:
+		return joinResult{ErrCodeKicked, "You were removed from this room"}
+	case gr.hasPassword && req.password == "":
+		return joinResult{ErrCodePasswordRequired, "This room requires a password"}
+	case gr.hasPassword && !gr.checkPassword(req.password):
+		return joinResult{ErrCodeWrongPassword, "Wrong password"}
+	case gr.locked:
+		return joinResult{ErrCodeRoomLocked, "Room is locked"}
+	}
+	if _, held := gr.holds[id]; !held && gr.seatsTaken() >= gr.cfg.MaxPlayers {
+		return joinResult{ErrCodeRoomFull, "Room is full"}
+	}
+	gr.holdSeat(id)
+	return joinResult{}
+}
+
+// expireHolds drops holds that were never claimed and reports whether any were.
+// Caller must hold gr's write lock.
+func (gr *GameRoom) expireHolds(now time.Time) bool {
+	expired := false
+	for id, until := range gr.holds {
+		if now.After(until) {
+			gr.log.Info("Seat hold expired", "player", id)
+			delete(gr.holds, id)
+			expired = true
+		}
+	}
+	return expired
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// newLobbyClient returns a connectionless client already sitting in h's lobby.
+func newLobbyClient(h *Hub) *ClientConn {
+	c := &ClientConn{
+		id:   uuid.NewString(),
+		hub:  h,
+		send: make(chan *websocket.PreparedMessage, 256),
+		done: make(chan struct{}),
+	}
+	c.identity = c.id
+	h.assignGuestName(c)
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+	return c
+}
+
+// TestConcurrentJoinsNeverOverfill fires many joins at one room at the same
+// instant; exactly MaxPlayersPerRoom must get in and the rest stay in the lobby.
+func TestConcurrentJoinsNeverOverfill(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	go h.matchmaker.Run()
+
+	h.mu.Lock()
+	room := h.addRoom(RoomOptions{})
+	h.mu.Unlock()
+	go room.Run()
+
+	const joiners = 64
+	clients := make([]*ClientConn, joiners)
+	for i := range clients {
+		clients[i] = newLobbyClient(h)
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		ref := room.ID
+		if i%2 == 1 {
+			ref = room.Code
+		}
+		go func(c *ClientConn, ref string) {
+			defer wg.Done()
+			<-start
+			h.joinRoom(c, ref, "")
+		}(c, ref)
+	}
+	close(start)
+	wg.Wait()
+
+	// Registration finishes on the room goroutine; wait for every hold to be claimed
+	deadline := time.Now().Add(2 * time.Second)
+	var players, holds int
+	for time.Now().Before(deadline) {
+		room.RLock()
+		players, holds = len(room.players), len(room.holds)
+		room.RUnlock()
+		if holds == 0 && players == MaxPlayersPerRoom {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if players != MaxPlayersPerRoom || holds != 0 {
+		t.Fatalf("room has %d players and %d holds, want %d and 0", players, holds, MaxPlayersPerRoom)
+	}
+
+	seated := 0
+	h.mu.RLock()
+	for _, c := range clients {
+		c.roomMu.Lock()
+		inRoom := c.room == room
+		c.roomMu.Unlock()
+		if inRoom {
+			seated++
+			if h.clients[c] {
+				t.Errorf("client %s is both in the room and in the lobby", c)
+			}
+		} else if !h.clients[c] {
+			t.Errorf("rejected client %s was not left in the lobby", c)
+		}
+	}
+	h.mu.RUnlock()
+	if seated != MaxPlayersPerRoom {
+		t.Fatalf("%d clients think they are in the room, want %d", seated, MaxPlayersPerRoom)
+	}
+}
+
+func TestSeatHoldsExpire(t *testing.T) {
+	room := NewGameRoom("room", "ABCDEF", NewHub(), RoomOptions{})
+	room.holdSeat("a")
+	room.holdSeat("b")
+
+	if room.expireHolds(time.Now()) {
+		t.Fatal("fresh holds expired")
+	}
+	if got := room.admit(joinRequest{client: &ClientConn{id: "c"}}); got.code != ErrCodeRoomFull {
+		t.Fatalf("join with every seat held: got %q, want %q", got.code, ErrCodeRoomFull)
+	}
+	if !room.expireHolds(time.Now().Add(SeatHoldTimeout + time.Second)) {
+		t.Fatal("stale holds did not expire")
+	}
+	if got := room.admit(joinRequest{client: &ClientConn{id: "c"}}); got.code != "" {
+		t.Fatalf("join after holds expired: got %q, want a seat", got.code)
+	}
+}
+
+// TestDisconnectedClientIsNotSeated registers a client whose connection closed
+// between its seat being held and the room taking it in.
+func TestDisconnectedClientIsNotSeated(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	ghost, live := newLobbyClient(h), newLobbyClient(h)
+
+	h.mu.Lock()
+	room := h.addRoom(RoomOptions{})
+	room.holdSeat(ghost.id)
+	room.holdSeat(live.id)
+	h.mu.Unlock()
+	go room.Run()
+
+	close(ghost.done)
+	room.register <- ghost
+	room.register <- live
+
+	// The room takes registrations in order, so once live is seated the
+	// ghost has been dealt with
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		live.roomMu.Lock()
+		seated := live.room == room
+		live.roomMu.Unlock()
+		if seated {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("live client was never seated")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	room.RLock()
+	_, held := room.holds[ghost.id]
+	_, player := room.players[ghost.id]
+	inRoom := room.clients[ghost]
+	room.RUnlock()
+	if held || player || inRoom {
+		t.Errorf("disconnected client kept its place: hold %v, player %v, client %v", held, player, inRoom)
+	}
+	ghost.roomMu.Lock()
+	defer ghost.roomMu.Unlock()
+	if ghost.room != nil {
+		t.Error("disconnected client was told it is in the room")
+	}
+}
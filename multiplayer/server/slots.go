@@ -0,0 +1,49 @@
+package main
+
+// spawnPoint is where a side starts. Even sides start on the left and odd
+// ones on the right; when a room holds more than two players each half is
+// split into evenly spaced lanes, so no two sides in a run of MaxPlayers
+// share a spot. With two players both start vertically centered.
+func (g GameConfig) spawnPoint(side int) Vector2D {
+	lanes := (g.MaxPlayers + 1) / 2
+	if lanes < 1 {
+		lanes = 1
+	}
+	lane := (side / 2) % lanes
+	y := g.CanvasHeight*float64(lane+1)/float64(lanes+1) - g.PlayerHeight/2
+	if side%2 == 0 {
+		return Vector2D{X: g.CanvasWidth * 0.15, Y: y}
+	}
+	return Vector2D{X: g.CanvasWidth * 0.80, Y: y}
+}
+
+// freeSlot returns the lowest slot index no player in the room is using.
+// Slots are kept for as long as a player stays, so spawn side and color
+// never depend on map iteration order. Caller must hold gr's write lock.
+func (gr *GameRoom) freeSlot() int {
+	used := make(map[int]bool, len(gr.players))
+	for _, p := range gr.players {
+		used[p.Slot] = true
+	}
+	slot := 0
+	for used[slot] {
+		slot++
+	}
+	return slot
+}
+
+// slotColor gives every slot its own color.
+func slotColor(slot int) string {
+	return playerColors[slot%len(playerColors)]
+}
+
+// spawnFor returns where the player in slot starts the current round. With
+// swapSides set, the sides alternate every round.
+// Caller must hold at least a read lock on gr.
+func (gr *GameRoom) spawnFor(slot int) Vector2D {
+	side := slot
+	if gr.swapSides {
+		side += gr.round
+	}
+	return gr.cfg.spawnPoint(side)
+}